@@ -0,0 +1,209 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// AMIResolver returns an AMI ID which new instances are launched with.
+// An empty string means that AMI is not determined yet.
+type AMIResolver interface {
+	Resolve(ctx context.Context) (string, error)
+}
+
+type AMIResolverConfig struct {
+	Type    string     `yaml:"Type" validate:"required,eq=command|eq=latest|eq=pinned"`
+	Command *Command   `yaml:"Command"`
+	Owners  []string   `yaml:"Owners"`
+	Filters EC2Filters `yaml:"Filters" validate:"dive"`
+}
+
+func (c *AMIResolverConfig) Validate() error {
+	switch c.Type {
+	case "command":
+		if c.Command == nil {
+			return fmt.Errorf("AMIResolver.Command is required for command resolver")
+		}
+	case "latest":
+		if len(c.Owners) == 0 && len(c.Filters) == 0 {
+			return fmt.Errorf("AMIResolver.Owners or AMIResolver.Filters is required for latest resolver")
+		}
+	}
+	return nil
+}
+
+// NewAMIResolvers returns AMIResolvers configured at top level and in launch overrides.
+// Keys of the result are ones returned by Config.AMISourceKeyFor.
+func NewAMIResolvers(config *Config, ec2Client EC2ClientIface, status StatusStoreIface) (map[string]AMIResolver, error) {
+	resolvers := map[string]AMIResolver{}
+
+	r, err := newAMIResolver("", config.AMICommand, config.AMIResolver, ec2Client, status)
+	if err != nil {
+		return nil, err
+	}
+	resolvers[""] = r
+
+	for a, o := range config.LaunchOverridesByArchitecture {
+		if !o.hasAMI() {
+			continue
+		}
+		key := fmt.Sprintf("arch:%s", a)
+		r, err := newAMIResolver(key, o.AMICommand, o.AMIResolver, ec2Client, status)
+		if err != nil {
+			return nil, err
+		}
+		resolvers[key] = r
+	}
+
+	for t, o := range config.LaunchOverridesByType {
+		if !o.hasAMI() {
+			continue
+		}
+		key := fmt.Sprintf("type:%s", t)
+		r, err := newAMIResolver(key, o.AMICommand, o.AMIResolver, ec2Client, status)
+		if err != nil {
+			return nil, err
+		}
+		resolvers[key] = r
+	}
+
+	return resolvers, nil
+}
+
+func newAMIResolver(key string, command *Command, c *AMIResolverConfig, ec2Client EC2ClientIface, status StatusStoreIface) (AMIResolver, error) {
+	if c == nil {
+		if command == nil {
+			return nil, fmt.Errorf("either AMIResolver or AMICommand is required")
+		}
+		return &CommandAMIResolver{Command: *command}, nil
+	}
+
+	switch c.Type {
+	case "command":
+		return &CommandAMIResolver{Command: *c.Command}, nil
+	case "latest":
+		return &LatestImageAMIResolver{ec2Client: ec2Client, Owners: c.Owners, Filters: c.Filters}, nil
+	case "pinned":
+		return &PinnedAMIResolver{status: status, Key: key}, nil
+	}
+
+	return nil, fmt.Errorf("unknown AMI resolver type: %s", c.Type)
+}
+
+// CommandAMIResolver resolves AMI from stdout of a command
+type CommandAMIResolver struct {
+	Command Command
+}
+
+func (r *CommandAMIResolver) Resolve(ctx context.Context) (string, error) {
+	return r.Command.Output(ctx, []string{})
+}
+
+// LatestImageAMIResolver resolves the newest AMI matching owners and filters
+type LatestImageAMIResolver struct {
+	ec2Client EC2ClientIface
+	Owners    []string
+	Filters   EC2Filters
+}
+
+func (r *LatestImageAMIResolver) Resolve(ctx context.Context) (string, error) {
+	image, err := r.ec2Client.DescribeLatestImage(ctx, r.Owners, r.Filters)
+	if err != nil {
+		return "", err
+	}
+	if image == nil {
+		return "", nil
+	}
+	return *image.ImageId, nil
+}
+
+// PinnedAMIResolver resolves AMI stored in status store (e.g. via HTTP API)
+type PinnedAMIResolver struct {
+	status StatusStoreIface
+	Key    string
+}
+
+func (r *PinnedAMIResolver) Resolve(ctx context.Context) (string, error) {
+	return r.status.FetchPinnedAMI(r.Key)
+}
+
+// ResolveAvailableAMI resolves AMI and validates that the image is available
+func ResolveAvailableAMI(ctx context.Context, resolver AMIResolver, ec2Client EC2ClientIface) (string, error) {
+	ami, err := resolver.Resolve(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ami == "" {
+		return "", nil
+	}
+
+	image, err := ec2Client.DescribeImage(ctx, ami)
+	if err != nil {
+		return "", err
+	}
+	if image == nil {
+		return "", fmt.Errorf("AMI %s is not found", ami)
+	}
+	if image.State == nil || *image.State != "available" {
+		return "", fmt.Errorf("AMI %s is not available (state: %s)", ami, aws.StringValue(image.State))
+	}
+
+	logf(ctx, "[DEBUG] resolved AMI: %s", ami)
+	return ami, nil
+}
+
+// VarietyAMIs represents AMI IDs new instances of each variety are launched with
+type VarietyAMIs map[InstanceVariety]string
+
+// Distinct returns sorted AMI IDs without duplication
+func (a VarietyAMIs) Distinct() []string {
+	found := map[string]bool{}
+	amis := []string{}
+	for _, ami := range a {
+		if ami != "" && !found[ami] {
+			found[ami] = true
+			amis = append(amis, ami)
+		}
+	}
+	sort.Strings(amis)
+	return amis
+}
+
+// ReadyFor returns true if AMIs for all varieties to be launched in change are determined
+func (a VarietyAMIs) ReadyFor(change map[InstanceVariety]int64) bool {
+	for v, c := range change {
+		if c > 0 && a[v] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveAMIs resolves AMIs for varieties. An AMI which is not determined yet is an empty string.
+func (r *Runner) resolveAMIs(ctx context.Context, vs []InstanceVariety) (VarietyAMIs, error) {
+	resolved := map[string]string{}
+	amis := VarietyAMIs{}
+	for _, v := range vs {
+		key := r.config.AMISourceKeyFor(v)
+		ami, ok := resolved[key]
+		if !ok {
+			resolver, ok := r.amiResolvers[key]
+			if !ok {
+				return nil, fmt.Errorf("AMI resolver for %s is not found", v.InstanceType)
+			}
+
+			var err error
+			ami, err = ResolveAvailableAMI(ctx, resolver, r.ec2Client)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = ami
+		}
+		amis[v] = ami
+	}
+
+	return amis, nil
+}
@@ -0,0 +1,44 @@
+package autoscaler
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func TestResolveAvailableAMI(t *testing.T) {
+	statusStore := new(MockStatusStoreIface)
+	statusStore.On("FetchPinnedAMI", "").Return("ami-abc", nil)
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
+
+	ami, err := ResolveAvailableAMI(context.Background(), &PinnedAMIResolver{status: statusStore}, ec2Client)
+	assert.NoError(t, err)
+	assert.Equal(t, "ami-abc", ami)
+}
+
+func TestResolveAvailableAMINotAvailable(t *testing.T) {
+	statusStore := new(MockStatusStoreIface)
+	statusStore.On("FetchPinnedAMI", "").Return("ami-abc", nil)
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("pending")}, nil)
+
+	_, err := ResolveAvailableAMI(context.Background(), &PinnedAMIResolver{status: statusStore}, ec2Client)
+	assert.Error(t, err)
+}
+
+func TestLatestImageAMIResolver(t *testing.T) {
+	filters := EC2Filters{{Name: "name", Values: []string{"app-*"}}}
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeLatestImage", mock.Anything, []string{"self"}, filters).Return(&ec2.Image{ImageId: aws.String("ami-new")}, nil)
+
+	r := &LatestImageAMIResolver{ec2Client: ec2Client, Owners: []string{"self"}, Filters: filters}
+	ami, err := r.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ami-new", ami)
+}
@@ -1,16 +1,29 @@
 package autoscaler
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type APIServer struct {
-	status  StatusStoreIface
-	metrics map[string]float64
+	status   StatusStoreIface
+	metrics  map[string]float64
+	amis     []string
+	budget   RemovalBudget
+	decision *Decision
+	mutex    sync.Mutex
+	server   *http.Server
+
+	// overrideMutex serializes updates of the scaling override
+	overrideMutex sync.Mutex
 }
 
 func NewAPIServer(status StatusStoreIface) *APIServer {
@@ -20,26 +33,135 @@ func NewAPIServer(status StatusStoreIface) *APIServer {
 	}
 }
 
+// UpdateMetrics merges metrics into the current ones
 func (s *APIServer) UpdateMetrics(metrics map[string]float64) {
-	s.metrics = metrics
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for k, v := range metrics {
+		s.metrics[k] = v
+	}
+}
+
+func (s *APIServer) UpdateAMIs(amis []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.amis = amis
+}
+
+func (s *APIServer) UpdateRemovalBudget(budget RemovalBudget) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.budget = budget
+	delete(s.metrics, "removal_budget_capacity")
+	delete(s.metrics, "removal_budget_instances")
+	if budget.Capacity != nil {
+		s.metrics["removal_budget_capacity"] = *budget.Capacity
+	}
+	if budget.Instances != nil {
+		s.metrics["removal_budget_instances"] = float64(*budget.Instances)
+	}
+}
+
+// UpdateDecision keeps the decision made by the last scaling
+func (s *APIServer) UpdateDecision(d *Decision) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.decision = d
 }
 
 func (s *APIServer) Run(addr string) {
-	r := gin.Default()
+	r := newAPIEngine()
+	s.addRoutes(r)
+	s.server = serveAPI(addr, r)
+}
+
+func (s *APIServer) addRoutes(r gin.IRoutes) {
 	r.GET("/metrics", s.getMetricsHandler)
 	r.GET("/schedules", s.getSchedulesHandler)
 	r.POST("/schedules", s.postSchedulesHandler)
 	r.DELETE("/schedules", s.deleteSchedulesHandler)
+	r.GET("/ami", s.getAMIHandler)
+	r.PUT("/ami", s.putAMIHandler)
+	r.DELETE("/ami", s.deleteAMIHandler)
+	r.GET("/refresh", s.getRefreshHandler)
+	r.POST("/refresh/pause", s.postRefreshPauseHandler)
+	r.POST("/refresh/resume", s.postRefreshResumeHandler)
+	r.GET("/scaling", s.getScalingHandler)
+	r.POST("/scaling/pause", s.postScalingPauseHandler)
+	r.POST("/scaling/resume", s.postScalingResumeHandler)
+	r.POST("/scaling/scale-in/pause", s.postScaleInPauseHandler)
+	r.POST("/scaling/scale-in/resume", s.postScaleInResumeHandler)
+	r.PUT("/scaling/capacity", s.putScalingCapacityHandler)
+	r.DELETE("/scaling/capacity", s.deleteScalingCapacityHandler)
+	r.GET("/status", s.getStatusHandler)
+	r.GET("/decision/last", s.getLastDecisionHandler)
+}
+
+// newAPIEngine returns a gin engine which writes access logs and recovered panics by the log package
+// instead of stdout, so that they follow -log-level and -log-format
+func newAPIEngine() *gin.Engine {
+	r := gin.New()
+	r.Use(logAPIRequest, recoverAPIPanic)
+	return r
+}
+
+func logAPIRequest(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	logWithFields(c.Request.Context(), "DEBUG", "API request", LogFields{
+		"method":    c.Request.Method,
+		"path":      c.Request.URL.Path,
+		"status":    c.Writer.Status(),
+		"latency":   time.Since(start),
+		"client_ip": c.ClientIP(),
+	})
+}
+
+func recoverAPIPanic(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("[ERROR] panic in API handler of %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+	}()
+	c.Next()
+}
+
+func serveAPI(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{Addr: addr, Handler: handler}
 	go func() {
-		r.Run(addr)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("[ERROR] API server: %s", err)
+		}
 	}()
+	return server
+}
+
+// Shutdown stops the server after requests in progress finish or ctx is done
+func (s *APIServer) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	logf(ctx, "[INFO] shutting down API server")
+	return s.server.Shutdown(ctx)
 }
 
 func (s *APIServer) getMetricsHandler(c *gin.Context) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	lines := []string{}
 	for k, v := range s.metrics {
 		lines = append(lines, fmt.Sprintf("spotscaler_%s{} %f", k, v))
 	}
+	for _, ami := range s.amis {
+		lines = append(lines, fmt.Sprintf("spotscaler_ami_info{ami=%q} 1", ami))
+	}
 	body := fmt.Sprintf("%s\n", strings.Join(lines, "\n"))
 	c.String(200, body)
 }
@@ -80,3 +202,282 @@ func (s *APIServer) deleteSchedulesHandler(c *gin.Context) {
 		"deleted": true,
 	})
 }
+
+// AMI handlers take "key" query parameter to specify a pinned AMI resolver in
+// launch overrides (e.g. "arch:arm64" or "type:m6g.large")
+func (s *APIServer) getAMIHandler(c *gin.Context) {
+	pinned, err := s.status.FetchPinnedAMI(c.Query("key"))
+	if err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	s.mutex.Lock()
+	current := s.amis
+	s.mutex.Unlock()
+
+	c.JSON(200, gin.H{
+		"Current": current,
+		"Pinned":  pinned,
+	})
+}
+
+func (s *APIServer) putAMIHandler(c *gin.Context) {
+	var req struct {
+		ImageID string `binding:"required"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.String(400, "%s", err)
+		return
+	}
+
+	if err := s.status.StorePinnedAMI(c.Query("key"), req.ImageID); err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"Pinned": req.ImageID,
+	})
+}
+
+func (s *APIServer) deleteAMIHandler(c *gin.Context) {
+	if err := s.status.StorePinnedAMI(c.Query("key"), ""); err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"deleted": true,
+	})
+}
+
+func (s *APIServer) getRefreshHandler(c *gin.Context) {
+	st, err := s.status.FetchRefreshStatus()
+	if err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	paused, err := s.status.FetchRefreshPaused()
+	if err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"Status": st,
+		"Paused": paused,
+	})
+}
+
+func (s *APIServer) postRefreshPauseHandler(c *gin.Context) {
+	s.setRefreshPaused(c, true)
+}
+
+func (s *APIServer) postRefreshResumeHandler(c *gin.Context) {
+	s.setRefreshPaused(c, false)
+}
+
+func (s *APIServer) setRefreshPaused(c *gin.Context, paused bool) {
+	if err := s.status.StoreRefreshPaused(paused); err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"Paused": paused,
+	})
+}
+
+// getScalingHandler returns cooldowns and the removal budget as of the last loop
+func (s *APIServer) getScalingHandler(c *gin.Context) {
+	st, err := s.status.FetchScalingStatus()
+	if err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	override, err := s.status.FetchScalingOverride()
+	if err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	s.mutex.Lock()
+	budget := s.budget
+	s.mutex.Unlock()
+
+	c.JSON(200, gin.H{
+		"Status":        st,
+		"RemovalBudget": budget,
+		"Override":      override,
+	})
+}
+
+func (s *APIServer) postScalingPauseHandler(c *gin.Context) {
+	s.updateOverride(c, func(o *ScalingOverride) error {
+		o.Paused = true
+		return nil
+	})
+}
+
+func (s *APIServer) postScalingResumeHandler(c *gin.Context) {
+	s.updateOverride(c, func(o *ScalingOverride) error {
+		o.Paused = false
+		return nil
+	})
+}
+
+func (s *APIServer) postScaleInPauseHandler(c *gin.Context) {
+	s.updateOverride(c, func(o *ScalingOverride) error {
+		o.ScaleInPaused = true
+		return nil
+	})
+}
+
+func (s *APIServer) postScaleInResumeHandler(c *gin.Context) {
+	s.updateOverride(c, func(o *ScalingOverride) error {
+		o.ScaleInPaused = false
+		return nil
+	})
+}
+
+// putScalingCapacityHandler overrides capacity until ExpiresAt (RFC3339) or for Duration (e.g. "1h")
+func (s *APIServer) putScalingCapacityHandler(c *gin.Context) {
+	var req struct {
+		Capacity  *float64 `binding:"required"`
+		ExpiresAt time.Time
+		Duration  string
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.String(400, "%s", err)
+		return
+	}
+
+	now := time.Now()
+	expiresAt := req.ExpiresAt
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			c.String(400, "%s", err)
+			return
+		}
+		expiresAt = now.Add(d)
+	}
+	if expiresAt.IsZero() {
+		c.String(400, "ExpiresAt or Duration is required")
+		return
+	}
+
+	s.updateOverride(c, func(o *ScalingOverride) error {
+		return o.SetCapacity(*req.Capacity, expiresAt, now)
+	})
+}
+
+func (s *APIServer) deleteScalingCapacityHandler(c *gin.Context) {
+	s.updateOverride(c, func(o *ScalingOverride) error {
+		o.ClearCapacity()
+		return nil
+	})
+}
+
+// updateOverride updates the scaling override by f and responds with the updated one.
+// An error from f is a bad request.
+func (s *APIServer) updateOverride(c *gin.Context, f func(o *ScalingOverride) error) {
+	s.overrideMutex.Lock()
+	defer s.overrideMutex.Unlock()
+
+	o, err := s.status.FetchScalingOverride()
+	if err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	if err := f(o); err != nil {
+		c.String(400, "%s", err)
+		return
+	}
+
+	if err := s.status.StoreScalingOverride(o); err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	fields := LogFields{"paused": o.Paused, "scale_in_paused": o.ScaleInPaused}
+	if o.Capacity != nil {
+		fields["capacity"] = *o.Capacity
+		fields["capacity_expires_at"] = o.CapacityExpiresAt.Format(time.RFC3339)
+	}
+	logWithFields(c.Request.Context(), "INFO", "scaling override is updated via API", fields)
+	c.JSON(200, o)
+}
+
+// getStatusHandler returns a summary of data in the status store
+func (s *APIServer) getStatusHandler(c *gin.Context) {
+	st, err := FetchStatus(s.status)
+	if err != nil {
+		c.String(500, "%s", err)
+		return
+	}
+
+	c.JSON(200, st)
+}
+
+// getLastDecisionHandler returns how the last scaling decided changes
+func (s *APIServer) getLastDecisionHandler(c *gin.Context) {
+	s.mutex.Lock()
+	d := s.decision
+	s.mutex.Unlock()
+
+	if d == nil {
+		c.String(404, "no decision is made yet")
+		return
+	}
+	c.JSON(200, d)
+}
+
+// GroupsAPIServer serves APIs of autoscaler groups on one server. Routes of APIServer
+// are served under /groups/<AutoscalerID> (e.g. /groups/web/schedules).
+type GroupsAPIServer struct {
+	apis   map[string]*APIServer
+	server *http.Server
+}
+
+func NewGroupsAPIServer(apis map[string]*APIServer) *GroupsAPIServer {
+	return &GroupsAPIServer{
+		apis: apis,
+	}
+}
+
+func (s *GroupsAPIServer) Run(addr string) {
+	s.server = serveAPI(addr, s.handler())
+}
+
+func (s *GroupsAPIServer) handler() http.Handler {
+	r := newAPIEngine()
+	r.GET("/groups", s.getGroupsHandler)
+	for id, api := range s.apis {
+		api.addRoutes(r.Group("/groups/" + id))
+	}
+	return r
+}
+
+// Shutdown stops the server after requests in progress finish or ctx is done
+func (s *GroupsAPIServer) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	logf(ctx, "[INFO] shutting down API server")
+	return s.server.Shutdown(ctx)
+}
+
+func (s *GroupsAPIServer) getGroupsHandler(c *gin.Context) {
+	ids := []string{}
+	for id := range s.apis {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	c.JSON(200, ids)
+}
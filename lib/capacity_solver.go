@@ -0,0 +1,192 @@
+package autoscaler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MaxCapacitySolverIterations limits the number of instances a solver allocates
+const MaxCapacitySolverIterations = 100000
+
+// UnsatisfiableCapacityError is returned when no allocation meets the requirement
+type UnsatisfiableCapacityError struct {
+	Reason string
+}
+
+func (e *UnsatisfiableCapacityError) Error() string {
+	return fmt.Sprintf("desired capacity is unsatisfiable: %s", e.Reason)
+}
+
+// capacitySolver computes allocations InstanceCapacity.Increment reaches after k steps
+// without iterating. Increment always adds an instance to the variety with the least
+// capacity, so the k-th allocation consists of the k smallest "levels" j * capacity
+// of all varieties, where ties are broken by the order of SortInstanceVarietiesByCapacity.
+//
+// With failure domains larger than a variety, the step of levels of a variety is its
+// capacity multiplied by the number of varieties in its failure domain, so that each
+// failure domain gets about the same capacity however many varieties it has.
+type capacitySolver struct {
+	varieties []InstanceVariety
+	units     []float64
+	steps     []float64
+}
+
+func newCapacitySolver(varieties []InstanceVariety, capacities CapacityTable, failureModel FailureModel) (*capacitySolver, error) {
+	vs := make([]InstanceVariety, len(varieties))
+	copy(vs, varieties)
+	sort.Sort(SortInstanceVarietiesByCapacity{Varieties: vs, Capacities: capacities})
+
+	domains := failureModel.Domains(vs)
+	units := []float64{}
+	steps := []float64{}
+	for _, v := range vs {
+		c, err := v.Capacity(capacities)
+		if err != nil {
+			return nil, err
+		}
+		if c <= 0 {
+			return nil, fmt.Errorf("capacity of %s must be positive", v.InstanceType)
+		}
+		units = append(units, c)
+		steps = append(steps, c*float64(domains[failureModel.DomainOf(v)]))
+	}
+
+	return &capacitySolver{varieties: vs, units: units, steps: steps}, nil
+}
+
+// countAtOrBelow returns the number of instances of variety i whose level is at most l
+func (s *capacitySolver) countAtOrBelow(i int, l float64) int {
+	if l < 0 {
+		return 0
+	}
+	u := s.steps[i]
+	j := int(math.Floor(l / u))
+	for float64(j+1)*u <= l {
+		j++
+	}
+	for j >= 0 && float64(j)*u > l {
+		j--
+	}
+	return j + 1
+}
+
+func (s *capacitySolver) totalAtOrBelow(l float64) int {
+	n := 0
+	for i := range s.steps {
+		n += s.countAtOrBelow(i, l)
+	}
+	return n
+}
+
+// counts returns the number of instances of each variety after k increments
+func (s *capacitySolver) counts(k int) []int {
+	counts := make([]int, len(s.steps))
+	if k <= 0 {
+		return counts
+	}
+
+	// bisect level of the k-th instance: totalAtOrBelow(lo) < k <= totalAtOrBelow(hi)
+	lo, hi := -1.0, float64(k-1)*s.steps[0]
+	for _, u := range s.steps {
+		hi = math.Min(hi, float64(k-1)*u)
+	}
+	for {
+		mid := lo + (hi-lo)/2
+		if mid <= lo || mid >= hi {
+			break
+		}
+		if s.totalAtOrBelow(mid) < k {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	// the largest level at most hi is the level of the k-th instance
+	level := 0.0
+	for i, u := range s.steps {
+		if l := float64(s.countAtOrBelow(i, hi)-1) * u; l > level {
+			level = l
+		}
+	}
+
+	taken := 0
+	for i, u := range s.steps {
+		// instances whose level is below the level of the k-th instance
+		counts[i] = int(math.Ceil(level / u))
+		for counts[i] > 0 && float64(counts[i]-1)*u >= level {
+			counts[i]--
+		}
+		for float64(counts[i])*u < level {
+			counts[i]++
+		}
+		taken += counts[i]
+	}
+
+	// instances exactly at the level are taken in the order of varieties
+	for i, u := range s.steps {
+		if taken >= k {
+			break
+		}
+		if float64(counts[i])*u == level {
+			counts[i]++
+			taken++
+		}
+	}
+
+	return counts
+}
+
+func (s *capacitySolver) capacityFromCounts(counts []int) InstanceCapacity {
+	c := InstanceCapacity{}
+	for i, v := range s.varieties {
+		c[v] = float64(counts[i]) * s.units[i]
+	}
+	return c
+}
+
+func (s *capacitySolver) capacity(k int) InstanceCapacity {
+	return s.capacityFromCounts(s.counts(k))
+}
+
+// firstSatisfying returns the least k in [0, MaxCapacitySolverIterations] for which
+// monotone f is true, or -1 if there is none
+func firstSatisfying(f func(k int) bool) int {
+	if f(0) {
+		return 0
+	}
+
+	hi := 1
+	for !f(hi) {
+		if hi >= MaxCapacitySolverIterations {
+			return -1
+		}
+		hi *= 2
+		if hi > MaxCapacitySolverIterations {
+			hi = MaxCapacitySolverIterations
+		}
+	}
+
+	lo := hi / 2
+	for lo+1 < hi {
+		mid := (lo + hi) / 2
+		if f(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi
+}
+
+// next adds an instance to counts in the same way as counts(k+1) does
+func (s *capacitySolver) next(counts []int) {
+	least := 0
+	for i, u := range s.steps {
+		if float64(counts[i])*u < float64(counts[least])*s.steps[least] {
+			least = i
+		}
+	}
+	counts[least]++
+}
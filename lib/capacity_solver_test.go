@@ -0,0 +1,145 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// desiredCapacityFromTotalByIncrement is the previous algorithm which adds instances one by one
+func desiredCapacityFromTotalByIncrement(varieties []InstanceVariety, capacities CapacityTable, total float64, maxTerminatedVarieties int, limit int) (InstanceCapacity, bool) {
+	c := InstanceCapacity{}
+	for _, v := range varieties {
+		c[v] = 0.0
+	}
+	for i := 0; i <= limit; i++ {
+		if total <= c.TotalInWorstCase(maxTerminatedVarieties) {
+			return c, true
+		}
+		c.Increment(capacities)
+	}
+	return nil, false
+}
+
+// desiredCapacityFromTargetCPUUtilByIncrement is the previous algorithm which adds instances one by one
+func desiredCapacityFromTargetCPUUtilByIncrement(varieties []InstanceVariety, capacities CapacityTable, cpuUtil float64, maxCPUUtil float64, targetCPUUtilDiff float64, ondemandCapacityTotal float64, spotCapacityTotal float64, maxTerminatedVarieties int, limit int) (InstanceCapacity, bool) {
+	c := InstanceCapacity{}
+	for _, v := range varieties {
+		c[v] = 0.0
+	}
+	for i := 0; i <= limit; i++ {
+		u := cpuUtil * (ondemandCapacityTotal + spotCapacityTotal) / (ondemandCapacityTotal + c.Total())
+		uScaleOut := maxCPUUtil *
+			(ondemandCapacityTotal + c.TotalInWorstCase(maxTerminatedVarieties)) /
+			(ondemandCapacityTotal + c.Total())
+		if u < uScaleOut-targetCPUUtilDiff {
+			return c, true
+		}
+		c.Increment(capacities)
+	}
+	return nil, false
+}
+
+type solverTestCase struct {
+	Varieties              []InstanceVariety
+	Capacities             CapacityTable
+	MaxTerminatedVarieties int
+	Total                  float64
+	CPUUtil                float64
+	MaxCPUUtil             float64
+	TargetCPUUtilDiff      float64
+	OndemandCapacity       float64
+	SpotCapacity           float64
+}
+
+func (solverTestCase) Generate(r *rand.Rand, size int) reflect.Value {
+	c := solverTestCase{Capacities: CapacityTable{}}
+
+	n := 1 + r.Intn(6)
+	for i := 0; i < n; i++ {
+		t := fmt.Sprintf("t%d", i)
+		// multiples of 0.5 are exact in float64, as capacities in practice are
+		c.Capacities[t] = float64(1+r.Intn(32)) / 2.0
+		c.Varieties = append(c.Varieties, InstanceVariety{
+			InstanceType: t,
+			Subnet:       Subnet{SubnetID: fmt.Sprintf("subnet-%d", r.Intn(3))},
+		})
+	}
+
+	c.MaxTerminatedVarieties = r.Intn(n)
+	c.Total = float64(r.Intn(400))
+	c.CPUUtil = float64(r.Intn(100))
+	c.MaxCPUUtil = float64(50 + r.Intn(40))
+	c.TargetCPUUtilDiff = float64(r.Intn(20))
+	c.OndemandCapacity = float64(r.Intn(100))
+	c.SpotCapacity = float64(r.Intn(200))
+
+	return reflect.ValueOf(c)
+}
+
+func TestDesiredCapacityFromTotalMatchesIncrement(t *testing.T) {
+	f := func(c solverTestCase) bool {
+		expected, ok := desiredCapacityFromTotalByIncrement(c.Varieties, c.Capacities, c.Total, c.MaxTerminatedVarieties, 10000)
+		if !ok {
+			return true
+		}
+		actual, err := DesiredCapacityFromTotal(c.Varieties, c.Capacities, c.Total, varietyFailureModel(c.MaxTerminatedVarieties))
+		return err == nil && reflect.DeepEqual(expected, actual)
+	}
+
+	err := quick.Check(f, &quick.Config{MaxCount: 500})
+	assert.NoError(t, err)
+}
+
+func TestDesiredCapacityFromTargetCPUUtilMatchesIncrement(t *testing.T) {
+	f := func(c solverTestCase) bool {
+		expected, ok := desiredCapacityFromTargetCPUUtilByIncrement(c.Varieties, c.Capacities, c.CPUUtil, c.MaxCPUUtil, c.TargetCPUUtilDiff, c.OndemandCapacity, c.SpotCapacity, c.MaxTerminatedVarieties, 10000)
+		if !ok {
+			return true
+		}
+		actual, err := DesiredCapacityFromTargetCPUUtil(context.Background(), c.Varieties, c.Capacities, c.CPUUtil, c.MaxCPUUtil, c.TargetCPUUtilDiff, c.OndemandCapacity, c.SpotCapacity, varietyFailureModel(c.MaxTerminatedVarieties))
+		return err == nil && reflect.DeepEqual(expected, actual)
+	}
+
+	err := quick.Check(f, &quick.Config{MaxCount: 500})
+	assert.NoError(t, err)
+}
+
+func TestDesiredCapacityUnsatisfiable(t *testing.T) {
+	capacities := CapacityTable{"t1": 10}
+	varieties := []InstanceVariety{{InstanceType: "t1", Subnet: Subnet{SubnetID: "subnet-a"}}}
+
+	_, err := DesiredCapacityFromTotal(varieties, capacities, 100, varietyFailureModel(1))
+	assert.IsType(t, &UnsatisfiableCapacityError{}, err)
+
+	_, err = DesiredCapacityFromTotal([]InstanceVariety{}, capacities, 100, varietyFailureModel(0))
+	assert.IsType(t, &UnsatisfiableCapacityError{}, err)
+
+	// capacity in the worst case is always zero, so CPU util never goes down
+	_, err = DesiredCapacityFromTargetCPUUtil(context.Background(), varieties, capacities, 50, 80, 10, 0, 10, varietyFailureModel(1))
+	assert.IsType(t, &UnsatisfiableCapacityError{}, err)
+
+	_, err = DesiredCapacityFromTargetCPUUtil(context.Background(), varieties, capacities, 50, 10, 10, 0, 10, varietyFailureModel(0))
+	assert.IsType(t, &UnsatisfiableCapacityError{}, err)
+}
+
+func TestDesiredCapacityFromTotalLarge(t *testing.T) {
+	capacities := CapacityTable{"t1": 1, "t2": 3}
+	varieties := []InstanceVariety{
+		{InstanceType: "t1", Subnet: Subnet{SubnetID: "subnet-a"}},
+		{InstanceType: "t2", Subnet: Subnet{SubnetID: "subnet-a"}},
+	}
+
+	actual, err := DesiredCapacityFromTotal(varieties, capacities, 30000, varietyFailureModel(1))
+	assert.NoError(t, err)
+	assert.Equal(t, InstanceCapacity{varieties[0]: 30000, varieties[1]: 30000}, actual)
+}
+
+func varietyFailureModel(n int) FailureModel {
+	return FailureModel{Domain: FailureDomainVariety, MaxFailedDomains: n}
+}
@@ -1,81 +1,705 @@
 package autoscaler
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/hashicorp/logutils"
 )
 
 func init() {
 	// overwrite flags set by gin...
 	log.SetFlags(log.LstdFlags)
+	// gin prints routes and warnings in debug mode
+	if os.Getenv(gin.ENV_GIN_MODE) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
 }
 
+const cliUsage = `Usage: spotscaler <subcommand> [options]
+
+Subcommands:
+  run        run scaling loops (default)
+  once       run a loop once and exit
+  plan       compute and print changes of instances without any side effect
+  apply      apply changes computed now or saved by plan -out
+  status     print status in the status store
+  schedules  list, add or remove (rm) schedules
+  lint       validate a config file
+  simulate   run scaling against recorded or synthetic inputs
+  replay     replay recorded inputs and compare decisions
+  version    show version
+
+Run "spotscaler <subcommand> -h" for options of each subcommand.
+`
+
 // StartCLI is entrypoint and returns exit code
 func StartCLI() int {
-	configPath := flag.String("config", "", "config file")
-	confirmBeforeAction := flag.Bool("confirm-before-action", false, "confirmation before important actions")
-	version := flag.Bool("version", false, "show version")
-	logLevel := flag.String("log-level", "DEBUG", "log level (one of TRACE, DEBUG, INFO, WARN and ERROR)")
-	dryRun := flag.Bool("dry-run", false, "dry run mode")
-	flag.Parse()
+	ctx, cancel := shutdownContext()
+	defer cancel()
 
-	SetLogLevel(*logLevel)
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		// flags without subcommand run loops as before subcommands were introduced
+		return startRunCLI(ctx, args)
+	}
+
+	switch args[0] {
+	case "run":
+		return startRunCLI(ctx, args[1:])
+	case "once":
+		return startOnceCLI(ctx, args[1:])
+	case "plan":
+		return startPlanCLI(ctx, args[1:])
+	case "apply":
+		return startApplyCLI(ctx, args[1:])
+	case "status":
+		return startStatusCLI(args[1:])
+	case "schedules":
+		return startSchedulesCLI(args[1:])
+	case "lint":
+		return startLintCLI(args[1:])
+	case "simulate":
+		return startSimulateCLI(ctx, args[1:])
+	case "replay":
+		return startReplayCLI(ctx, args[1:])
+	case "version":
+		fmt.Printf("spotscaler v%s (%v)\n", Version, GitCommit)
+		return 0
+	case "help":
+		fmt.Print(cliUsage)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown subcommand: %s\n\n%s", args[0], cliUsage)
+	return 1
+}
+
+// shutdownContext returns a context canceled on SIGINT or SIGTERM.
+// The second signal terminates the process immediately.
+func shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigchan:
+			log.Printf("[INFO] %s is received, stopping after the current step", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigchan)
+	}()
+
+	return ctx, cancel
+}
+
+// runnerFlags are flags shared by subcommands which run a Runner
+type runnerFlags struct {
+	configPath          *string
+	confirmBeforeAction *bool
+	logLevel            *string
+	logFormat           *string
+	dryRun              *bool
+	group               *string
+}
+
+func newRunnerFlags(fs *flag.FlagSet) *runnerFlags {
+	return &runnerFlags{
+		configPath:          fs.String("config", "", "config file"),
+		confirmBeforeAction: fs.Bool("confirm-before-action", false, "confirmation before important actions"),
+		logLevel:            fs.String("log-level", "DEBUG", "log level (one of TRACE, DEBUG, INFO, WARN and ERROR)"),
+		logFormat:           newLogFormatFlag(fs),
+		dryRun:              fs.Bool("dry-run", false, "dry run mode"),
+		group:               newGroupFlag(fs),
+	}
+}
+
+func newGroupFlag(fs *flag.FlagSet) *string {
+	return fs.String("group", "", "AutoscalerID of a group if config has Groups")
+}
+
+func newLogFormatFlag(fs *flag.FlagSet) *string {
+	return fs.String("log-format", "text", "log format (text or json)")
+}
+
+// loadConfigs loads and validates configs of groups with flags applied.
+// All groups are loaded unless -group is specified.
+func (f *runnerFlags) loadConfigs() ([]*Config, error) {
+	err := SetLogFormat(*f.logFormat)
+	if err != nil {
+		return nil, err
+	}
+	SetLogLevel(*f.logLevel)
+
+	configs, err := loadValidConfigs(*f.configPath)
+	if err != nil {
+		return nil, err
+	}
+	if *f.group != "" {
+		config, err := selectGroupConfig(configs, *f.group)
+		if err != nil {
+			return nil, err
+		}
+		configs = []*Config{config}
+	}
+
+	for _, config := range configs {
+		if *f.confirmBeforeAction {
+			config.ConfirmBeforeAction = *f.confirmBeforeAction
+		}
+		if *f.dryRun {
+			config.DryRun = *f.dryRun
+		}
+		log.Printf("[DEBUG] loaded config: %s", config.Redacted())
+	}
+
+	return configs, nil
+}
+
+// loadConfig loads and validates config of a group with flags applied
+func (f *runnerFlags) loadConfig() (*Config, error) {
+	configs, err := f.loadConfigs()
+	if err != nil {
+		return nil, err
+	}
+	return selectGroupConfig(configs, *f.group)
+}
+
+func (f *runnerFlags) newRunner() (*Runner, error) {
+	config, err := f.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewRunner(config)
+}
+
+func loadValidConfigs(path string) ([]*Config, error) {
+	if path == "" {
+		return nil, fmt.Errorf("-config option is required")
+	}
+
+	configs, err := LoadYAMLConfigs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, config := range configs {
+		err = config.Validate()
+		if err != nil {
+			if len(configs) > 1 {
+				return nil, fmt.Errorf("%s: %s", config.AutoscalerID, err)
+			}
+			return nil, err
+		}
+	}
+
+	if len(configs) > 1 {
+		err = ValidateGroupConfigs(configs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+// loadValidConfig loads and validates config of the group (which can be empty if config has no Groups)
+func loadValidConfig(path string, group string) (*Config, error) {
+	configs, err := loadValidConfigs(path)
+	if err != nil {
+		return nil, err
+	}
+	return selectGroupConfig(configs, group)
+}
+
+func selectGroupConfig(configs []*Config, group string) (*Config, error) {
+	ids := []string{}
+	for _, c := range configs {
+		if group == "" && len(configs) == 1 || c.AutoscalerID == group {
+			return c, nil
+		}
+		ids = append(ids, c.AutoscalerID)
+	}
+
+	if group == "" {
+		return nil, fmt.Errorf("-group option is required (one of %s)", strings.Join(ids, ", "))
+	}
+	return nil, fmt.Errorf("group %s is not found (one of %s)", group, strings.Join(ids, ", "))
+}
+
+func startRunCLI(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	f := newRunnerFlags(fs)
+	version := fs.Bool("version", false, "show version")
+	recordPath := fs.String("record", "", "JSONL file which inputs of scaling in each loop are appended to")
+	fs.Parse(args)
 
 	if *version {
 		fmt.Printf("spotscaler v%s (%v)\n", Version, GitCommit)
 		return 0
 	}
 
-	if *configPath == "" {
-		log.Println("[ERROR] -config option is required")
+	configs, err := f.loadConfigs()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	if len(configs) > 1 {
+		if *recordPath != "" {
+			log.Println("[ERROR] -record is not supported with multiple groups, use -group")
+			return 1
+		}
+
+		groups, err := NewGroups(configs)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		groups.EnableConfigReload(f.loadConfigs)
+
+		err = groups.StartLoops(ctx)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		return 0
+	}
+
+	runner, err := NewRunner(configs[0])
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	runner.EnableConfigReload(f.loadConfig)
+
+	if *recordPath != "" {
+		err = runner.EnableRecording(*recordPath)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+
+	err = runner.StartLoop(ctx)
+	if err != nil {
+		log.Println(err)
 		return 1
 	}
 
-	config, err := LoadYAMLConfig(*configPath)
+	return 0
+}
+
+func startOnceCLI(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("once", flag.ExitOnError)
+	f := newRunnerFlags(fs)
+	fs.Parse(args)
+
+	configs, err := f.loadConfigs()
 	if err != nil {
 		log.Println(err)
 		return 1
 	}
-	if *confirmBeforeAction {
-		config.ConfirmBeforeAction = *confirmBeforeAction
+
+	if len(configs) > 1 {
+		groups, err := NewGroups(configs)
+		if err == nil {
+			err = groups.RunOnce(ctx)
+		}
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		return 0
 	}
-	if *dryRun {
-		config.DryRun = *dryRun
+
+	runner, err := NewRunner(configs[0])
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	err = runner.RunOnce(ctx)
+	if err != nil {
+		log.Println(err)
+		return 1
 	}
 
-	err = config.Validate()
+	return 0
+}
+
+func startPlanCLI(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	configPath := fs.String("config", "", "config file")
+	group := newGroupFlag(fs)
+	logLevel := fs.String("log-level", "WARN", "log level (one of TRACE, DEBUG, INFO, WARN and ERROR)")
+	logFormat := newLogFormatFlag(fs)
+	outPath := fs.String("out", "", "file the plan is saved to for apply -plan (optional)")
+	fs.Parse(args)
+
+	err := SetLogFormat(*logFormat)
 	if err != nil {
 		log.Println(err)
 		return 1
 	}
+	SetLogLevel(*logLevel)
 
-	log.Printf("[DEBUG] loaded config: %+v", config)
+	config, err := loadValidConfig(*configPath, *group)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
 
 	runner, err := NewRunner(config)
 	if err != nil {
 		log.Println(err)
 		return 1
 	}
+	runner.DisableSideEffects()
+
+	plan, err := runner.Plan(ctx)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	err = PrintScalePlan(os.Stdout, plan)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	if *outPath != "" {
+		err = WriteScalePlan(*outPath, plan)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func startLintCLI(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	configPath := fs.String("config", "", "config file")
+	fs.Parse(args)
+
+	configs, err := loadValidConfigs(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if len(configs) > 1 {
+		fmt.Printf("%s is valid (%d groups)\n", *configPath, len(configs))
+		return 0
+	}
+	fmt.Printf("%s is valid\n", *configPath)
+	return 0
+}
+
+func startApplyCLI(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	f := newRunnerFlags(fs)
+	planPath := fs.String("plan", "", "file saved by plan -out (a plan is computed now by default)")
+	fs.Parse(args)
+
+	runner, err := f.newRunner()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	var plan *ScalePlan
+	if *planPath != "" {
+		plan, err = LoadScalePlan(*planPath)
+	} else {
+		plan, err = runner.Plan(ctx)
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	err = PrintScalePlan(os.Stdout, plan)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	if *planPath != "" {
+		err = runner.ApplyPlan(ctx, plan)
+	} else {
+		err = runner.applyScalePlan(ctx, plan)
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	return 0
+}
+
+func startStatusCLI(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath := fs.String("config", "", "config file")
+	group := newGroupFlag(fs)
+	logFormat := newLogFormatFlag(fs)
+	fs.Parse(args)
+
+	err := SetLogFormat(*logFormat)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	SetLogLevel("WARN")
+
+	config, err := loadValidConfig(*configPath, *group)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	status, err := FetchStatus(NewStatusStore(config.RedisHost, config.RedisPassword, config.FullAutoscalerID()))
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	j, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	fmt.Println(string(j))
+
+	return 0
+}
+
+func startSchedulesCLI(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: spotscaler schedules <list|add|rm> [options]")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("schedules "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", "", "config file")
+	group := newGroupFlag(fs)
+	startAt := fs.String("start", "", "start time in RFC3339 (add)")
+	endAt := fs.String("end", "", "end time in RFC3339 (add)")
+	capacity := fs.Float64("capacity", 0, "capacity during the schedule (add)")
+	fs.Parse(args[1:])
+
+	SetLogLevel("WARN")
+
+	config, err := loadValidConfig(*configPath, *group)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	status := NewStatusStore(config.RedisHost, config.RedisPassword, config.FullAutoscalerID())
+
+	switch args[0] {
+	case "list":
+		schedules, err := status.ListSchedules()
+		if err == nil {
+			err = PrintSchedules(os.Stdout, schedules)
+		}
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	case "add":
+		sch := NewSchedule()
+		sch.StartAt, err = time.Parse(time.RFC3339, *startAt)
+		if err == nil {
+			sch.EndAt, err = time.Parse(time.RFC3339, *endAt)
+		}
+		if err != nil {
+			log.Println("[ERROR] -start and -end options in RFC3339 are required:", err)
+			return 1
+		}
+		sch.Capacity = *capacity
+
+		err = sch.Validate()
+		if err == nil {
+			err = status.AddSchedules(sch)
+		}
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		fmt.Println(sch.Key)
+	case "rm":
+		if fs.NArg() != 1 {
+			log.Println("[ERROR] key of a schedule is required: spotscaler schedules rm -config config.yml <key>")
+			return 1
+		}
+		err = status.RemoveSchedule(fs.Arg(0))
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown schedules subcommand: %s\n", args[0])
+		return 1
+	}
+
+	return 0
+}
+
+func startSimulateCLI(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configPath := fs.String("config", "", "config file")
+	group := newGroupFlag(fs)
+	instancesPath := fs.String("instances", "", "JSON file of working instances")
+	pricesPath := fs.String("prices", "", "JSON file of spot prices by instance type")
+	schedulesPath := fs.String("schedules", "", "JSON file of schedules (optional)")
+	metricsPath := fs.String("metrics", "", "JSON file of CPU util series")
+	subnetsPath := fs.String("subnets", "", "JSON file of subnets used instead of ones in config (optional)")
+	logLevel := fs.String("log-level", "WARN", "log level (one of TRACE, DEBUG, INFO, WARN and ERROR)")
+	logFormat := newLogFormatFlag(fs)
+	fs.Parse(args)
+
+	err := SetLogFormat(*logFormat)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	SetLogLevel(*logLevel)
+
+	if *configPath == "" || *instancesPath == "" || *pricesPath == "" || *metricsPath == "" {
+		log.Println("[ERROR] -config, -instances, -prices and -metrics options are required")
+		return 1
+	}
+
+	config, err := loadValidConfig(*configPath, *group)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	input, err := LoadSimulationInput(*instancesPath, *pricesPath, *schedulesPath, *metricsPath, *subnetsPath)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	simulator, err := NewSimulator(config, input)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	steps, err := simulator.Run(ctx)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	err = PrintSimulationSteps(os.Stdout, steps)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	return 0
+}
+
+func startReplayCLI(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := fs.String("config", "", "config file")
+	group := newGroupFlag(fs)
+	recordsPath := fs.String("records", "", "JSONL file written with -record")
+	logLevel := fs.String("log-level", "WARN", "log level (one of TRACE, DEBUG, INFO, WARN and ERROR)")
+	logFormat := newLogFormatFlag(fs)
+	fs.Parse(args)
+
+	err := SetLogFormat(*logFormat)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	SetLogLevel(*logLevel)
+
+	if *configPath == "" || *recordsPath == "" {
+		log.Println("[ERROR] -config and -records options are required")
+		return 1
+	}
+
+	config, err := loadValidConfig(*configPath, *group)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	records, err := LoadRunRecords(*recordsPath)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
 
-	err = runner.StartLoop()
+	results, err := Replay(ctx, config, records)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
 
+	differences, err := PrintReplayResults(os.Stdout, results)
 	if err != nil {
 		log.Println(err)
 		return 1
 	}
 
+	if differences > 0 {
+		log.Printf("[ERROR] %d decisions differ from recorded ones", differences)
+		return 1
+	}
+
 	return 0
 }
 
+var jsonLogging bool
+
 func SetLogLevel(level string) {
+	var w io.Writer = os.Stdout
+	if jsonLogging {
+		w = &JSONLogWriter{Writer: os.Stdout}
+	}
+
 	filter := &logutils.LevelFilter{
 		Levels:   []logutils.LogLevel{"TRACE", "DEBUG", "INFO", "WARN", "ERROR"},
 		MinLevel: logutils.LogLevel(level),
-		Writer:   os.Stdout,
+		Writer:   w,
 	}
 	log.SetOutput(filter)
 }
+
+// SetLogFormat switches log lines to "text" or "json". Call SetLogLevel after it.
+func SetLogFormat(format string) error {
+	switch format {
+	case "text":
+		jsonLogging = false
+		log.SetFlags(log.LstdFlags)
+	case "json":
+		// time is a field of JSON
+		jsonLogging = true
+		log.SetFlags(0)
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	return nil
+}
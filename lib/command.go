@@ -1,8 +1,8 @@
 package autoscaler
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"strings"
@@ -13,22 +13,27 @@ type Command struct {
 	Args    []string `yaml:"Args"`
 }
 
-func (h Command) RunWithStdin(input string) error {
-	log.Printf("[DEBUG] executing %s %v", h.Command, h.Args)
+func (h Command) RunWithStdin(ctx context.Context, input string) error {
+	logf(ctx, "[DEBUG] executing %s %v", h.Command, h.Args)
 
-	c := exec.Command(h.Command, h.Args...)
+	// output goes through the log package to follow -log-format
+	stdout := &logLineWriter{ctx: ctx, level: "INFO", prefix: fmt.Sprintf("%s: ", h.Command)}
+	stderr := &logLineWriter{ctx: ctx, level: "WARN", prefix: fmt.Sprintf("%s: ", h.Command)}
+	c := exec.CommandContext(ctx, h.Command, h.Args...)
 	c.Stdin = strings.NewReader(input)
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
+	c.Stdout = stdout
+	c.Stderr = stderr
 	err := c.Run()
+	stdout.Flush()
+	stderr.Flush()
 	return err
 }
 
-func (h Command) Output(env []string) (string, error) {
-	log.Printf("[DEBUG] executing %s %v", h.Command, h.Args)
+func (h Command) Output(ctx context.Context, env []string) (string, error) {
+	logf(ctx, "[DEBUG] executing %s %v", h.Command, h.Args)
 
 	env = append(env, os.Environ()...)
-	c := exec.Command(h.Command, h.Args...)
+	c := exec.CommandContext(ctx, h.Command, h.Args...)
 	c.Env = env
 	b, err := c.Output()
 	err = h.wrapError(err)
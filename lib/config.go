@@ -1,9 +1,11 @@
 package autoscaler
 
 import (
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/go-playground/validator.v9"
 	"gopkg.in/yaml.v2"
@@ -11,32 +13,83 @@ import (
 
 // Config represents configuration loaded from a file
 type Config struct {
-	AutoscalerID           string              `yaml:"AutoscalerID" validate:"required"`
-	LaunchConfiguration    LaunchConfiguration `yaml:"LaunchConfiguration" validate:"required"`
-	WorkingInstanceFilters EC2Filters          `yaml:"WorkingInstanceFilters" validate:"dive"`
-	TerminateTags          EC2Tags             `yaml:"TerminateTags" validate:"required,dive"`
-	InstanceTags           EC2Tags             `yaml:"InstanceTags" validate:"dive"`
-	LoopInterval           string              `yaml:"LoopInterval" validate:"required"`
-	InstanceCapacityByType map[string]float64  `yaml:"InstanceCapacityByType" validate:"required"`
-	BiddingPriceByType     map[string]float64  `yaml:"BiddingPriceByType" validate:"required"`
-	InstanceTypes          []string            `yaml:"InstanceTypes" validate:"required"`
-	Subnets                []Subnet            `yaml:"Subnets" validate:"required,dive"`
-	RedisHost              string              `yaml:"RedisHost" validate:"required"`
-	Cooldown               string              `yaml:"Cooldown" validate:"required"`
-	HookCommands           []Command           `yaml:"HookCommands"`
-	AMICommand             Command             `yaml:"AMICommand" validate:"required"`
-	CPUUtilCommand         Command             `yaml:"CPUUtilCommand" validate:"required"`
-	CapacityTagKey         string              `yaml:"CapacityTagKey"`
-	ConfirmBeforeAction    bool                `yaml:"ConfirmBeforeAction"`
-	Timers                 map[string]Timer    `yaml:"Timers" validate:"dive"`
-	MaxCPUUtil             float64             `yaml:"MaxCPUUtil" validate:"required"`
-	MaxCapacity            float64             `yaml:"MaxCapacity"`
-	MinCapacity            float64             `yaml:"MinCapacity"`
-	MaxTerminatedVarieties int                 `yaml:"MaxTerminatedVarieties" validate:"required"`
-	ScaleInThreshold       float64             `yaml:"ScaleInThreshold" validate:"required"`
-	ProhibitToScaleIn      bool                `yaml:"ProhibitToScaleIn"`
-	DryRun                 bool                `yaml:"DryRun"`
-	APIAddr                string              `yaml:"APIAddr"`
+	AutoscalerID                  string                    `yaml:"AutoscalerID" validate:"required"`
+	LaunchConfiguration           LaunchConfiguration       `yaml:"LaunchConfiguration" validate:"required"`
+	WorkingInstanceFilters        EC2Filters                `yaml:"WorkingInstanceFilters" validate:"dive"`
+	TerminateTags                 EC2Tags                   `yaml:"TerminateTags" validate:"required,dive"`
+	InstanceTags                  EC2Tags                   `yaml:"InstanceTags" validate:"dive"`
+	NameTag                       string                    `yaml:"NameTag"`
+	LoopInterval                  string                    `yaml:"LoopInterval" validate:"required"`
+	InstanceCapacityByType        map[string]float64        `yaml:"InstanceCapacityByType" validate:"required"`
+	BiddingPriceByType            map[string]float64        `yaml:"BiddingPriceByType" validate:"required"`
+	InstanceTypes                 []string                  `yaml:"InstanceTypes" validate:"required"`
+	InstanceArchitectureByType    map[string]string         `yaml:"InstanceArchitectureByType"`
+	LaunchOverridesByType         map[string]LaunchOverride `yaml:"LaunchOverridesByType" validate:"dive"`
+	LaunchOverridesByArchitecture map[string]LaunchOverride `yaml:"LaunchOverridesByArchitecture" validate:"dive"`
+	Subnets                       []Subnet                  `yaml:"Subnets" validate:"dive"`
+	SubnetFilters                 EC2Filters                `yaml:"SubnetFilters" validate:"dive"`
+	SubnetRefreshInterval         string                    `yaml:"SubnetRefreshInterval"`
+	RedisHost                     string                    `yaml:"RedisHost" validate:"required"`
+	RedisPassword                 string                    `yaml:"RedisPassword"`
+	Cooldown                      string                    `yaml:"Cooldown" validate:"required"`
+	ScaleOutCooldown              string                    `yaml:"ScaleOutCooldown"`
+	ScaleInCooldown               string                    `yaml:"ScaleInCooldown"`
+	MaxTerminatedCapacityPerLoop  float64                   `yaml:"MaxTerminatedCapacityPerLoop" validate:"min=0"`
+	MaxTerminatedCapacityPerHour  float64                   `yaml:"MaxTerminatedCapacityPerHour" validate:"min=0"`
+	MaxTerminatedInstancesPerLoop int                       `yaml:"MaxTerminatedInstancesPerLoop" validate:"min=0"`
+	MaxTerminatedInstancesPerHour int                       `yaml:"MaxTerminatedInstancesPerHour" validate:"min=0"`
+	HookCommands                  []Command                 `yaml:"HookCommands"`
+	AMICommand                    *Command                  `yaml:"AMICommand"`
+	AMIResolver                   *AMIResolverConfig        `yaml:"AMIResolver"`
+	InstanceRefresh               *InstanceRefreshConfig    `yaml:"InstanceRefresh"`
+	MaxInstanceLifetime           string                    `yaml:"MaxInstanceLifetime"`
+	MaxRotatedInstances           int                       `yaml:"MaxRotatedInstances"`
+	Rebalance                     *RebalanceConfig          `yaml:"Rebalance"`
+	ReplacementTimeout            string                    `yaml:"ReplacementTimeout"`
+	CPUUtilCommand                Command                   `yaml:"CPUUtilCommand" validate:"required"`
+	CapacityTagKey                string                    `yaml:"CapacityTagKey"`
+	ConfirmBeforeAction           bool                      `yaml:"ConfirmBeforeAction"`
+	Timers                        map[string]Timer          `yaml:"Timers" validate:"dive"`
+	MaxCPUUtil                    float64                   `yaml:"MaxCPUUtil" validate:"required"`
+	MaxCapacity                   float64                   `yaml:"MaxCapacity"`
+	MinCapacity                   float64                   `yaml:"MinCapacity"`
+	MaxHourlyCost                 float64                   `yaml:"MaxHourlyCost" validate:"min=0"`
+	OndemandPricesFile            string                    `yaml:"OndemandPricesFile"`
+	MaxTerminatedVarieties        int                       `yaml:"MaxTerminatedVarieties" validate:"required"`
+	FailureDomain                 string                    `yaml:"FailureDomain" validate:"omitempty,eq=variety|eq=availabilityZone|eq=instanceFamily"`
+	ScaleInThreshold              float64                   `yaml:"ScaleInThreshold" validate:"required"`
+	ProhibitToScaleIn             bool                      `yaml:"ProhibitToScaleIn"`
+	ScalingPolicy                 *ScalingPolicyConfig      `yaml:"ScalingPolicy"`
+	DryRun                        bool                      `yaml:"DryRun"`
+	APIAddr                       string                    `yaml:"APIAddr"`
+
+	// contents of files read by !file
+	secrets []string
+}
+
+// Redacted returns config formatted for logging without secrets
+func (c *Config) Redacted() string {
+	r := *c
+	r.secrets = nil
+	return c.Redact(fmt.Sprintf("%+v", r))
+}
+
+// Redact replaces secrets in s, which are RedisPassword and values read by !file
+func (c *Config) Redact(s string) string {
+	secrets := append([]string{c.RedisPassword}, c.secrets...)
+	// longer ones first not to leave a part of a secret containing another
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		s = strings.Replace(s, secret, "(redacted)", -1)
+		// as formatted in JSON (e.g. changes of config)
+		if j, err := json.Marshal(secret); err == nil {
+			s = strings.Replace(s, string(j[1:len(j)-1]), "(redacted)", -1)
+		}
+	}
+	return s
 }
 
 func (c *Config) FullAutoscalerID() string {
@@ -44,9 +97,13 @@ func (c *Config) FullAutoscalerID() string {
 }
 
 func (c *Config) InstanceVarieties() []InstanceVariety {
+	return c.InstanceVarietiesInSubnets(c.Subnets)
+}
+
+func (c *Config) InstanceVarietiesInSubnets(subnets []Subnet) []InstanceVariety {
 	vs := []InstanceVariety{}
 	for _, t := range c.InstanceTypes {
-		for _, s := range c.Subnets {
+		for _, s := range subnets {
 			v := InstanceVariety{
 				Subnet:       s,
 				InstanceType: t,
@@ -58,27 +115,201 @@ func (c *Config) InstanceVarieties() []InstanceVariety {
 	return vs
 }
 
+// CapacityTable returns capacity of instance types in InstanceCapacityByType
+func (c *Config) CapacityTable() CapacityTable {
+	return CapacityTable(c.InstanceCapacityByType)
+}
+
+// ArchitectureTable returns architecture of instance types in InstanceArchitectureByType
+func (c *Config) ArchitectureTable() ArchitectureTable {
+	return ArchitectureTable(c.InstanceArchitectureByType)
+}
+
 // Validate validates config data
 func (c *Config) Validate() error {
 	validate := validator.New()
-	return validate.Struct(c)
+	err := validate.Struct(c)
+	if err != nil {
+		return err
+	}
+
+	err = c.LaunchConfiguration.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.validateLaunchOverrides()
+	if err != nil {
+		return err
+	}
+
+	err = c.validateLaunchTemplates()
+	if err != nil {
+		return err
+	}
+
+	if c.MaxCapacity > 0 && c.MaxCapacity <= c.MinCapacity {
+		return fmt.Errorf("MaxCapacity must be greater than MinCapacity")
+	}
+
+	if len(c.Subnets) == 0 && len(c.SubnetFilters) == 0 {
+		return fmt.Errorf("either Subnets or SubnetFilters is required")
+	}
+
+	if c.AMICommand == nil && c.AMIResolver == nil {
+		return fmt.Errorf("either AMICommand or AMIResolver is required")
+	}
+	if c.AMIResolver != nil {
+		err = c.AMIResolver.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.ScalingPolicy != nil {
+		err = c.ScalingPolicy.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.validateConsistency()
+}
+
+// validateConsistency validates relations between fields and returns all problems found
+func (c *Config) validateConsistency() error {
+	problems := []string{}
+
+	for _, t := range c.InstanceTypes {
+		if _, ok := c.InstanceCapacityByType[t]; !ok {
+			problems = append(problems, fmt.Sprintf("InstanceCapacityByType has no entry for %s in InstanceTypes", t))
+		}
+		if _, ok := c.BiddingPriceByType[t]; !ok {
+			problems = append(problems, fmt.Sprintf("BiddingPriceByType has no entry for %s in InstanceTypes", t))
+		}
+	}
+
+	durations := map[string]string{
+		"LoopInterval":          c.LoopInterval,
+		"Cooldown":              c.Cooldown,
+		"ScaleOutCooldown":      c.ScaleOutCooldown,
+		"ScaleInCooldown":       c.ScaleInCooldown,
+		"SubnetRefreshInterval": c.SubnetRefreshInterval,
+		"MaxInstanceLifetime":   c.MaxInstanceLifetime,
+		"ReplacementTimeout":    c.ReplacementTimeout,
+	}
+	for k, t := range c.Timers {
+		durations[fmt.Sprintf("Timers[%s].Duration", k)] = t.Duration
+	}
+	names := []string{}
+	for name := range durations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d := durations[name]
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q is not a duration (e.g. 30s, 5m, 1h)", name, d))
+		}
+	}
+
+	// subnets discovered by SubnetFilters are unknown until the first loop
+	if len(c.SubnetFilters) == 0 && len(c.Subnets) > 0 {
+		fm := c.FailureModel()
+		domains := fm.Domains(c.InstanceVarieties())
+		if fm.MaxFailedDomains >= len(domains) {
+			problems = append(problems, fmt.Sprintf("MaxTerminatedVarieties (%d) must be less than the number of failure domains (%d %s in InstanceTypes and Subnets)", fm.MaxFailedDomains, len(domains), fm.Domain))
+		}
+	}
+
+	if c.ScaleInThreshold >= c.MaxCPUUtil {
+		problems = append(problems, fmt.Sprintf("ScaleInThreshold (%v) must be less than MaxCPUUtil (%v)", c.ScaleInThreshold, c.MaxCPUUtil))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
 }
 
-// LoadYAMLConfig loads from YAML file and returns Config
+// LoadYAMLConfig loads from YAML file and returns Config.
+// path can be files separated by comma which are merged in order (see readConfigData).
+// Use LoadYAMLConfigs for a file which defines Groups.
 func LoadYAMLConfig(path string) (*Config, error) {
-	f, err := os.Open(path)
+	configs, err := LoadYAMLConfigs(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) != 1 {
+		return nil, fmt.Errorf("%s: %d groups are defined, one of them has to be selected", path, len(configs))
+	}
+	return configs[0], nil
+}
+
+// LoadYAMLConfigs loads from YAML file and returns a Config of each group in Groups.
+// Each group overrides top-level fields in the same way as files separated by comma.
+// A file without Groups defines only one group.
+func LoadYAMLConfigs(path string) ([]*Config, error) {
+	secrets := configSecrets{}
+	data, err := readConfigData(path, secrets)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	secretList := []string{}
+	for s := range secrets {
+		secretList = append(secretList, s)
+	}
 
-	data, err := ioutil.ReadAll(f)
+	var raw map[interface{}]interface{}
+	err = yaml.Unmarshal(data, &raw)
 	if err != nil {
 		return nil, err
 	}
 
+	groups, ok := raw["Groups"]
+	if !ok {
+		config, err := decodeYAMLConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		config.secrets = secretList
+		return []*Config{config}, nil
+	}
+	delete(raw, "Groups")
+
+	list, ok := groups.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s: Groups must be a non-empty list", path)
+	}
+
+	configs := []*Config{}
+	for i, g := range list {
+		data, err := yaml.Marshal(mergeYAMLValues(raw, g))
+		if err != nil {
+			return nil, err
+		}
+		config, err := decodeYAMLConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Groups[%d]: %s", path, i, err)
+		}
+		config.secrets = secretList
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+func decodeYAMLConfig(data []byte) (*Config, error) {
 	config := Config{}
-	err = yaml.Unmarshal(data, &config)
+	err := yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkUnknownYAMLKeys(data, &config)
 	if err != nil {
 		return nil, err
 	}
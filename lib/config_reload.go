@@ -0,0 +1,172 @@
+package autoscaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ConfigChange is a changed field of config
+type ConfigChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, formatConfigValue(c.Old), formatConfigValue(c.New))
+}
+
+func formatConfigValue(v interface{}) string {
+	if v == nil {
+		return "(none)"
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(j)
+}
+
+// DiffConfig returns changed fields from old to new. Structs and maps are compared field by field
+// (e.g. InstanceCapacityByType[c4.large]) and other values including slices as a whole.
+func DiffConfig(old, new *Config) []ConfigChange {
+	return diffValues("", reflect.ValueOf(*old), reflect.ValueOf(*new))
+}
+
+func diffValues(path string, old, new reflect.Value) []ConfigChange {
+	if old.Kind() == reflect.Ptr && new.Kind() == reflect.Ptr && !old.IsNil() && !new.IsNil() {
+		return diffValues(path, old.Elem(), new.Elem())
+	}
+
+	switch old.Kind() {
+	case reflect.Struct:
+		changes := []ConfigChange{}
+		for i := 0; i < old.NumField(); i++ {
+			f := old.Type().Field(i)
+			if f.PkgPath != "" {
+				// unexported
+				continue
+			}
+			p := f.Name
+			if path != "" {
+				p = path + "." + f.Name
+			}
+			changes = append(changes, diffValues(p, old.Field(i), new.Field(i))...)
+		}
+		return changes
+	case reflect.Map:
+		if old.Type().Key().Kind() != reflect.String {
+			break
+		}
+		keys := map[string]bool{}
+		for _, k := range old.MapKeys() {
+			keys[k.String()] = true
+		}
+		for _, k := range new.MapKeys() {
+			keys[k.String()] = true
+		}
+		sorted := []string{}
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		changes := []ConfigChange{}
+		for _, k := range sorted {
+			key := reflect.ValueOf(k).Convert(old.Type().Key())
+			p := fmt.Sprintf("%s[%s]", path, k)
+			o, n := old.MapIndex(key), new.MapIndex(key)
+			switch {
+			case !o.IsValid():
+				changes = append(changes, ConfigChange{Field: p, New: n.Interface()})
+			case !n.IsValid():
+				changes = append(changes, ConfigChange{Field: p, Old: o.Interface()})
+			default:
+				changes = append(changes, diffValues(p, o, n)...)
+			}
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(old.Interface(), new.Interface()) {
+		return nil
+	}
+	return []ConfigChange{{Field: path, Old: old.Interface(), New: new.Interface()}}
+}
+
+// EnableConfigReload makes the loop reload config by load on SIGHUP
+func (r *Runner) EnableConfigReload(load func() (*Config, error)) {
+	r.loadConfig = load
+}
+
+// reloadConfig replaces config with a newly loaded one between loops.
+// The current config is kept if the new one is invalid.
+func (r *Runner) reloadConfig(ctx context.Context) error {
+	if r.loadConfig == nil {
+		logf(ctx, "[WARN] config reload is not enabled")
+		return nil
+	}
+
+	logf(ctx, "[INFO] reloading config")
+	config, err := r.loadConfig()
+	if err != nil {
+		logf(ctx, "[ERROR] keep the current config because the new one is invalid: %s", err)
+		return nil
+	}
+	if _, err := time.ParseDuration(config.LoopInterval); err != nil {
+		logf(ctx, "[ERROR] keep the current config because LoopInterval is invalid: %s", err)
+		return nil
+	}
+
+	// the status store and the API server are set up on start
+	for _, c := range []struct {
+		name     string
+		old, new *string
+	}{
+		{"AutoscalerID", &r.config.AutoscalerID, &config.AutoscalerID},
+		{"RedisHost", &r.config.RedisHost, &config.RedisHost},
+		{"RedisPassword", &r.config.RedisPassword, &config.RedisPassword},
+		{"APIAddr", &r.config.APIAddr, &config.APIAddr},
+	} {
+		if *c.old != *c.new {
+			logf(ctx, "[WARN] changing %s requires restart, the current value is kept", c.name)
+			*c.new = *c.old
+		}
+	}
+
+	amiResolvers, err := NewAMIResolvers(config, r.ec2Client, r.status)
+	if err != nil {
+		logf(ctx, "[ERROR] keep the current config because AMI resolvers cannot be set up: %s", err)
+		return nil
+	}
+
+	changes := DiffConfig(r.config, config)
+	if len(changes) == 0 {
+		logf(ctx, "[INFO] config is not changed")
+		return nil
+	}
+	for _, c := range changes {
+		logf(ctx, "[INFO] config changed: %s", config.Redact(r.config.Redact(c.String())))
+	}
+
+	// the EC2 client shares config, so it is replaced in place
+	*r.config = *config
+	r.amiResolvers = amiResolvers
+	r.subnetsResolvedAt = time.Time{}
+
+	details := []map[string]interface{}{}
+	for _, c := range changes {
+		details = append(details, map[string]interface{}{
+			"Field": c.Field,
+			"Old":   c.Old,
+			"New":   c.New,
+		})
+	}
+	return r.runHookCommands(ctx, "configReloaded", fmt.Sprintf("Config is reloaded (%d changes)", len(changes)), map[string]interface{}{
+		"Changes": details,
+	})
+}
@@ -0,0 +1,92 @@
+package autoscaler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := configForTest("90")
+	new := configForTest("90")
+	assert.Empty(t, DiffConfig(old, new))
+
+	new.MaxCPUUtil = old.MaxCPUUtil + 10
+	new.InstanceTypes = append(new.InstanceTypes, "c5.large")
+	new.InstanceCapacityByType = map[string]float64{}
+	for k, v := range old.InstanceCapacityByType {
+		new.InstanceCapacityByType[k] = v
+	}
+	new.InstanceCapacityByType["c5.large"] = 2
+
+	changes := DiffConfig(old, new)
+	fields := []string{}
+	for _, c := range changes {
+		fields = append(fields, c.Field)
+	}
+	assert.Equal(t, []string{"InstanceCapacityByType[c5.large]", "InstanceTypes", "MaxCPUUtil"}, fields)
+	assert.Equal(t, "InstanceCapacityByType[c5.large]: (none) -> 2", changes[0].String())
+	assert.Equal(t, fmt.Sprintf("MaxCPUUtil: %v -> %v", old.MaxCPUUtil, new.MaxCPUUtil), changes[2].String())
+}
+
+func TestReloadConfig(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, _, _ := newScalePlanTestRunner(t, &now)
+	config := r.config
+
+	// an invalid config is not applied
+	r.EnableConfigReload(func() (*Config, error) {
+		return nil, fmt.Errorf("invalid")
+	})
+	assert.NoError(t, r.reloadConfig(context.Background()))
+	assert.Equal(t, config, r.config)
+
+	newConfig := *config
+	newConfig.MaxCPUUtil = config.MaxCPUUtil - 10
+	newConfig.LoopInterval = "1m"
+	newConfig.RedisHost = "other:6379"
+	newConfig.InstanceCapacityByType = map[string]float64{"c4.large": 3, "m4.large": 3}
+	r.EnableConfigReload(func() (*Config, error) {
+		c := newConfig
+		return &c, nil
+	})
+	assert.NoError(t, r.reloadConfig(context.Background()))
+
+	// config is replaced in place
+	assert.True(t, config == r.config)
+	assert.Equal(t, newConfig.MaxCPUUtil, r.config.MaxCPUUtil)
+	assert.NotEqual(t, "other:6379", r.config.RedisHost)
+
+	capacity, err := InstanceVariety{InstanceType: "c4.large"}.Capacity(r.config.CapacityTable())
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, capacity)
+}
+
+func TestReloadConfigRedactsSecrets(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(buf)
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, _, _ := newScalePlanTestRunner(t, &now)
+	r.config.NameTag = "old-secret"
+	r.config.secrets = []string{"old-secret"}
+
+	newConfig := *r.config
+	newConfig.NameTag = "new-secret"
+	newConfig.secrets = []string{"new-secret"}
+	r.EnableConfigReload(func() (*Config, error) {
+		c := newConfig
+		return &c, nil
+	})
+	assert.NoError(t, r.reloadConfig(context.Background()))
+
+	assert.Equal(t, "new-secret", r.config.NameTag)
+	assert.Contains(t, buf.String(), `config changed: NameTag: "(redacted)" -> "(redacted)"`)
+	assert.NotContains(t, buf.String(), "secret")
+}
@@ -0,0 +1,222 @@
+package autoscaler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config files are preprocessed before they are decoded:
+//
+//   - "key: !env value" and "- !env value" are replaced with value where ${NAME} and
+//     ${NAME:-default} are replaced with environment variables ($$ is $). Other values
+//     are not expanded, so that UserData can contain shell variables.
+//   - "key: !include path", "- key: !include path" and "- !include path" are replaced with YAML in the file
+//   - "key: !file path" is replaced with the content of the file as a string (e.g. secrets),
+//     which is redacted in logs of config
+//
+// Tags in block scalars (e.g. "UserData: |") are kept as they are.
+// Paths are relative to the file in which they are written.
+// Multiple files separated by comma are merged in order (later ones override earlier ones).
+
+const maxConfigIncludeDepth = 10
+
+// configSecrets collects contents of files read by !file
+type configSecrets map[string]bool
+
+// readConfigData reads config files in paths separated by comma and returns merged YAML
+func readConfigData(paths string, secrets configSecrets) ([]byte, error) {
+	files := strings.Split(paths, ",")
+	if len(files) == 1 {
+		return readConfigFile(files[0], 0, secrets)
+	}
+
+	var merged interface{}
+	for _, f := range files {
+		data, err := readConfigFile(strings.TrimSpace(f), 0, secrets)
+		if err != nil {
+			return nil, err
+		}
+
+		var v interface{}
+		err = yaml.Unmarshal(data, &v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f, err)
+		}
+		merged = mergeYAMLValues(merged, v)
+	}
+
+	return yaml.Marshal(merged)
+}
+
+func readConfigFile(path string, depth int, secrets configSecrets) ([]byte, error) {
+	if depth > maxConfigIncludeDepth {
+		return nil, fmt.Errorf("%s: too deep includes", path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = resolveConfigTags(data, filepath.Dir(path), depth, secrets)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return data, nil
+}
+
+var configEnvPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandConfigEnv replaces environment variables in a value of !env
+func expandConfigEnv(value string) (string, error) {
+	var err error
+	expanded := configEnvPattern.ReplaceAllStringFunc(value, func(s string) string {
+		if s == "$$" {
+			return "$"
+		}
+
+		m := configEnvPattern.FindStringSubmatch(s)
+		v, ok := os.LookupEnv(m[1])
+		if m[2] != "" {
+			if v == "" {
+				v = m[3]
+			}
+		} else if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set (use ${%s:-default} for a default value)", m[1], m[1])
+		}
+		return v
+	})
+	return expanded, err
+}
+
+// yamlScalar returns v as a YAML scalar. Numbers and booleans are kept as they are
+// and the others are quoted so that they are not parsed as YAML structure.
+func yamlScalar(v string) string {
+	var parsed interface{}
+	if err := yaml.Unmarshal([]byte(v), &parsed); err == nil {
+		switch parsed.(type) {
+		case int, int64, uint64, float64, bool:
+			return v
+		}
+	}
+	return strconv.Quote(v)
+}
+
+var configTagPattern = regexp.MustCompile(`^(\s*)([^#]*?:|-)\s+!(include|file|env)\s+(.*?)\s*$`)
+var configBlockScalarPattern = regexp.MustCompile(`^(\s*)(?:[^#]*?:|-)\s+[|>][-+0-9]*\s*(?:#.*)?$`)
+
+// resolveConfigTags replaces !env tags with values and !include and !file tags with contents of files.
+// Contents of files read by !file are added to secrets.
+func resolveConfigTags(data []byte, dir string, depth int, secrets configSecrets) ([]byte, error) {
+	out := []string{}
+	// lines indented deeper than blockIndent are in a block scalar
+	blockIndent := -1
+	for i, line := range strings.Split(string(data), "\n") {
+		if blockIndent >= 0 {
+			if strings.TrimSpace(line) == "" || len(line)-len(strings.TrimLeft(line, " \t")) > blockIndent {
+				out = append(out, line)
+				continue
+			}
+			blockIndent = -1
+		}
+		if b := configBlockScalarPattern.FindStringSubmatch(line); b != nil {
+			blockIndent = len(b[1])
+			out = append(out, line)
+			continue
+		}
+
+		m := configTagPattern.FindStringSubmatch(line)
+		if m == nil {
+			out = append(out, line)
+			continue
+		}
+		indent, key, tag, path := m[1], m[2], m[3], m[4]
+		if tag == "env" {
+			v, err := expandConfigEnv(m[4])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+			out = append(out, fmt.Sprintf("%s%s %s", indent, key, yamlScalar(v)))
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		switch tag {
+		case "file":
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+			v := strings.TrimRight(string(b), "\r\n")
+			if v != "" {
+				secrets[v] = true
+			}
+			out = append(out, fmt.Sprintf("%s%s %s", indent, key, strconv.Quote(v)))
+		case "include":
+			included, err := readConfigFile(path, depth+1, secrets)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+
+			lines := []string{}
+			for _, l := range strings.Split(strings.TrimRight(string(included), "\n"), "\n") {
+				if strings.TrimSpace(l) == "" || strings.HasPrefix(strings.TrimSpace(l), "#") {
+					continue
+				}
+				lines = append(lines, l)
+			}
+			if len(lines) == 0 {
+				return nil, fmt.Errorf("line %d: %s is empty", i+1, path)
+			}
+
+			if key == "-" {
+				// the first line follows "- " and the rest are aligned with it
+				out = append(out, indent+"- "+lines[0])
+				for _, l := range lines[1:] {
+					out = append(out, indent+"  "+l)
+				}
+			} else {
+				// included lines are nested under the key, which follows "- " in a list entry
+				keyIndent := indent + strings.Repeat(" ", len(key)-len(strings.TrimLeft(key, "- ")))
+				out = append(out, indent+key)
+				for _, l := range lines {
+					out = append(out, keyIndent+"  "+l)
+				}
+			}
+		}
+	}
+
+	return []byte(strings.Join(out, "\n")), nil
+}
+
+// mergeYAMLValues merges overlay into base. Mappings are merged recursively
+// and other values in overlay replace ones in base.
+func mergeYAMLValues(base, overlay interface{}) interface{} {
+	b, ok := base.(map[interface{}]interface{})
+	if !ok {
+		return overlay
+	}
+	o, ok := overlay.(map[interface{}]interface{})
+	if !ok {
+		return overlay
+	}
+
+	merged := map[interface{}]interface{}{}
+	for k, v := range b {
+		merged[k] = v
+	}
+	for k, v := range o {
+		merged[k] = mergeYAMLValues(merged[k], v)
+	}
+	return merged
+}
@@ -0,0 +1,136 @@
+package autoscaler
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// checkUnknownYAMLKeys returns an error listing keys in data which no field of out's type is decoded from
+func checkUnknownYAMLKeys(data []byte, out interface{}) error {
+	var raw interface{}
+	err := yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	unknown := unknownYAMLKeys("", raw, reflect.TypeOf(out))
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+
+	reasons := []string{}
+	for _, k := range unknown {
+		name := k[strings.LastIndex(k, ".")+1:]
+		if reason, ok := unsupportedLaunchKeys[name]; ok && strings.HasPrefix(k, "LaunchConfiguration.") {
+			reasons = append(reasons, fmt.Sprintf("%s is not supported: %s", k, reason))
+		}
+	}
+	if len(reasons) > 0 {
+		return fmt.Errorf("unknown keys in config: %s (%s)", strings.Join(unknown, ", "), strings.Join(reasons, "; "))
+	}
+	return fmt.Errorf("unknown keys in config: %s", strings.Join(unknown, ", "))
+}
+
+// unsupportedLaunchKeys are launch parameters which users may expect in LaunchConfiguration
+// but cannot be set to spot instance requests (see "Known limitations" in README.md)
+var unsupportedLaunchKeys = map[string]string{
+	"MetadataOptions":  "launch specifications of spot instance requests have no metadata options (IMDSv2)",
+	"Ipv6AddressCount": "RequestSpotInstances of the vendored aws-sdk-go (1.5.2) has no IPv6 addresses",
+	"Ipv6Addresses":    "RequestSpotInstances of the vendored aws-sdk-go (1.5.2) has no IPv6 addresses",
+	"KmsKeyId":         "RequestSpotInstances of the vendored aws-sdk-go (1.5.2) has no KMS keys of EBS",
+	"KMSKeyID":         "RequestSpotInstances of the vendored aws-sdk-go (1.5.2) has no KMS keys of EBS",
+	"Tenancy":          "RequestSpotInstances of the vendored aws-sdk-go (1.5.2) has no tenancy",
+}
+
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+func unknownYAMLKeys(path string, raw interface{}, t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(yamlUnmarshalerType) {
+		// decoded by its own rules
+		return nil
+	}
+
+	unknown := []string{}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		fields := yamlFields(t)
+		for k, v := range m {
+			key := fmt.Sprint(k)
+			p := key
+			if path != "" {
+				p = path + "." + key
+			}
+			ft, ok := fields[key]
+			if !ok {
+				unknown = append(unknown, p)
+				continue
+			}
+			unknown = append(unknown, unknownYAMLKeys(p, v, ft)...)
+		}
+	case reflect.Map:
+		m, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for k, v := range m {
+			unknown = append(unknown, unknownYAMLKeys(fmt.Sprintf("%s[%v]", path, k), v, t.Elem())...)
+		}
+	case reflect.Slice, reflect.Array:
+		s, ok := raw.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, v := range s {
+			unknown = append(unknown, unknownYAMLKeys(fmt.Sprintf("%s[%d]", path, i), v, t.Elem())...)
+		}
+	}
+	return unknown
+}
+
+// yamlFields returns types of fields by keys as yaml.v2 decodes them
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		inline := false
+		for _, o := range opts[1:] {
+			if o == "inline" {
+				inline = true
+			}
+		}
+		if inline {
+			for k, ft := range yamlFields(f.Type) {
+				fields[k] = ft
+			}
+			continue
+		}
+
+		key := opts[0]
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		fields[key] = f.Type
+	}
+	return fields
+}
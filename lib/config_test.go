@@ -0,0 +1,219 @@
+package autoscaler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadSampleConfig(t *testing.T) {
+	config, err := LoadYAMLConfig("../example/config.sample.yml")
+	assert.NoError(t, err)
+	assert.NoError(t, config.Validate())
+}
+
+func TestLoadYAMLConfigRejectsUnknownKeys(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+AutoscalerID: test
+MaxCPUUtl: 50
+Subnets:
+  - SubnetID: subnet-a
+    AvailabilityZone: az-a
+    LaunchMethod: spot
+Timers:
+  t:
+    After: LaunchingInstances
+    Duration: 1m
+    Command:
+      Command: echo
+      Arg: [a]
+`)
+	assert.NoError(t, err)
+	f.Close()
+
+	_, err = LoadYAMLConfig(f.Name())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown keys in config: MaxCPUUtl, Subnets[0].LaunchMethod, Timers[t].Command.Arg")
+	}
+}
+
+func TestLoadYAMLConfigRejectsUnsupportedLaunchKeys(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+AutoscalerID: test
+LaunchConfiguration:
+  KeyName: key
+  SecurityGroupIDs: [sg-abc]
+  MetadataOptions:
+    HttpTokens: required
+  BlockDeviceMappings:
+    - DeviceName: /dev/xvda
+      EBS:
+        Encrypted: true
+        KmsKeyId: alias/ebs
+`)
+	assert.NoError(t, err)
+	f.Close()
+
+	_, err = LoadYAMLConfig(f.Name())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "LaunchConfiguration.MetadataOptions is not supported: launch specifications of spot instance requests have no metadata options (IMDSv2)")
+		assert.Contains(t, err.Error(), "LaunchConfiguration.BlockDeviceMappings[0].EBS.KmsKeyId is not supported")
+	}
+}
+
+func TestValidateConsistency(t *testing.T) {
+	c := configForTest("90")
+	c.InstanceTypes = []string{"c4.large", "m4.large"}
+	assert.NoError(t, c.validateConsistency())
+
+	c.InstanceTypes = append(c.InstanceTypes, "c5.large")
+	c.LoopInterval = "1 minute"
+	c.ScaleInThreshold = c.MaxCPUUtil
+	c.MaxTerminatedVarieties = len(c.InstanceVarieties()) + 1
+
+	err := c.validateConsistency()
+	if assert.Error(t, err) {
+		msg := err.Error()
+		assert.Contains(t, msg, "InstanceCapacityByType has no entry for c5.large")
+		assert.Contains(t, msg, "BiddingPriceByType has no entry for c5.large")
+		assert.Contains(t, msg, `LoopInterval "1 minute" is not a duration`)
+		assert.Contains(t, msg, "MaxTerminatedVarieties")
+		assert.Contains(t, msg, "ScaleInThreshold")
+	}
+}
+
+func TestLoadYAMLConfigWithEnvIncludesAndOverlays(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"base.yml": `
+# ${NOT_SET} in comments is ignored
+AutoscalerID: !env ${SPOTSCALER_TEST_ID}
+RedisHost: !env ${SPOTSCALER_TEST_REDIS:-localhost:6379}
+RedisPassword: !file secrets/redis
+MaxCPUUtil: !env ${SPOTSCALER_TEST_MAX_CPU_UTIL:-50}
+InstanceCapacityByType:
+  c4.large: 2
+  m4.large: 2
+Subnets:
+  - !include subnet.yml
+NameTag: !env cost$$center: ${SPOTSCALER_TEST_ID}
+LaunchConfiguration:
+  KeyName: key
+  SecurityGroupIDs: [sg-abc]
+  UserData: |
+    #!/bin/sh
+    echo "${HOSTNAME:-unknown} ${NOT_SET}" > /tmp/pid.$$
+`,
+		"subnet.yml": `
+SubnetID: !env subnet-${SPOTSCALER_TEST_ID}
+AvailabilityZone: ap-northeast-1a
+`,
+		"secrets/redis": "p@ss word\n",
+		"prod.yml": `
+MaxCPUUtil: 70
+InstanceCapacityByType:
+  m4.large: 3
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	os.Setenv("SPOTSCALER_TEST_ID", "abc")
+	defer os.Unsetenv("SPOTSCALER_TEST_ID")
+
+	base := filepath.Join(dir, "base.yml")
+	config, err := LoadYAMLConfig(base)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", config.AutoscalerID)
+	assert.Equal(t, "localhost:6379", config.RedisHost)
+	assert.Equal(t, "p@ss word", config.RedisPassword)
+	assert.Equal(t, 50.0, config.MaxCPUUtil)
+	assert.Equal(t, []Subnet{{SubnetID: "subnet-abc", AvailabilityZone: "ap-northeast-1a"}}, config.Subnets)
+	assert.Equal(t, "cost$center: abc", config.NameTag)
+	// values without !env are not expanded
+	assert.Equal(t, "#!/bin/sh\necho \"${HOSTNAME:-unknown} ${NOT_SET}\" > /tmp/pid.$$\n", config.LaunchConfiguration.UserData)
+
+	config, err = LoadYAMLConfig(base + "," + filepath.Join(dir, "prod.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, 70.0, config.MaxCPUUtil)
+	assert.Equal(t, map[string]float64{"c4.large": 2, "m4.large": 3}, config.InstanceCapacityByType)
+	assert.Equal(t, "abc", config.AutoscalerID)
+
+	os.Unsetenv("SPOTSCALER_TEST_ID")
+	_, err = LoadYAMLConfig(base)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "environment variable SPOTSCALER_TEST_ID is not set")
+	}
+}
+
+func TestResolveConfigTagsInListEntriesAndBlockScalars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "values.yml"), []byte("Name: Role\nValues: [web]\n"), 0644))
+
+	data, err := resolveConfigTags([]byte(`
+WorkingInstanceFilters:
+  - Filter: !include values.yml
+UserData: |
+  Key: !include values.yml
+  echo !env ${NOT_SET}
+  - !include values.yml
+NameTag: !env name
+`), dir, 0, configSecrets{})
+	assert.NoError(t, err)
+
+	var v map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal(data, &v))
+	assert.Equal(t, []interface{}{
+		map[interface{}]interface{}{
+			"Filter": map[interface{}]interface{}{"Name": "Role", "Values": []interface{}{"web"}},
+		},
+	}, v["WorkingInstanceFilters"])
+	assert.Equal(t, "Key: !include values.yml\necho !env ${NOT_SET}\n- !include values.yml\n", v["UserData"])
+	assert.Equal(t, "name", v["NameTag"])
+}
+
+func TestRedactValuesFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("to\"ken\n"), 0644))
+	path := filepath.Join(dir, "config.yml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`
+AutoscalerID: test
+RedisPassword: password
+NameTag: !file token
+`), 0644))
+
+	config, err := LoadYAMLConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "to\"ken", config.NameTag)
+
+	dumped := config.Redacted()
+	assert.Contains(t, dumped, "NameTag:(redacted)")
+	assert.NotContains(t, dumped, "ken")
+	assert.NotContains(t, dumped, "password")
+
+	changed := *config
+	changed.NameTag = "other"
+	for _, c := range DiffConfig(config, &changed) {
+		assert.Equal(t, `NameTag: "(redacted)" -> "other"`, config.Redact(c.String()))
+	}
+}
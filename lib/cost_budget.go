@@ -0,0 +1,265 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LoadOndemandPrices loads hourly prices of on-demand instances by instance type from a YAML file
+// (e.g. "m4.large: 0.129")
+func LoadOndemandPrices(path string) (map[string]float64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := map[string]float64{}
+	err = yaml.Unmarshal(data, &prices)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s failed: %s", path, err)
+	}
+	return prices, nil
+}
+
+// HourlyCost returns hourly cost of instances in c by spot prices of each variety
+func (c InstanceCapacity) HourlyCost(prices map[InstanceVariety]float64, capacities CapacityTable) (float64, error) {
+	cost := 0.0
+	for v, capacity := range c {
+		if capacity == 0 {
+			continue
+		}
+		p, ok := prices[v]
+		if !ok {
+			return 0.0, fmt.Errorf("Spot price of %v is unknown", v)
+		}
+		cap, err := v.Capacity(capacities)
+		if err != nil {
+			return 0.0, err
+		}
+		cost += math.Ceil(capacity/cap) * p
+	}
+	return cost, nil
+}
+
+// CapCapacityByCost returns desired if its hourly cost is at most maxCost. Otherwise it returns
+// the allocation, in the order instances are added by the capacity solver, whose total is
+// the largest with hourly cost at most maxCost.
+func CapCapacityByCost(varieties []InstanceVariety, capacities CapacityTable, desired InstanceCapacity, maxCost float64, prices map[InstanceVariety]float64, failureModel FailureModel) (InstanceCapacity, error) {
+	cost, err := desired.HourlyCost(prices, capacities)
+	if err != nil {
+		return nil, err
+	}
+	if cost <= maxCost {
+		return desired, nil
+	}
+
+	solver, err := newCapacitySolver(varieties, capacities, failureModel)
+	if err != nil {
+		return nil, err
+	}
+
+	var costErr error
+	k := firstSatisfying(func(k int) bool {
+		c, err := solver.capacity(k).HourlyCost(prices, capacities)
+		if err != nil {
+			costErr = err
+			return true
+		}
+		return c > maxCost
+	})
+	if costErr != nil {
+		return nil, costErr
+	}
+	if k < 0 {
+		k = MaxCapacitySolverIterations + 1
+	}
+	if k == 0 {
+		return solver.capacity(0), nil
+	}
+	return solver.capacity(k - 1), nil
+}
+
+// hourlyCost returns hourly cost of instances by spot prices and on-demand prices.
+// Bidding prices are used for spot instances in varieties whose price is not fetched.
+// On-demand instances whose price is unknown are excluded with a warning, since they are
+// not launched by spotscaler and failing every loop would stop scaling.
+func (r *Runner) hourlyCost(ctx context.Context, instances Instances, spotPrices map[InstanceVariety]float64, ondemandPrices map[string]float64) (float64, error) {
+	cost := 0.0
+	unknown := map[string]int{}
+	for _, i := range instances.Ondemand() {
+		p, ok := ondemandPrices[*i.InstanceType]
+		if !ok {
+			unknown[*i.InstanceType]++
+			continue
+		}
+		cost += p
+	}
+	if len(unknown) > 0 {
+		types := []string{}
+		for t, n := range unknown {
+			types = append(types, fmt.Sprintf("%s * %d", t, n))
+		}
+		sort.Strings(types)
+		logf(ctx, "[WARN] on-demand prices of %s are not in OndemandPricesFile, and the instances are excluded from hourly cost", strings.Join(types, ", "))
+	}
+	for _, i := range instances.Spot() {
+		p, ok := spotPrices[i.Variety()]
+		if !ok {
+			p, ok = r.config.BiddingPriceByType[*i.InstanceType]
+		}
+		if !ok {
+			return 0.0, fmt.Errorf("Spot price of %s is unknown", *i.InstanceType)
+		}
+		cost += p
+	}
+	return cost, nil
+}
+
+// updateCostMetrics updates metrics of estimated hourly cost of working instances and
+// returns hourly cost of on-demand instances. It does nothing unless MaxHourlyCost is set.
+func (r *Runner) updateCostMetrics(ctx context.Context, workingInstances Instances, spotPrices map[InstanceVariety]float64) (float64, error) {
+	if r.config.MaxHourlyCost <= 0 {
+		return 0.0, nil
+	}
+
+	ondemandPrices := map[string]float64{}
+	if r.config.OndemandPricesFile != "" {
+		var err error
+		ondemandPrices, err = LoadOndemandPrices(r.config.OndemandPricesFile)
+		if err != nil {
+			return 0.0, err
+		}
+	}
+
+	ondemandCost, err := r.hourlyCost(ctx, workingInstances.Ondemand(), spotPrices, ondemandPrices)
+	if err != nil {
+		return 0.0, err
+	}
+	spotCost, err := r.hourlyCost(ctx, workingInstances.Spot(), spotPrices, ondemandPrices)
+	if err != nil {
+		return 0.0, err
+	}
+	logf(ctx, "[DEBUG] estimated hourly cost: %f USD (on-demand: %f USD, spot: %f USD)", ondemandCost+spotCost, ondemandCost, spotCost)
+
+	r.api.UpdateMetrics(map[string]float64{
+		"estimated_hourly_cost": ondemandCost + spotCost,
+		"hourly_cost_headroom":  r.config.MaxHourlyCost - ondemandCost - spotCost,
+	})
+
+	return ondemandCost, nil
+}
+
+// budgetDesiredCapacity trims desired capacity so that hourly cost of on-demand instances and
+// desired spot instances fits in MaxHourlyCost and notifies hooks of "costCapped" event if it is trimmed
+func (r *Runner) budgetDesiredCapacity(ctx context.Context, varieties []InstanceVariety, desired InstanceCapacity, ondemandCost float64, spotPrices map[InstanceVariety]float64) (InstanceCapacity, error) {
+	if r.config.MaxHourlyCost <= 0 {
+		return desired, nil
+	}
+
+	capped, err := CapCapacityByCost(varieties, r.config.CapacityTable(), desired, r.config.MaxHourlyCost-ondemandCost, spotPrices, r.config.FailureModel())
+	if err != nil {
+		return nil, err
+	}
+
+	if capped.Total() == desired.Total() {
+		return desired, nil
+	}
+
+	desiredCost, err := desired.HourlyCost(spotPrices, r.config.CapacityTable())
+	if err != nil {
+		return nil, err
+	}
+	cappedCost, err := capped.HourlyCost(spotPrices, r.config.CapacityTable())
+	if err != nil {
+		return nil, err
+	}
+
+	logf(ctx, "[WARN] desired capacity %f (%f USD/h) is capped to %f (%f USD/h) by MaxHourlyCost", desired.Total(), ondemandCost+desiredCost, capped.Total(), ondemandCost+cappedCost)
+	logf(ctx, "[INFO] capped desired capacity: %v", capped)
+
+	err = r.runHookCommands(ctx, "costCapped", "Desired capacity is capped by MaxHourlyCost", map[string]interface{}{
+		"MaxHourlyCost":   r.config.MaxHourlyCost,
+		"DesiredCost":     ondemandCost + desiredCost,
+		"CappedCost":      ondemandCost + cappedCost,
+		"DesiredCapacity": desired.Total(),
+		"CappedCapacity":  capped.Total(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return capped, nil
+}
+
+// budgetChangeCount trims launches in change so that hourly cost of working instances after
+// the change fits in MaxHourlyCost. budgetDesiredCapacity caps the allocation, but launches
+// exceed the budget when instances out of it are not terminated (e.g. blocked by cooldown, or
+// kept while capacity moves to other varieties). Launches are trimmed from the variety with
+// the most launches left.
+func (r *Runner) budgetChangeCount(ctx context.Context, workingInstances Instances, change map[InstanceVariety]int64, ondemandCost float64, spotPrices map[InstanceVariety]float64) (map[InstanceVariety]int64, error) {
+	if r.config.MaxHourlyCost <= 0 {
+		return change, nil
+	}
+
+	priceOf := func(v InstanceVariety) (float64, error) {
+		if p, ok := spotPrices[v]; ok {
+			return p, nil
+		}
+		if p, ok := r.config.BiddingPriceByType[v.InstanceType]; ok {
+			return p, nil
+		}
+		return 0.0, fmt.Errorf("Spot price of %s is unknown", v.InstanceType)
+	}
+
+	cost, err := r.hourlyCost(ctx, workingInstances.Spot(), spotPrices, nil)
+	if err != nil {
+		return nil, err
+	}
+	cost += ondemandCost
+
+	trimmed := map[InstanceVariety]int64{}
+	launching := []InstanceVariety{}
+	for v, c := range change {
+		p, err := priceOf(v)
+		if err != nil {
+			return nil, err
+		}
+		cost += float64(c) * p
+		trimmed[v] = c
+		if c > 0 {
+			launching = append(launching, v)
+		}
+	}
+	sort.Sort(SortInstanceVarietiesByCapacity{Varieties: launching, Capacities: r.config.CapacityTable()})
+
+	// allow rounding errors of summed prices
+	for cost > r.config.MaxHourlyCost+1e-9 {
+		var most *InstanceVariety
+		for i, v := range launching {
+			if trimmed[v] > 0 && (most == nil || trimmed[v] > trimmed[*most]) {
+				most = &launching[i]
+			}
+		}
+		if most == nil {
+			break
+		}
+
+		p, err := priceOf(*most)
+		if err != nil {
+			return nil, err
+		}
+		trimmed[*most]--
+		cost -= p
+		if trimmed[*most] == 0 {
+			delete(trimmed, *most)
+		}
+	}
+
+	return trimmed, nil
+}
@@ -0,0 +1,151 @@
+package autoscaler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapCapacityByCost(t *testing.T) {
+	capacities := CapacityTable{"t1": 10, "t2": 10}
+	varieties := []InstanceVariety{
+		{InstanceType: "t1", Subnet: Subnet{SubnetID: "subnet-a"}},
+		{InstanceType: "t2", Subnet: Subnet{SubnetID: "subnet-a"}},
+	}
+	prices := map[InstanceVariety]float64{varieties[0]: 0.1, varieties[1]: 0.2}
+	desired := InstanceCapacity{varieties[0]: 30, varieties[1]: 30}
+
+	cost, err := desired.HourlyCost(prices, capacities)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.9, cost, 1e-9)
+
+	actual, err := CapCapacityByCost(varieties, capacities, desired, 1.0, prices, varietyFailureModel(1))
+	assert.NoError(t, err)
+	assert.Equal(t, desired, actual)
+
+	actual, err = CapCapacityByCost(varieties, capacities, desired, 0.65, prices, varietyFailureModel(1))
+	assert.NoError(t, err)
+	assert.Equal(t, InstanceCapacity{varieties[0]: 20, varieties[1]: 20}, actual)
+
+	actual, err = CapCapacityByCost(varieties, capacities, desired, 0, prices, varietyFailureModel(1))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, actual.Total())
+}
+
+func TestScaleOutCappedByMaxHourlyCost(t *testing.T) {
+	f, err := ioutil.TempFile("", "ondemand-prices")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("c4.large: 0.5\n")
+	assert.NoError(t, err)
+	f.Close()
+
+	config := simulationConfig(configForTest("90"))
+	config.MaxHourlyCost = 0.8
+	config.OndemandPricesFile = f.Name()
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	ondemand := spotInstanceForTest("i-1", "c4.large", "ami-abc")
+	ondemand.SpotInstanceRequestId = nil
+	instances := Instances{ondemand, spotInstanceForTest("i-2", "c4.large", "ami-abc")}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(90))
+	assert.NoError(t, err)
+
+	err = r.scale(context.Background())
+	assert.NoError(t, err)
+
+	// cost before scaling is 0.5 USD of the on-demand instance and 0.1 USD of the spot one
+	assert.InDelta(t, 0.6, r.api.metrics["estimated_hourly_cost"], 1e-9)
+	assert.InDelta(t, 0.2, r.api.metrics["hourly_cost_headroom"], 1e-9)
+
+	// only 3 spot instances fit in the budget
+	working, _ := ec2Client.DescribeWorkingInstances(context.Background())
+	assert.Len(t, working.Spot(), 3)
+}
+
+func TestScaleWithUnknownOndemandPrice(t *testing.T) {
+	config := simulationConfig(configForTest("90"))
+	config.MaxHourlyCost = 0.8
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	ondemand := spotInstanceForTest("i-1", "c4.large", "ami-abc")
+	ondemand.SpotInstanceRequestId = nil
+	instances := Instances{ondemand, spotInstanceForTest("i-2", "c4.large", "ami-abc")}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(90))
+	assert.NoError(t, err)
+
+	// the on-demand instance without price is excluded instead of failing the loop
+	err = r.scale(context.Background())
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.1, r.api.metrics["estimated_hourly_cost"], 1e-9)
+	assert.NotEmpty(t, ec2Client.Changes)
+}
+
+func TestLaunchesCappedByMaxHourlyCostOfWorkingInstances(t *testing.T) {
+	config := simulationConfig(configForTest("50"))
+	config.MaxHourlyCost = 0.5
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	instances := Instances{}
+	for _, id := range []string{"i-1", "i-2", "i-3", "i-4"} {
+		instances = append(instances, spotInstanceForTest(id, "c4.large", "ami-abc"))
+	}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(50))
+	assert.NoError(t, err)
+
+	// capacity in the worst case of 20 is balanced into c4.large and m4.large, which costs 0.4 USD/h in total
+	o := &ScalingOverride{}
+	assert.NoError(t, o.SetCapacity(20, now.Add(time.Hour), now))
+	assert.NoError(t, status.StoreScalingOverride(o))
+
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+	d := plan.Decision
+	assert.Empty(t, d.CappedBy)
+
+	// c4.large instances are kept while capacity moves to m4.large, so only one of the launches fits in 0.5 USD/h
+	if assert.Len(t, d.Changes, 1) {
+		assert.Equal(t, "m4.large", d.Changes[0].Variety.InstanceType)
+		assert.Equal(t, int64(1), d.Changes[0].Count)
+	}
+	rules := map[string]int64{}
+	for _, b := range d.Blocked {
+		rules[b.Rule] += b.Count
+	}
+	assert.Equal(t, map[string]int64{BlockedByMaxHourlyCost: 1}, rules)
+}
+
+func TestReplacementLaunchesCappedByMaxHourlyCost(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, ec2Client, _ := newScalePlanTestRunner(t, &now)
+	amis, err := r.resolveAMIs(context.Background(), r.instanceVarieties())
+	assert.NoError(t, err)
+	working, err := ec2Client.DescribeWorkingInstances(context.Background())
+	assert.NoError(t, err)
+
+	// the working instance costs 0.1 USD/h and a replacement does not fit in the budget
+	r.config.MaxHourlyCost = 0.1
+	launched, err := r.launchReplacementInstances(context.Background(), working, 1, "refreshingInstances", "Launching replacement instances", amis)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, launched)
+	assert.Empty(t, ec2Client.Changes)
+
+	r.config.MaxHourlyCost = 0.2
+	launched, err = r.launchReplacementInstances(context.Background(), working, 1, "refreshingInstances", "Launching replacement instances", amis)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, launched)
+	assert.Equal(t, map[InstanceVariety]int64{working[0].Variety(): 1}, ec2Client.Changes)
+}
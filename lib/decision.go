@@ -0,0 +1,215 @@
+package autoscaler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Rules which block a part of changes
+const (
+	BlockedByScaleOutCooldown  = "scaleOutCooldown"
+	BlockedByScaleInCooldown   = "scaleInCooldown"
+	BlockedByProhibitToScaleIn = "prohibitToScaleIn"
+	BlockedBySchedule          = "scheduleNoTerminate"
+	BlockedByRemovalBudget     = "removalBudget"
+	BlockedByScaleInPaused     = "scaleInPaused"
+	BlockedByMaxHourlyCost     = "maxHourlyCost"
+	BlockedByPaused            = "paused"
+)
+
+// Decision explains how scaling in a loop decides changes of spot instances
+type Decision struct {
+	Time time.Time
+
+	// inputs
+	CPUUtil            float64
+	OndemandCapacity   float64
+	SpotCapacity       float64
+	AvailableVarieties int
+	FailureModel       FailureModel
+	Schedule           *Schedule
+	Override           *ScalingOverride
+
+	// thresholds derived from inputs
+	SpotCapacityInWorstCase float64
+	CPUUtilToScaleOut       float64
+	CPUUtilToScaleIn        float64
+
+	// allocations by the scaling policy (CPU based), by the schedule and by the override via API,
+	// and the chosen one after it is capped by CappedBy (e.g. MaxCapacity and MaxHourlyCost)
+	PolicyCapacity   []VarietyCapacity
+	ScheduleCapacity []VarietyCapacity
+	OverrideCapacity []VarietyCapacity
+	DesiredCapacity  []VarietyCapacity
+	CappedBy         []string
+
+	Changes []RecordedChange
+	Blocked []BlockedChange
+
+	// Reason describes why there is no change
+	Reason string
+	// Result describes how changes were applied (e.g. "applied" or "aborted: AMI is not found").
+	// It is empty for a plan which is not applied yet.
+	Result string
+}
+
+type VarietyCapacity struct {
+	Variety  InstanceVariety
+	Capacity float64
+}
+
+// BlockedChange is a part of changes removed by Rule
+type BlockedChange struct {
+	Variety InstanceVariety
+	Count   int64
+	Rule    string
+}
+
+func varietyCapacities(c InstanceCapacity) []VarietyCapacity {
+	if c == nil {
+		return nil
+	}
+	varieties := c.Varieties()
+	sort.Slice(varieties, func(i, j int) bool { return lessInstanceVariety(varieties[i], varieties[j]) })
+
+	vcs := []VarietyCapacity{}
+	for _, v := range varieties {
+		vcs = append(vcs, VarietyCapacity{Variety: v, Capacity: c[v]})
+	}
+	return vcs
+}
+
+func sortedVarieties(vs map[InstanceVariety]bool) []InstanceVariety {
+	varieties := []InstanceVariety{}
+	for v := range vs {
+		varieties = append(varieties, v)
+	}
+	sort.Slice(varieties, func(i, j int) bool { return lessInstanceVariety(varieties[i], varieties[j]) })
+	return varieties
+}
+
+func (d *Decision) Block(v InstanceVariety, count int64, rule string) {
+	d.Blocked = append(d.Blocked, BlockedChange{Variety: v, Count: count, Rule: rule})
+}
+
+func (d *Decision) SetChangeCount(change map[InstanceVariety]int64) {
+	d.Changes = []RecordedChange{}
+	for v, c := range change {
+		d.Changes = append(d.Changes, RecordedChange{Variety: v, Count: c})
+	}
+	sort.Slice(d.Changes, func(i, j int) bool {
+		return lessInstanceVariety(d.Changes[i].Variety, d.Changes[j].Variety)
+	})
+	if len(d.Changes) == 0 && d.Reason == "" {
+		d.Reason = "no change"
+		if len(d.Blocked) > 0 {
+			d.Reason = "all changes are blocked"
+		}
+	}
+}
+
+func (d *Decision) ChangeCount() map[InstanceVariety]int64 {
+	change := map[InstanceVariety]int64{}
+	for _, c := range d.Changes {
+		change[c.Variety] = c.Count
+	}
+	return change
+}
+
+// PrintDecision writes the decision as tables
+func PrintDecision(w io.Writer, d *Decision) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Time\t%s\n", d.Time.Format(time.RFC3339))
+	fmt.Fprintf(tw, "CPU util\t%.1f (scale out at %.1f, scale in at %.1f)\n", d.CPUUtil, d.CPUUtilToScaleOut, d.CPUUtilToScaleIn)
+	fmt.Fprintf(tw, "On-demand capacity\t%.1f\n", d.OndemandCapacity)
+	fmt.Fprintf(tw, "Spot capacity\t%.1f (%.1f when %s fail)\n", d.SpotCapacity, d.SpotCapacityInWorstCase, d.FailureModel)
+	fmt.Fprintf(tw, "Available varieties\t%d\n", d.AvailableVarieties)
+	if d.Schedule != nil {
+		fmt.Fprintf(tw, "Schedule\t%s (capacity %.1f until %s)\n", d.Schedule.Key, d.Schedule.Capacity, d.Schedule.EndAt.Format(time.RFC3339))
+	}
+	if d.Override != nil {
+		if d.Override.Paused {
+			fmt.Fprintf(tw, "Override\tscaling is paused\n")
+		}
+		if d.Override.ScaleInPaused {
+			fmt.Fprintf(tw, "Override\tscaling in is paused\n")
+		}
+		if c := d.Override.ActiveCapacity(d.Time); c != nil {
+			fmt.Fprintf(tw, "Override\tcapacity %.1f until %s\n", *c, d.Override.CapacityExpiresAt.Format(time.RFC3339))
+		}
+	}
+	if len(d.CappedBy) > 0 {
+		fmt.Fprintf(tw, "Capped by\t%s\n", strings.Join(d.CappedBy, ", "))
+	}
+	if len(d.Changes) == 0 {
+		fmt.Fprintf(tw, "Result\tno change (%s)\n", d.Reason)
+	} else if d.Result != "" {
+		fmt.Fprintf(tw, "Result\t%s\n", d.Result)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	varieties := map[InstanceVariety]bool{}
+	policy := map[InstanceVariety]float64{}
+	for _, c := range d.PolicyCapacity {
+		varieties[c.Variety] = true
+		policy[c.Variety] = c.Capacity
+	}
+	schedule := map[InstanceVariety]float64{}
+	for _, c := range d.ScheduleCapacity {
+		varieties[c.Variety] = true
+		schedule[c.Variety] = c.Capacity
+	}
+	override := map[InstanceVariety]float64{}
+	for _, c := range d.OverrideCapacity {
+		varieties[c.Variety] = true
+		override[c.Variety] = c.Capacity
+	}
+	desired := map[InstanceVariety]float64{}
+	for _, c := range d.DesiredCapacity {
+		varieties[c.Variety] = true
+		desired[c.Variety] = c.Capacity
+	}
+	change := d.ChangeCount()
+	for v := range change {
+		varieties[v] = true
+	}
+	blocked := map[InstanceVariety][]string{}
+	for _, b := range d.Blocked {
+		varieties[b.Variety] = true
+		blocked[b.Variety] = append(blocked[b.Variety], fmt.Sprintf("%+d by %s", b.Count, b.Rule))
+	}
+	if len(varieties) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INSTANCE TYPE\tSUBNET\tPOLICY\tSCHEDULE\tOVERRIDE\tDESIRED\tCHANGE\tBLOCKED")
+	optional := func(m map[InstanceVariety]float64, v InstanceVariety, given bool) string {
+		if !given {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", m[v])
+	}
+	for _, v := range sortedVarieties(varieties) {
+		blockedBy := strings.Join(blocked[v], ", ")
+		if blockedBy == "" {
+			blockedBy = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%+d\t%s\n",
+			v.InstanceType, v.Subnet.SubnetID,
+			optional(policy, v, d.PolicyCapacity != nil),
+			optional(schedule, v, d.ScheduleCapacity != nil),
+			optional(override, v, d.OverrideCapacity != nil),
+			optional(desired, v, d.DesiredCapacity != nil),
+			change[v], blockedBy)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,112 @@
+package autoscaler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionBlockedByCooldown(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, _, status := newScalePlanTestRunner(t, &now)
+	err := status.StoreScalingStatus(&ScalingStatus{ScaleOutCooldownEndsAt: now.Add(time.Minute)})
+	assert.NoError(t, err)
+
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+
+	d := plan.Decision
+	assert.Equal(t, 90.0, d.CPUUtil)
+	assert.NotEmpty(t, d.PolicyCapacity)
+	assert.Nil(t, d.ScheduleCapacity)
+	assert.Empty(t, d.Changes)
+	assert.Equal(t, "all changes are blocked", d.Reason)
+	assert.NotEmpty(t, d.Blocked)
+	for _, b := range d.Blocked {
+		assert.Equal(t, BlockedByScaleOutCooldown, b.Rule)
+		assert.True(t, b.Count > 0)
+	}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, PrintDecision(buf, d))
+	assert.Contains(t, buf.String(), "by scaleOutCooldown")
+
+	j, err := json.Marshal(d)
+	assert.NoError(t, err)
+	loaded := &Decision{}
+	assert.NoError(t, json.Unmarshal(j, loaded))
+	assert.Equal(t, d.Blocked, loaded.Blocked)
+}
+
+func TestDecisionInCooldownOfBothDirections(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, _, status := newScalePlanTestRunner(t, &now)
+	err := status.StoreScalingStatus(&ScalingStatus{
+		ScaleOutCooldownEndsAt: now.Add(time.Minute),
+		ScaleInCooldownEndsAt:  now.Add(time.Minute),
+	})
+	assert.NoError(t, err)
+
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+
+	// the allocation is recorded with changes blocked by cooldown
+	d := plan.Decision
+	assert.Equal(t, "cooldown", d.Reason)
+	assert.NotEmpty(t, d.DesiredCapacity)
+	assert.Empty(t, d.Changes)
+	assert.NotEmpty(t, d.Blocked)
+	for _, b := range d.Blocked {
+		assert.Equal(t, BlockedByScaleOutCooldown, b.Rule)
+	}
+}
+
+func TestDecisionInPause(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, _, status := newScalePlanTestRunner(t, &now)
+	assert.NoError(t, status.StoreScalingOverride(&ScalingOverride{Paused: true}))
+
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+
+	d := plan.Decision
+	assert.Equal(t, "paused", d.Reason)
+	assert.NotEmpty(t, d.DesiredCapacity)
+	assert.Empty(t, d.Changes)
+	assert.NotEmpty(t, d.Blocked)
+	for _, b := range d.Blocked {
+		assert.Equal(t, BlockedByPaused, b.Rule)
+	}
+}
+
+func TestDecisionIsExposedByAPI(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, _, _ := newScalePlanTestRunner(t, &now)
+
+	err := r.scale(context.Background())
+	assert.NoError(t, err)
+	assert.NotNil(t, r.api.decision)
+	assert.NotEmpty(t, r.api.decision.Changes)
+	assert.Equal(t, "applied", r.api.decision.Result)
+}
+
+func TestDecisionRecordsAbortedScaling(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, _, status := newScalePlanTestRunner(t, &now)
+	assert.NoError(t, status.StorePinnedAMI("", ""))
+
+	err := r.scale(context.Background())
+	assert.NoError(t, err)
+	if assert.NotNil(t, r.api.decision) {
+		assert.NotEmpty(t, r.api.decision.Changes)
+		assert.Equal(t, "aborted: AMI is not found", r.api.decision.Result)
+	}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, PrintDecision(buf, r.api.decision))
+	assert.Contains(t, buf.String(), "aborted: AMI is not found")
+}
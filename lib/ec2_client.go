@@ -1,12 +1,12 @@
 package autoscaler
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
-	"log"
 	"math"
 	"strconv"
 	"strings"
@@ -14,18 +14,22 @@ import (
 )
 
 type EC2ClientIface interface {
-	TerminateInstancesByCount(instances Instances, v InstanceVariety, count int64) error
-	TerminateInstances(instances Instances) error
-	LaunchSpotInstances(v InstanceVariety, c int64, ami string) error
-	ChangeInstances(change map[InstanceVariety]int64, ami string, terminationTarget Instances) error
-	DescribeWorkingInstances() (Instances, error)
-
-	DescribePendingAndActiveSIRs() ([]*ec2.SpotInstanceRequest, error)
-	PropagateTagsFromSIRsToInstances(reqs []*ec2.SpotInstanceRequest) error
-	CreateStatusTagsOfSIRs(reqs []*ec2.SpotInstanceRequest, status string) error
-	DescribeSpotPrices(vs []InstanceVariety) (map[InstanceVariety]float64, error)
-	DescribeDeadSIRs() ([]*ec2.SpotInstanceRequest, error)
-	CancelOpenSIRs(reqs []*ec2.SpotInstanceRequest) error
+	TerminateInstancesByCount(ctx context.Context, instances Instances, v InstanceVariety, count int64) error
+	TerminateInstances(ctx context.Context, instances Instances) error
+	LaunchSpotInstances(ctx context.Context, v InstanceVariety, c int64, ami string) error
+	ChangeInstances(ctx context.Context, change map[InstanceVariety]int64, amis VarietyAMIs, terminationTarget Instances) error
+	DescribeWorkingInstances(ctx context.Context) (Instances, error)
+
+	DescribePendingAndActiveSIRs(ctx context.Context) ([]*ec2.SpotInstanceRequest, error)
+	PropagateTagsFromSIRsToInstances(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error
+	CreateStatusTagsOfSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest, status string) error
+	DescribeSpotPrices(ctx context.Context, vs []InstanceVariety) (map[InstanceVariety]float64, error)
+	DescribeDeadSIRs(ctx context.Context) ([]*ec2.SpotInstanceRequest, error)
+	CancelOpenSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error
+
+	DescribeImage(ctx context.Context, id string) (*ec2.Image, error)
+	DescribeLatestImage(ctx context.Context, owners []string, filters EC2Filters) (*ec2.Image, error)
+	DescribeSubnets(ctx context.Context, ids []string, filters EC2Filters) ([]Subnet, error)
 }
 
 type EC2Client struct {
@@ -40,7 +44,7 @@ func NewEC2Client(ec2 ec2iface.EC2API, config *Config) *EC2Client {
 	}
 }
 
-func (c *EC2Client) TerminateInstancesByCount(instances Instances, v InstanceVariety, count int64) error {
+func (c *EC2Client) TerminateInstancesByCount(ctx context.Context, instances Instances, v InstanceVariety, count int64) error {
 	target := Instances{}
 	for _, i := range instances {
 		if count <= 0 {
@@ -52,10 +56,10 @@ func (c *EC2Client) TerminateInstancesByCount(instances Instances, v InstanceVar
 		}
 	}
 
-	return c.TerminateInstances(target)
+	return c.TerminateInstances(ctx, target)
 }
 
-func (c *EC2Client) TerminateInstances(instances Instances) error {
+func (c *EC2Client) TerminateInstances(ctx context.Context, instances Instances) error {
 	ids := []*string{}
 	for _, i := range instances {
 		ids = append(ids, i.InstanceId)
@@ -66,9 +70,12 @@ func (c *EC2Client) TerminateInstances(instances Instances) error {
 		Resources: ids,
 		Tags:      c.config.TerminateTags.SDK(),
 	}
-	log.Printf("[DEBUG] terminating: %s", params)
+	logf(ctx, "[DEBUG] terminating: %s", params)
+	logWithFields(ctx, "INFO", "instances are tagged to be terminated", LogFields{
+		"instance_ids": aws.StringValueSlice(ids),
+	})
 
-	_, err := c.ec2.CreateTags(params)
+	_, err := c.createTags(ctx, params)
 	if err != nil {
 		return err
 	}
@@ -76,60 +83,71 @@ func (c *EC2Client) TerminateInstances(instances Instances) error {
 	return nil
 }
 
-func (c *EC2Client) LaunchSpotInstances(v InstanceVariety, count int64, ami string) error {
-	securityGroupIds := []*string{}
-	for _, i := range c.config.LaunchConfiguration.SecurityGroupIDs {
-		securityGroupIds = append(securityGroupIds, aws.String(i))
-	}
-
+func (c *EC2Client) LaunchSpotInstances(ctx context.Context, v InstanceVariety, count int64, ami string) error {
 	biddingPrice, ok := c.config.BiddingPriceByType[v.InstanceType]
 	if !ok {
 		return fmt.Errorf("Bidding price for %s is unknown", v.InstanceType)
 	}
 
-	userData := base64.StdEncoding.EncodeToString([]byte(c.config.LaunchConfiguration.UserData))
+	data, err := NewLaunchTemplateData(c.config, v, ami, time.Now())
+	if err != nil {
+		return err
+	}
 
-	requestSpotInstancesParams := &ec2.RequestSpotInstancesInput{
-		DryRun:        aws.Bool(c.config.DryRun),
-		SpotPrice:     aws.String(fmt.Sprintf("%f", biddingPrice)),
-		InstanceCount: aws.Int64(count),
-		LaunchSpecification: &ec2.RequestSpotLaunchSpecification{
-			ImageId:          aws.String(ami),
-			InstanceType:     aws.String(v.InstanceType),
-			KeyName:          aws.String(c.config.LaunchConfiguration.KeyName),
-			SecurityGroupIds: securityGroupIds,
-			SubnetId:         aws.String(v.Subnet.SubnetID),
-			UserData:         aws.String(userData),
-			IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
-				Name: aws.String(c.config.LaunchConfiguration.IAMInstanceProfileName),
-			},
-			BlockDeviceMappings: c.config.LaunchConfiguration.SDKBlockDeviceMappings(),
-		},
+	launchConfiguration := c.config.LaunchConfigurationFor(v)
+
+	userData, err := launchConfiguration.RenderUserData(data)
+	if err != nil {
+		return err
 	}
-	log.Printf("[INFO] requesting spot instances: %s", requestSpotInstancesParams)
+	userData = base64.StdEncoding.EncodeToString([]byte(userData))
 
-	resp, err := c.ec2.RequestSpotInstances(requestSpotInstancesParams)
+	instanceTags, err := c.config.RenderInstanceTags(data)
 	if err != nil {
 		return err
 	}
 
-	ids := []*string{}
-	for _, req := range resp.SpotInstanceRequests {
-		ids = append(ids, req.SpotInstanceRequestId)
+	requestSpotInstancesParams := &ec2.RequestSpotInstancesInput{
+		DryRun:              aws.Bool(c.config.DryRun),
+		SpotPrice:           aws.String(fmt.Sprintf("%f", biddingPrice)),
+		InstanceCount:       aws.Int64(count),
+		LaunchSpecification: launchConfiguration.SDKLaunchSpecification(v, ami, userData),
+	}
+	logf(ctx, "[INFO] requesting spot instances: %s", requestSpotInstancesParams)
+
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	capacity, err := v.Capacity()
+	// Once requested, spot instance requests must be tagged because untagged ones are not managed
+	// by spotscaler. So the launch step is not canceled by ctx, and the requests are canceled if
+	// tagging fails.
+	stepCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	resp, err := c.requestSpotInstances(stepCtx, requestSpotInstancesParams)
 	if err != nil {
 		return err
 	}
 
+	ids := []*string{}
+	for _, req := range resp.SpotInstanceRequests {
+		ids = append(ids, req.SpotInstanceRequestId)
+	}
+	logWithFields(ctx, "INFO", "spot instances are requested", LogFields{
+		"instance_type": v.InstanceType,
+		"subnet_id":     v.Subnet.SubnetID,
+		"count":         count,
+		"sir_ids":       aws.StringValueSlice(ids),
+	})
+
 	tags := []*ec2.Tag{
 		{Key: aws.String("RequestedBy"), Value: aws.String(c.config.FullAutoscalerID())},
 		{Key: aws.String("spotscaler:Status"), Value: aws.String("pending")},
-		{Key: aws.String(fmt.Sprintf("propagate:%s", c.config.CapacityTagKey)), Value: aws.String(fmt.Sprint(capacity))},
+		{Key: aws.String(fmt.Sprintf("propagate:%s", c.config.CapacityTagKey)), Value: aws.String(fmt.Sprint(data.Capacity))},
 		{Key: aws.String("propagate:ManagedBy"), Value: aws.String(c.config.FullAutoscalerID())},
 	}
-	for _, t := range c.config.InstanceTags {
+	for _, t := range instanceTags {
 		tags = append(tags, &ec2.Tag{Key: aws.String(fmt.Sprintf("propagate:%s", t.Key)), Value: aws.String(t.Value)})
 	}
 
@@ -141,15 +159,23 @@ func (c *EC2Client) LaunchSpotInstances(v InstanceVariety, count int64, ami stri
 
 	retry := 4
 	for i := 0; i < retry; i++ {
-		_, err = c.ec2.CreateTags(createTagsParams)
+		_, err = c.createTags(stepCtx, createTagsParams)
 		if err == nil {
 			break
 		}
 		if i < retry-1 {
 			sleepSec := int(math.Pow(2, float64(i)))
-			log.Printf("[INFO] CreateTags failed, will retry after %d sec: %s", sleepSec, err)
+			logf(ctx, "[INFO] CreateTags failed, will retry after %d sec: %s", sleepSec, err)
 			<-time.After(time.Duration(sleepSec) * time.Second)
 		} else {
+			logf(ctx, "[WARN] canceling spot instance requests which cannot be tagged: %v", aws.StringValueSlice(ids))
+			_, cerr := c.cancelSpotInstanceRequests(stepCtx, &ec2.CancelSpotInstanceRequestsInput{
+				DryRun:                 aws.Bool(c.config.DryRun),
+				SpotInstanceRequestIds: ids,
+			})
+			if cerr != nil {
+				logf(ctx, "[ERROR] canceling spot instance requests failed: %s", cerr)
+			}
 			return err
 		}
 	}
@@ -157,16 +183,25 @@ func (c *EC2Client) LaunchSpotInstances(v InstanceVariety, count int64, ami stri
 	return nil
 }
 
-func (c *EC2Client) ChangeInstances(change map[InstanceVariety]int64, ami string, terminationTarget Instances) error {
+func (c *EC2Client) ChangeInstances(ctx context.Context, change map[InstanceVariety]int64, amis VarietyAMIs, terminationTarget Instances) error {
 	var err error
 	for v, count := range change {
+		// stop between varieties so that a launch in progress is not interrupted
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		if count > 0 {
-			err = c.LaunchSpotInstances(v, count, ami)
+			ami, ok := amis[v]
+			if !ok || ami == "" {
+				return fmt.Errorf("AMI for %v is unknown", v)
+			}
+			err = c.LaunchSpotInstances(ctx, v, count, ami)
 			if err != nil {
 				return err
 			}
 		} else if count < 0 {
-			err = c.TerminateInstancesByCount(terminationTarget, v, count*-1)
+			err = c.TerminateInstancesByCount(ctx, terminationTarget, v, count*-1)
 			if err != nil {
 				return err
 			}
@@ -176,7 +211,7 @@ func (c *EC2Client) ChangeInstances(change map[InstanceVariety]int64, ami string
 	return nil
 }
 
-func (c *EC2Client) DescribeWorkingInstances() (Instances, error) {
+func (c *EC2Client) DescribeWorkingInstances(ctx context.Context) (Instances, error) {
 	filters := append(
 		c.config.WorkingInstanceFilters.SDK(),
 		&ec2.Filter{Name: aws.String("instance-state-name"), Values: []*string{aws.String("running")}},
@@ -185,7 +220,8 @@ func (c *EC2Client) DescribeWorkingInstances() (Instances, error) {
 		Filters: filters,
 	}
 	instances := []*ec2.Instance{}
-	err := c.ec2.DescribeInstancesPages(
+	err := c.describeInstancesPages(
+		ctx,
 		params,
 		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
 			for _, res := range page.Reservations {
@@ -203,7 +239,7 @@ func (c *EC2Client) DescribeWorkingInstances() (Instances, error) {
 	return ret, nil
 }
 
-func (c *EC2Client) DescribePendingAndActiveSIRs() ([]*ec2.SpotInstanceRequest, error) {
+func (c *EC2Client) DescribePendingAndActiveSIRs(ctx context.Context) ([]*ec2.SpotInstanceRequest, error) {
 	params := &ec2.DescribeSpotInstanceRequestsInput{
 		Filters: []*ec2.Filter{
 			{
@@ -219,7 +255,7 @@ func (c *EC2Client) DescribePendingAndActiveSIRs() ([]*ec2.SpotInstanceRequest,
 		},
 	}
 
-	resp, err := c.ec2.DescribeSpotInstanceRequests(params)
+	resp, err := c.describeSpotInstanceRequests(ctx, params)
 	if err != nil {
 		return nil, err
 	}
@@ -227,7 +263,7 @@ func (c *EC2Client) DescribePendingAndActiveSIRs() ([]*ec2.SpotInstanceRequest,
 	return resp.SpotInstanceRequests, nil
 }
 
-func (c *EC2Client) PropagateTagsFromSIRsToInstances(reqs []*ec2.SpotInstanceRequest) error {
+func (c *EC2Client) PropagateTagsFromSIRsToInstances(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error {
 	for _, req := range reqs {
 		tags := []*ec2.Tag{}
 		for _, t := range req.Tags {
@@ -243,8 +279,19 @@ func (c *EC2Client) PropagateTagsFromSIRsToInstances(reqs []*ec2.SpotInstanceReq
 			Tags:      tags,
 		}
 
-		log.Printf("[DEBUG] CreateTags: %s", createTagsParams)
-		_, err := c.ec2.CreateTags(createTagsParams)
+		logf(ctx, "[DEBUG] CreateTags: %s", createTagsParams)
+		_, err := c.createTags(ctx, createTagsParams)
+		if err != nil {
+			return err
+		}
+		logWithFields(ctx, "DEBUG", "tags are propagated from spot instance request", LogFields{
+			"sir_id":      aws.StringValue(req.SpotInstanceRequestId),
+			"instance_id": aws.StringValue(req.InstanceId),
+		})
+	}
+
+	if len(c.config.LaunchConfiguration.VolumeTags) > 0 {
+		err := c.createVolumeTags(ctx, reqs)
 		if err != nil {
 			return err
 		}
@@ -253,7 +300,48 @@ func (c *EC2Client) PropagateTagsFromSIRsToInstances(reqs []*ec2.SpotInstanceReq
 	return nil
 }
 
-func (c *EC2Client) CreateStatusTagsOfSIRs(reqs []*ec2.SpotInstanceRequest, status string) error {
+func (c *EC2Client) createVolumeTags(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error {
+	instanceIDs := []*string{}
+	for _, req := range reqs {
+		instanceIDs = append(instanceIDs, req.InstanceId)
+	}
+
+	volumeIDs := []*string{}
+	err := c.describeInstancesPages(
+		ctx,
+		&ec2.DescribeInstancesInput{InstanceIds: instanceIDs},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, res := range page.Reservations {
+				for _, i := range res.Instances {
+					for _, m := range i.BlockDeviceMappings {
+						if m.Ebs != nil && m.Ebs.VolumeId != nil {
+							volumeIDs = append(volumeIDs, m.Ebs.VolumeId)
+						}
+					}
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return err
+	}
+
+	if len(volumeIDs) == 0 {
+		return nil
+	}
+
+	createTagsParams := &ec2.CreateTagsInput{
+		DryRun:    aws.Bool(c.config.DryRun),
+		Resources: volumeIDs,
+		Tags:      c.config.LaunchConfiguration.VolumeTags.SDK(),
+	}
+
+	logf(ctx, "[DEBUG] CreateTags: %s", createTagsParams)
+	_, err = c.createTags(ctx, createTagsParams)
+	return err
+}
+
+func (c *EC2Client) CreateStatusTagsOfSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest, status string) error {
 	ids := []*string{}
 
 	for _, req := range reqs {
@@ -268,8 +356,8 @@ func (c *EC2Client) CreateStatusTagsOfSIRs(reqs []*ec2.SpotInstanceRequest, stat
 		},
 	}
 
-	log.Printf("[DEBUG] CreateTags: %s", createTagsParams)
-	_, err := c.ec2.CreateTags(createTagsParams)
+	logf(ctx, "[DEBUG] CreateTags: %s", createTagsParams)
+	_, err := c.createTags(ctx, createTagsParams)
 	if err != nil {
 		return err
 	}
@@ -277,7 +365,7 @@ func (c *EC2Client) CreateStatusTagsOfSIRs(reqs []*ec2.SpotInstanceRequest, stat
 	return nil
 }
 
-func (c *EC2Client) DescribeSpotPrices(vs []InstanceVariety) (map[InstanceVariety]float64, error) {
+func (c *EC2Client) DescribeSpotPrices(ctx context.Context, vs []InstanceVariety) (map[InstanceVariety]float64, error) {
 	res := map[InstanceVariety]float64{}
 
 	varietiesByAZ := map[string][]InstanceVariety{}
@@ -300,8 +388,8 @@ func (c *EC2Client) DescribeSpotPrices(vs []InstanceVariety) (map[InstanceVariet
 		found := map[InstanceVariety]bool{}
 		var errInside error
 		pageIndex := 1
-		err := c.ec2.DescribeSpotPriceHistoryPages(input, func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
-			log.Printf("[TRACE] DescribeSpotPriceHistory page %d", pageIndex)
+		err := c.describeSpotPriceHistoryPages(ctx, input, func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
+			logf(ctx, "[TRACE] DescribeSpotPriceHistory page %d", pageIndex)
 			for _, v := range vs {
 				if f := found[v]; f {
 					// already found
@@ -346,7 +434,7 @@ func (c *EC2Client) DescribeSpotPrices(vs []InstanceVariety) (map[InstanceVariet
 	return res, nil
 }
 
-func (c *EC2Client) DescribeDeadSIRs() ([]*ec2.SpotInstanceRequest, error) {
+func (c *EC2Client) DescribeDeadSIRs(ctx context.Context) ([]*ec2.SpotInstanceRequest, error) {
 	params := &ec2.DescribeSpotInstanceRequestsInput{
 		Filters: []*ec2.Filter{
 			{
@@ -359,7 +447,7 @@ func (c *EC2Client) DescribeDeadSIRs() ([]*ec2.SpotInstanceRequest, error) {
 		},
 	}
 
-	resp, err := c.ec2.DescribeSpotInstanceRequests(params)
+	resp, err := c.describeSpotInstanceRequests(ctx, params)
 	if err != nil {
 		return nil, err
 	}
@@ -374,7 +462,7 @@ func (c *EC2Client) DescribeDeadSIRs() ([]*ec2.SpotInstanceRequest, error) {
 	return deadSIRs, nil
 }
 
-func (c *EC2Client) CancelOpenSIRs(reqs []*ec2.SpotInstanceRequest) error {
+func (c *EC2Client) CancelOpenSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error {
 	ids := []*string{}
 
 	for _, req := range reqs {
@@ -391,11 +479,82 @@ func (c *EC2Client) CancelOpenSIRs(reqs []*ec2.SpotInstanceRequest) error {
 		DryRun:                 aws.Bool(c.config.DryRun),
 		SpotInstanceRequestIds: ids,
 	}
-	log.Printf("[DEBUG] CancelSpotInstanceRequests: %s", cancelParams)
-	_, err := c.ec2.CancelSpotInstanceRequests(cancelParams)
+	logf(ctx, "[DEBUG] CancelSpotInstanceRequests: %s", cancelParams)
+	logWithFields(ctx, "INFO", "spot instance requests are canceled", LogFields{
+		"sir_ids": aws.StringValueSlice(ids),
+	})
+	_, err := c.cancelSpotInstanceRequests(ctx, cancelParams)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func (c *EC2Client) DescribeImage(ctx context.Context, id string) (*ec2.Image, error) {
+	params := &ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(id)},
+	}
+
+	resp, err := c.describeImages(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Images) == 0 {
+		return nil, nil
+	}
+
+	return resp.Images[0], nil
+}
+
+// DescribeLatestImage returns the newest available image matching filters.
+// Pending or failed images are skipped even if they are newer.
+func (c *EC2Client) DescribeLatestImage(ctx context.Context, owners []string, filters EC2Filters) (*ec2.Image, error) {
+	params := &ec2.DescribeImagesInput{
+		Owners:  aws.StringSlice(owners),
+		Filters: append(filters.SDK(), &ec2.Filter{Name: aws.String("state"), Values: aws.StringSlice([]string{"available"})}),
+	}
+
+	resp, err := c.describeImages(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *ec2.Image
+	for _, i := range resp.Images {
+		if aws.StringValue(i.State) != "available" {
+			continue
+		}
+		// CreationDate is in ISO 8601 format and can be compared as string
+		if latest == nil || aws.StringValue(latest.CreationDate) < aws.StringValue(i.CreationDate) {
+			latest = i
+		}
+	}
+
+	return latest, nil
+}
+
+func (c *EC2Client) DescribeSubnets(ctx context.Context, ids []string, filters EC2Filters) ([]Subnet, error) {
+	params := &ec2.DescribeSubnetsInput{
+		Filters: filters.SDK(),
+	}
+	if len(ids) > 0 {
+		params.SubnetIds = aws.StringSlice(ids)
+	}
+
+	resp, err := c.describeSubnets(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	subnets := []Subnet{}
+	for _, s := range resp.Subnets {
+		subnets = append(subnets, Subnet{
+			SubnetID:         *s.SubnetId,
+			AvailabilityZone: *s.AvailabilityZone,
+		})
+	}
+
+	return subnets, nil
+}
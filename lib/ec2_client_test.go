@@ -0,0 +1,55 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newEC2ClientForTest returns EC2Client calling EC2 API served by handler
+func newEC2ClientForTest(t *testing.T, handler http.HandlerFunc) (*EC2Client, func()) {
+	server := httptest.NewServer(handler)
+	sess, err := session.NewSession(aws.NewConfig().
+		WithEndpoint(server.URL).
+		WithRegion("ap-northeast-1").
+		WithCredentials(credentials.NewStaticCredentials("AKID", "SECRET", "")).
+		WithMaxRetries(0))
+	assert.NoError(t, err)
+	return NewEC2Client(ec2.New(sess), configForTest("0")), server.Close
+}
+
+func TestDescribeLatestImageSkipsNotAvailable(t *testing.T) {
+	forms := []map[string][]string{}
+	c, closeServer := newEC2ClientForTest(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		fmt.Fprint(w, `<DescribeImagesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>req-1</requestId>
+  <imagesSet>
+    <item><imageId>ami-pending</imageId><imageState>pending</imageState><creationDate>2017-01-02T00:00:00.000Z</creationDate></item>
+    <item><imageId>ami-available</imageId><imageState>available</imageState><creationDate>2017-01-01T00:00:00.000Z</creationDate></item>
+  </imagesSet>
+</DescribeImagesResponse>`)
+	})
+	defer closeServer()
+
+	image, err := c.DescribeLatestImage(context.Background(), []string{"self"}, EC2Filters{{Name: "name", Values: []string{"app-*"}}})
+	assert.NoError(t, err)
+	if assert.NotNil(t, image) {
+		assert.Equal(t, "ami-available", *image.ImageId)
+	}
+
+	if assert.Len(t, forms, 1) {
+		assert.Equal(t, []string{"name"}, forms[0]["Filter.1.Name"])
+		assert.Equal(t, []string{"state"}, forms[0]["Filter.2.Name"])
+		assert.Equal(t, []string{"available"}, forms[0]["Filter.2.Value.1"])
+	}
+}
@@ -0,0 +1,69 @@
+package autoscaler
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The SDK has no context support, so EC2 API is called through the functions below.
+// A request including its retries and following pages is canceled by ctx, and
+// request IDs of them are logged.
+
+func withContext(ctx context.Context, req *request.Request) *request.Request {
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest = r.HTTPRequest.WithContext(ctx)
+	})
+	req.Handlers.UnmarshalMeta.PushBack(func(r *request.Request) {
+		logWithFields(ctx, "DEBUG", "EC2 API is called", LogFields{
+			"operation":  r.Operation.Name,
+			"request_id": r.RequestID,
+		})
+	})
+	return req
+}
+
+func (c *EC2Client) createTags(ctx context.Context, input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	req, out := c.ec2.CreateTagsRequest(input)
+	return out, withContext(ctx, req).Send()
+}
+
+func (c *EC2Client) requestSpotInstances(ctx context.Context, input *ec2.RequestSpotInstancesInput) (*ec2.RequestSpotInstancesOutput, error) {
+	req, out := c.ec2.RequestSpotInstancesRequest(input)
+	return out, withContext(ctx, req).Send()
+}
+
+func (c *EC2Client) cancelSpotInstanceRequests(ctx context.Context, input *ec2.CancelSpotInstanceRequestsInput) (*ec2.CancelSpotInstanceRequestsOutput, error) {
+	req, out := c.ec2.CancelSpotInstanceRequestsRequest(input)
+	return out, withContext(ctx, req).Send()
+}
+
+func (c *EC2Client) describeSpotInstanceRequests(ctx context.Context, input *ec2.DescribeSpotInstanceRequestsInput) (*ec2.DescribeSpotInstanceRequestsOutput, error) {
+	req, out := c.ec2.DescribeSpotInstanceRequestsRequest(input)
+	return out, withContext(ctx, req).Send()
+}
+
+func (c *EC2Client) describeImages(ctx context.Context, input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	req, out := c.ec2.DescribeImagesRequest(input)
+	return out, withContext(ctx, req).Send()
+}
+
+func (c *EC2Client) describeSubnets(ctx context.Context, input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	req, out := c.ec2.DescribeSubnetsRequest(input)
+	return out, withContext(ctx, req).Send()
+}
+
+func (c *EC2Client) describeInstancesPages(ctx context.Context, input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	req, _ := c.ec2.DescribeInstancesRequest(input)
+	return withContext(ctx, req).EachPage(func(p interface{}, lastPage bool) bool {
+		return fn(p.(*ec2.DescribeInstancesOutput), lastPage)
+	})
+}
+
+func (c *EC2Client) describeSpotPriceHistoryPages(ctx context.Context, input *ec2.DescribeSpotPriceHistoryInput, fn func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool) error {
+	req, _ := c.ec2.DescribeSpotPriceHistoryRequest(input)
+	return withContext(ctx, req).EachPage(func(p interface{}, lastPage bool) bool {
+		return fn(p.(*ec2.DescribeSpotPriceHistoryOutput), lastPage)
+	})
+}
@@ -0,0 +1,79 @@
+package autoscaler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	FailureDomainVariety          = "variety"
+	FailureDomainAvailabilityZone = "availabilityZone"
+	FailureDomainInstanceFamily   = "instanceFamily"
+)
+
+// FailureModel assumes that all instances in the MaxFailedDomains largest failure domains
+// can be terminated at the same time. A failure domain is a variety (instance type and subnet),
+// an availability zone or an instance family.
+type FailureModel struct {
+	Domain           string
+	MaxFailedDomains int
+}
+
+// FailureModel returns the failure model in which MaxTerminatedVarieties domains of FailureDomain fail
+func (c *Config) FailureModel() FailureModel {
+	domain := c.FailureDomain
+	if domain == "" {
+		domain = FailureDomainVariety
+	}
+	return FailureModel{Domain: domain, MaxFailedDomains: c.MaxTerminatedVarieties}
+}
+
+func (m FailureModel) String() string {
+	return fmt.Sprintf("%d %s(s)", m.MaxFailedDomains, m.Domain)
+}
+
+// DomainOf returns the failure domain v belongs to
+func (m FailureModel) DomainOf(v InstanceVariety) string {
+	switch m.Domain {
+	case FailureDomainAvailabilityZone:
+		return v.Subnet.AvailabilityZone
+	case FailureDomainInstanceFamily:
+		return strings.SplitN(v.InstanceType, ".", 2)[0]
+	}
+	return fmt.Sprintf("%s/%s", v.InstanceType, v.Subnet.SubnetID)
+}
+
+// Domains returns the number of varieties in each failure domain
+func (m FailureModel) Domains(varieties []InstanceVariety) map[string]int {
+	domains := map[string]int{}
+	for _, v := range varieties {
+		domains[m.DomainOf(v)]++
+	}
+	return domains
+}
+
+// TotalOnFailure returns the total capacity after the largest failure domains are terminated
+func (c InstanceCapacity) TotalOnFailure(m FailureModel) float64 {
+	byDomain := map[string]float64{}
+	for v, cap := range c {
+		byDomain[m.DomainOf(v)] += cap
+	}
+
+	values := []float64{}
+	for _, cap := range byDomain {
+		values = append(values, cap)
+	}
+	sort.Float64s(values)
+
+	a := len(values) - m.MaxFailedDomains
+	if a < 0 {
+		a = 0
+	}
+	total := 0.0
+	for _, v := range values[:a] {
+		total += v
+	}
+
+	return total
+}
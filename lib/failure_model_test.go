@@ -0,0 +1,64 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var failureModelTestCapacities = CapacityTable{"c4.large": 10, "c4.xlarge": 20, "m4.large": 10}
+
+func failureModelTestVarieties() []InstanceVariety {
+	a := Subnet{SubnetID: "subnet-a", AvailabilityZone: "ap-northeast-1a"}
+	b := Subnet{SubnetID: "subnet-b", AvailabilityZone: "ap-northeast-1b"}
+	return []InstanceVariety{
+		{InstanceType: "c4.large", Subnet: a},
+		{InstanceType: "c4.xlarge", Subnet: a},
+		{InstanceType: "m4.large", Subnet: a},
+		{InstanceType: "m4.large", Subnet: b},
+	}
+}
+
+func TestTotalOnFailure(t *testing.T) {
+	vs := failureModelTestVarieties()
+	c := InstanceCapacity{vs[0]: 10, vs[1]: 20, vs[2]: 30, vs[3]: 40}
+
+	assert.Equal(t, 30.0, c.TotalOnFailure(FailureModel{Domain: FailureDomainVariety, MaxFailedDomains: 2}))
+	assert.Equal(t, c.TotalInWorstCase(2), c.TotalOnFailure(FailureModel{Domain: FailureDomainVariety, MaxFailedDomains: 2}))
+	// ap-northeast-1a has 60 and ap-northeast-1b has 40
+	assert.Equal(t, 40.0, c.TotalOnFailure(FailureModel{Domain: FailureDomainAvailabilityZone, MaxFailedDomains: 1}))
+	// c4 has 30 and m4 has 70
+	assert.Equal(t, 30.0, c.TotalOnFailure(FailureModel{Domain: FailureDomainInstanceFamily, MaxFailedDomains: 1}))
+	assert.Equal(t, 0.0, c.TotalOnFailure(FailureModel{Domain: FailureDomainAvailabilityZone, MaxFailedDomains: 2}))
+}
+
+func TestDesiredCapacityFromTotalByAvailabilityZone(t *testing.T) {
+	vs := failureModelTestVarieties()
+	m := FailureModel{Domain: FailureDomainAvailabilityZone, MaxFailedDomains: 1}
+
+	actual, err := DesiredCapacityFromTotal(vs, failureModelTestCapacities, 100, m)
+	assert.NoError(t, err)
+	assert.True(t, actual.TotalOnFailure(m) >= 100)
+
+	// capacity is spread over availability zones rather than varieties
+	byAZ := map[string]float64{}
+	for v, c := range actual {
+		byAZ[v.Subnet.AvailabilityZone] += c
+	}
+	assert.Equal(t, map[string]float64{"ap-northeast-1a": 120, "ap-northeast-1b": 100}, byAZ)
+
+	_, err = DesiredCapacityFromTotal(vs[:3], failureModelTestCapacities, 100, m)
+	assert.IsType(t, &UnsatisfiableCapacityError{}, err)
+}
+
+func TestDesiredCapacityFromTargetCPUUtilByInstanceFamily(t *testing.T) {
+	vs := failureModelTestVarieties()
+	m := FailureModel{Domain: FailureDomainInstanceFamily, MaxFailedDomains: 1}
+
+	actual, err := DesiredCapacityFromTargetCPUUtil(context.Background(), vs, failureModelTestCapacities, 80, 80, 10, 0, 100, m)
+	assert.NoError(t, err)
+
+	// CPU util stays below the target even when an instance family is terminated
+	assert.True(t, 80*100/actual.TotalOnFailure(m) < 70)
+}
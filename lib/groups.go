@@ -0,0 +1,145 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Groups runs autoscaler groups defined in one config file. They share an AWS session,
+// a Redis connection and an API server, and each group has its own loop, cooldown and status.
+type Groups struct {
+	runners []*Runner
+	api     *GroupsAPIServer
+	apiAddr string
+}
+
+// ValidateGroupConfigs checks that configs of groups can run in one process
+func ValidateGroupConfigs(configs []*Config) error {
+	problems := []string{}
+	ids := map[string]bool{}
+	for _, c := range configs {
+		if ids[c.AutoscalerID] {
+			problems = append(problems, fmt.Sprintf("AutoscalerID %s is used by more than one group", c.AutoscalerID))
+		}
+		ids[c.AutoscalerID] = true
+
+		first := configs[0]
+		if c.RedisHost != first.RedisHost || c.RedisPassword != first.RedisPassword {
+			problems = append(problems, fmt.Sprintf("RedisHost and RedisPassword of %s must be the same as %s", c.AutoscalerID, first.AutoscalerID))
+		}
+		if c.APIAddr != first.APIAddr {
+			problems = append(problems, fmt.Sprintf("APIAddr of %s must be the same as %s", c.AutoscalerID, first.AutoscalerID))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid groups:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func NewGroups(configs []*Config) (*Groups, error) {
+	err := ValidateGroupConfigs(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	awsSess, err := session.NewSession(aws.NewConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	redisClient := NewRedisClient(configs[0].RedisHost, configs[0].RedisPassword)
+
+	g := &Groups{apiAddr: configs[0].APIAddr}
+	apis := map[string]*APIServer{}
+	for _, c := range configs {
+		r, err := newRunnerWithSession(c, awsSess, NewStatusStoreWithClient(redisClient, c.FullAutoscalerID()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", c.AutoscalerID, err)
+		}
+		r.recoverPanic = true
+		g.runners = append(g.runners, r)
+		apis[c.AutoscalerID] = r.api
+	}
+	g.api = NewGroupsAPIServer(apis)
+
+	return g, nil
+}
+
+// EnableConfigReload makes loops of groups reload config by load on SIGHUP.
+// Each group takes its config by AutoscalerID, and groups added to config require restart.
+func (g *Groups) EnableConfigReload(load func() ([]*Config, error)) {
+	for _, r := range g.runners {
+		id := r.config.AutoscalerID
+		r.EnableConfigReload(func() (*Config, error) {
+			configs, err := load()
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range configs {
+				if c.AutoscalerID == id {
+					return c, nil
+				}
+			}
+			return nil, fmt.Errorf("group %s is not found in config", id)
+		})
+	}
+}
+
+// StartLoops runs loops of all groups until ctx is canceled
+func (g *Groups) StartLoops(ctx context.Context) error {
+	if g.apiAddr != "" {
+		g.api.Run(g.apiAddr)
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := g.api.Shutdown(shutdownCtx)
+			if err != nil {
+				logf(ctx, "[ERROR] error in shutting down API server: %s", err)
+			}
+		}()
+	}
+
+	errs := make(chan error, len(g.runners))
+	for _, r := range g.runners {
+		go func(r *Runner) {
+			err := r.loop(ctx)
+			if err != nil {
+				err = fmt.Errorf("%s: %s", r.config.AutoscalerID, err)
+			}
+			errs <- err
+		}(r)
+	}
+
+	var firstErr error
+	for range g.runners {
+		err := <-errs
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// RunOnce runs a loop of each group once. A failure of a group does not stop the others.
+func (g *Groups) RunOnce(ctx context.Context) error {
+	failed := []string{}
+	for _, r := range g.runners {
+		err := r.RunOnce(ctx)
+		if err != nil {
+			logf(ctx, "[ERROR] error in loop of %s: %s", r.config.AutoscalerID, err)
+			failed = append(failed, r.config.AutoscalerID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("loops of %s failed", strings.Join(failed, ", "))
+	}
+	return nil
+}
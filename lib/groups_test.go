@@ -0,0 +1,148 @@
+package autoscaler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadYAMLConfigsWithGroups(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+RedisHost: localhost:6379
+MaxCPUUtil: 50
+InstanceCapacityByType:
+  c4.large: 2
+Groups:
+  - AutoscalerID: web
+  - AutoscalerID: api
+    MaxCPUUtil: 70
+    InstanceCapacityByType:
+      m4.large: 2
+`)
+	assert.NoError(t, err)
+	f.Close()
+
+	configs, err := LoadYAMLConfigs(f.Name())
+	assert.NoError(t, err)
+	if assert.Len(t, configs, 2) {
+		assert.Equal(t, "web", configs[0].AutoscalerID)
+		assert.Equal(t, "localhost:6379", configs[0].RedisHost)
+		assert.Equal(t, 50.0, configs[0].MaxCPUUtil)
+		assert.Equal(t, map[string]float64{"c4.large": 2}, configs[0].InstanceCapacityByType)
+
+		assert.Equal(t, "api", configs[1].AutoscalerID)
+		assert.Equal(t, "localhost:6379", configs[1].RedisHost)
+		assert.Equal(t, 70.0, configs[1].MaxCPUUtil)
+		assert.Equal(t, map[string]float64{"c4.large": 2, "m4.large": 2}, configs[1].InstanceCapacityByType)
+	}
+
+	_, err = LoadYAMLConfig(f.Name())
+	assert.Error(t, err)
+
+	config, err := selectGroupConfig(configs, "api")
+	assert.NoError(t, err)
+	assert.Equal(t, "api", config.AutoscalerID)
+	_, err = selectGroupConfig(configs, "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "one of web, api")
+	}
+}
+
+func TestValidateGroupConfigs(t *testing.T) {
+	configs := []*Config{
+		{AutoscalerID: "web", RedisHost: "localhost:6379", APIAddr: ":8080"},
+		{AutoscalerID: "api", RedisHost: "localhost:6379", APIAddr: ":8080"},
+	}
+	assert.NoError(t, ValidateGroupConfigs(configs))
+
+	configs = append(configs, &Config{AutoscalerID: "web", RedisHost: "redis:6379", APIAddr: ":8081"})
+	err := ValidateGroupConfigs(configs)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "AutoscalerID web is used by more than one group")
+		assert.Contains(t, err.Error(), "RedisHost and RedisPassword of web must be the same as web")
+		assert.Contains(t, err.Error(), "APIAddr of web must be the same as web")
+	}
+}
+
+func TestGroupsAPIServerRoutes(t *testing.T) {
+	web := NewAPIServer(new(MockStatusStoreIface))
+	web.UpdateMetrics(map[string]float64{"cpu_util": 40})
+	api := NewAPIServer(new(MockStatusStoreIface))
+	api.UpdateMetrics(map[string]float64{"cpu_util": 60})
+	handler := NewGroupsAPIServer(map[string]*APIServer{"web": web, "api": api}).handler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/groups")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `["api", "web"]`, w.Body.String())
+
+	w = get("/groups/web/metrics")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "spotscaler_cpu_util{} 40.000000")
+
+	w = get("/groups/api/metrics")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "spotscaler_cpu_util{} 60.000000")
+
+	assert.Equal(t, 404, get("/groups/db/metrics").Code)
+}
+
+func TestWithPanicRecovery(t *testing.T) {
+	r := &Runner{config: &Config{AutoscalerID: "web"}, recoverPanic: true}
+
+	err := r.withPanicRecovery(func() error {
+		panic("boom")
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "panic in web: boom")
+	}
+}
+
+func TestGroupsPlanWithOwnCapacityTables(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	web, _, _ := newScalePlanTestRunner(t, &now)
+	api, _, _ := newScalePlanTestRunner(t, &now)
+	api.config.InstanceCapacityByType = map[string]float64{"c4.large": 20, "m4.large": 20}
+	for _, r := range []*Runner{web, api} {
+		r.DisableSideEffects()
+	}
+
+	expected := map[*Runner][]VarietyCapacity{}
+	for _, r := range []*Runner{web, api} {
+		plan, err := r.Plan(context.Background())
+		assert.NoError(t, err)
+		expected[r] = plan.Decision.DesiredCapacity
+	}
+	assert.NotEqual(t, expected[web], expected[api])
+
+	// loops of groups run concurrently without affecting each other
+	wg := &sync.WaitGroup{}
+	for _, r := range []*Runner{web, api} {
+		wg.Add(1)
+		go func(r *Runner) {
+			defer wg.Done()
+			for n := 0; n < 10; n++ {
+				plan, err := r.Plan(context.Background())
+				assert.NoError(t, err)
+				assert.Equal(t, expected[r], plan.Decision.DesiredCapacity)
+			}
+		}(r)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,26 @@
+package autoscaler
+
+import (
+	"regexp"
+	"strings"
+)
+
+// graviton instance families like a1, m6g, c6gn and x2gd
+var arm64FamilyPattern = regexp.MustCompile(`^(a1|[a-z]+[0-9]+g[a-z]*)$`)
+
+// ArchitectureTable is architecture of each instance type configured by InstanceArchitectureByType
+type ArchitectureTable map[string]string
+
+// Architecture returns architecture configured for an instance type,
+// or guesses it from the instance family
+func (a ArchitectureTable) Architecture(t string) string {
+	if a, ok := a[t]; ok {
+		return a
+	}
+
+	family := strings.SplitN(t, ".", 2)[0]
+	if arm64FamilyPattern.MatchString(family) {
+		return "arm64"
+	}
+	return "x86_64"
+}
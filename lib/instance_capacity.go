@@ -1,14 +1,13 @@
 package autoscaler
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math"
 	"sort"
 )
 
-var capacityTable map[string]float64
-
 type InstanceCapacity map[InstanceVariety]float64
 
 func (c InstanceCapacity) Total() float64 {
@@ -35,9 +34,9 @@ func (c InstanceCapacity) Varieties() []InstanceVariety {
 	return vs
 }
 
-func (c InstanceCapacity) Increment() (InstanceCapacity, error) {
+func (c InstanceCapacity) Increment(capacities CapacityTable) (InstanceCapacity, error) {
 	varieties := c.Varieties()
-	sort.Sort(SortInstanceVarietiesByCapacity(varieties))
+	sort.Sort(SortInstanceVarietiesByCapacity{Varieties: varieties, Capacities: capacities})
 
 	var leastVariety InstanceVariety
 	leastCapacity := math.Inf(1)
@@ -49,7 +48,7 @@ func (c InstanceCapacity) Increment() (InstanceCapacity, error) {
 	}
 
 	log.Printf("[TRACE] InstanceCapacity.Increment: adding %v", leastVariety)
-	cap, err := leastVariety.Capacity()
+	cap, err := leastVariety.Capacity(capacities)
 	if err != nil {
 		return nil, err
 	}
@@ -58,29 +57,19 @@ func (c InstanceCapacity) Increment() (InstanceCapacity, error) {
 	return c, nil
 }
 
+// TotalInWorstCase returns the total capacity after maxTerminatedVarieties varieties are terminated
 func (c InstanceCapacity) TotalInWorstCase(maxTerminatedVarieties int) float64 {
-	values := c.Values()
-	sort.Float64s(values)
-	total := 0.0
-	a := len(values) - maxTerminatedVarieties
-	if a < 0 {
-		a = 0
-	}
-	for _, v := range values[:a] {
-		total += v
-	}
-
-	return total
+	return c.TotalOnFailure(FailureModel{Domain: FailureDomainVariety, MaxFailedDomains: maxTerminatedVarieties})
 }
 
-func (cFrom InstanceCapacity) CountDiff(cTo InstanceCapacity) (map[InstanceVariety]int64, error) {
+func (cFrom InstanceCapacity) CountDiff(cTo InstanceCapacity, capacities CapacityTable) (map[InstanceVariety]int64, error) {
 	change := map[InstanceVariety]int64{}
 
 	for v, to := range cTo {
 		from := cFrom[v]
 		diff := to - from
 		if diff > 0 {
-			cap, err := v.Capacity()
+			cap, err := v.Capacity(capacities)
 			if err != nil {
 				return nil, err
 			}
@@ -95,7 +84,7 @@ func (cFrom InstanceCapacity) CountDiff(cTo InstanceCapacity) (map[InstanceVarie
 		to := cTo[v]
 		diff := from - to
 		if diff > 0 {
-			cap, err := v.Capacity()
+			cap, err := v.Capacity(capacities)
 			if err != nil {
 				return nil, err
 			}
@@ -111,61 +100,169 @@ func (cFrom InstanceCapacity) CountDiff(cTo InstanceCapacity) (map[InstanceVarie
 	return change, nil
 }
 
-func DesiredCapacityFromTargetCPUUtil(varieties []InstanceVariety, cpuUtil float64, maxCPUUtil float64, targetCPUUtilDiff float64, ondemandCapacityTotal float64, spotCapacityTotal float64, maxTerminatedVarieties int) (InstanceCapacity, error) {
-	var err error
-	desiredCapacity := InstanceCapacity{}
-	for _, v := range varieties {
-		desiredCapacity[v] = 0.0
+// DesiredCapacityFromTargetCPUUtil returns the least allocation, in the order instances are
+// added by the capacity solver, under which CPU util is below the scale-out threshold
+// by targetCPUUtilDiff even when failure domains fail as failureModel assumes.
+func DesiredCapacityFromTargetCPUUtil(ctx context.Context, varieties []InstanceVariety, capacities CapacityTable, cpuUtil float64, maxCPUUtil float64, targetCPUUtilDiff float64, ondemandCapacityTotal float64, spotCapacityTotal float64, failureModel FailureModel) (InstanceCapacity, error) {
+	if maxCPUUtil-targetCPUUtilDiff <= 0 {
+		return nil, &UnsatisfiableCapacityError{
+			Reason: fmt.Sprintf("target CPU util must be positive: %f", maxCPUUtil-targetCPUUtilDiff),
+		}
 	}
 
-L:
-	for {
-		u := cpuUtil * (ondemandCapacityTotal + spotCapacityTotal) / (ondemandCapacityTotal + desiredCapacity.Total())
+	solver, err := newCapacitySolver(varieties, capacities, failureModel)
+	if err != nil {
+		return nil, err
+	}
+	domains := len(failureModel.Domains(varieties))
+
+	satisfied := func(c InstanceCapacity) bool {
+		u := cpuUtil * (ondemandCapacityTotal + spotCapacityTotal) / (ondemandCapacityTotal + c.Total())
 		uScaleOut := maxCPUUtil *
-			(ondemandCapacityTotal + desiredCapacity.TotalInWorstCase(maxTerminatedVarieties)) /
-			(ondemandCapacityTotal + desiredCapacity.Total())
-		log.Printf("[TRACE] DesiredCapacityFromTargetCPUUtil u: %f, uScaleOut: %f", u, uScaleOut)
-		if u < uScaleOut-targetCPUUtilDiff {
-			break L
+			(ondemandCapacityTotal + c.TotalOnFailure(failureModel)) /
+			(ondemandCapacityTotal + c.Total())
+		logf(ctx, "[TRACE] DesiredCapacityFromTargetCPUUtil u: %f, uScaleOut: %f", u, uScaleOut)
+		return u < uScaleOut-targetCPUUtilDiff
+	}
+
+	// Since capacity in the worst case never exceeds the total, CPU util can be below
+	// the threshold only after the total exceeds the following one.
+	// The total increases monotonically, so the first candidate is found by bisection.
+	required := cpuUtil * (ondemandCapacityTotal + spotCapacityTotal) / (maxCPUUtil - targetCPUUtilDiff)
+	if len(varieties) == 0 {
+		c := InstanceCapacity{}
+		if satisfied(c) {
+			return c, nil
 		}
+		return nil, &UnsatisfiableCapacityError{Reason: "no instance variety is available"}
+	}
+	k := firstSatisfying(func(k int) bool {
+		return ondemandCapacityTotal+solver.capacity(k).Total() > required
+	})
+	if k < 0 {
+		return nil, &UnsatisfiableCapacityError{
+			Reason: fmt.Sprintf("more than %d instances are required", MaxCapacitySolverIterations),
+		}
+	}
 
-		desiredCapacity, err = desiredCapacity.Increment()
-		if err != nil {
-			return nil, err
+	// The condition is not monotone in general, so candidates are checked one by one.
+	counts := solver.counts(k)
+	for ; k <= MaxCapacitySolverIterations; k++ {
+		c := solver.capacityFromCounts(counts)
+		if satisfied(c) {
+			return c, nil
 		}
+		if domains <= failureModel.MaxFailedDomains {
+			// capacity in the worst case stays zero and CPU util only gets away from the target
+			break
+		}
+		solver.next(counts)
 	}
 
-	return desiredCapacity, nil
+	return nil, &UnsatisfiableCapacityError{
+		Reason: fmt.Sprintf("CPU util does not reach the target with up to %d instances", MaxCapacitySolverIterations),
+	}
 }
 
-func DesiredCapacityFromTotal(varieties []InstanceVariety, total float64, maxTerminatedVarieties int) (InstanceCapacity, error) {
-	var err error
-	desiredCapacity := InstanceCapacity{}
-	for _, v := range varieties {
-		desiredCapacity[v] = 0.0
+// DesiredCapacityFromTotal returns the least allocation, in the order instances are added by
+// the capacity solver, whose capacity on failure assumed by failureModel is total or more
+func DesiredCapacityFromTotal(varieties []InstanceVariety, capacities CapacityTable, total float64, failureModel FailureModel) (InstanceCapacity, error) {
+	solver, err := newCapacitySolver(varieties, capacities, failureModel)
+	if err != nil {
+		return nil, err
 	}
 
-L:
-	for {
-		if total <= desiredCapacity.TotalInWorstCase(maxTerminatedVarieties) {
-			break L
+	if total <= 0 {
+		return solver.capacity(0), nil
+	}
+
+	if domains := len(failureModel.Domains(varieties)); domains <= failureModel.MaxFailedDomains {
+		return nil, &UnsatisfiableCapacityError{
+			Reason: fmt.Sprintf("%d failure domains are not enough when %s can fail", domains, failureModel),
 		}
+	}
 
-		desiredCapacity, err = desiredCapacity.Increment()
-		if err != nil {
-			return nil, err
+	// capacity on failure increases monotonically as instances are added
+	k := firstSatisfying(func(k int) bool {
+		return total <= solver.capacity(k).TotalOnFailure(failureModel)
+	})
+	if k < 0 {
+		return nil, &UnsatisfiableCapacityError{
+			Reason: fmt.Sprintf("more than %d instances are required", MaxCapacitySolverIterations),
 		}
 	}
 
-	return desiredCapacity, nil
+	return solver.capacity(k), nil
 }
 
-func SetCapacityTable(c map[string]float64) {
-	capacityTable = c
+// ClampCapacity returns desired if its total is above minCapacity and at most maxCapacity
+// (zero means unlimited). Otherwise it returns the allocation, in the order instances are added
+// by the capacity solver, whose total is the largest at most maxCapacity or the least
+// above minCapacity, so that capped capacity is still spread over failure domains.
+func ClampCapacity(varieties []InstanceVariety, capacities CapacityTable, desired InstanceCapacity, minCapacity float64, maxCapacity float64, failureModel FailureModel) (InstanceCapacity, error) {
+	over := maxCapacity > 0 && desired.Total() > maxCapacity
+	under := desired.Total() <= minCapacity
+	if !over && !under {
+		return desired, nil
+	}
+
+	solver, err := newCapacitySolver(varieties, capacities, failureModel)
+	if err != nil {
+		return nil, err
+	}
+	if len(varieties) == 0 {
+		return nil, &UnsatisfiableCapacityError{Reason: "no instance variety is available"}
+	}
+
+	if over {
+		k := firstSatisfying(func(k int) bool {
+			return solver.capacity(k).Total() > maxCapacity
+		})
+		if k < 0 {
+			k = MaxCapacitySolverIterations + 1
+		}
+		return solver.capacity(k - 1), nil
+	}
+
+	k := firstSatisfying(func(k int) bool {
+		return solver.capacity(k).Total() > minCapacity
+	})
+	if k < 0 {
+		return nil, &UnsatisfiableCapacityError{
+			Reason: fmt.Sprintf("more than %d instances are required for MinCapacity", MaxCapacitySolverIterations),
+		}
+	}
+	return solver.capacity(k), nil
 }
 
-func CapacityFromInstanceType(t string) (float64, error) {
-	cap, ok := capacityTable[t]
+// BalancedCapacity returns the least allocation, in the order instances are added by the
+// capacity solver, whose total is total or more
+func BalancedCapacity(varieties []InstanceVariety, capacities CapacityTable, total float64, failureModel FailureModel) (InstanceCapacity, error) {
+	solver, err := newCapacitySolver(varieties, capacities, failureModel)
+	if err != nil {
+		return nil, err
+	}
+	if len(varieties) == 0 {
+		return nil, &UnsatisfiableCapacityError{Reason: "no instance variety is available"}
+	}
+
+	k := firstSatisfying(func(k int) bool {
+		return total <= solver.capacity(k).Total()
+	})
+	if k < 0 {
+		return nil, &UnsatisfiableCapacityError{
+			Reason: fmt.Sprintf("more than %d instances are required", MaxCapacitySolverIterations),
+		}
+	}
+	return solver.capacity(k), nil
+}
+
+// CapacityTable is capacity of each instance type configured by InstanceCapacityByType
+type CapacityTable map[string]float64
+
+// Capacity returns capacity of an instance type
+func (c CapacityTable) Capacity(t string) (float64, error) {
+	cap, ok := c[t]
 	if !ok {
 		return 0.0, fmt.Errorf("Capacity of %s is unknown", t)
 	}
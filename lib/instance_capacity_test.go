@@ -1,20 +1,21 @@
 package autoscaler
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
-func TestCapacityFromInstanceType(t *testing.T) {
-	_, err := CapacityFromInstanceType("unknown")
+func TestCapacityTable(t *testing.T) {
+	_, err := CapacityTable{"c4.large": 10}.Capacity("unknown")
 	assert.Error(t, err)
 }
 
 func TestDiffCount(t *testing.T) {
-	SetCapacityTable(map[string]float64{
+	capacities := CapacityTable{
 		"t1": 10.0,
 		"t2": 10.0,
-	})
+	}
 
 	v1 := InstanceVariety{
 		InstanceType: "t1",
@@ -45,7 +46,7 @@ func TestDiffCount(t *testing.T) {
 		v2: -1,
 	}
 
-	count, err := from.CountDiff(to)
+	count, err := from.CountDiff(to, capacities)
 	assert.NoError(t, err)
 	assert.Equal(t, expected, count)
 
@@ -61,17 +62,17 @@ func TestDiffCount(t *testing.T) {
 		v2: 1,
 	}
 
-	count, err = from.CountDiff(to)
+	count, err = from.CountDiff(to, capacities)
 	assert.NoError(t, err)
 	assert.Equal(t, expected, count)
 }
 
 func TestDesiredCapacityFromTotal(t *testing.T) {
-	SetCapacityTable(map[string]float64{
+	capacities := CapacityTable{
 		"c4.large": 10.0,
 		"m4.large": 20.0,
 		"r3.large": 30.0,
-	})
+	}
 
 	subnet := Subnet{
 		SubnetID:         "subnet-abc",
@@ -91,7 +92,7 @@ func TestDesiredCapacityFromTotal(t *testing.T) {
 			Subnet:       subnet,
 		},
 	}
-	actual, err := DesiredCapacityFromTotal(varieties, 100, 2)
+	actual, err := DesiredCapacityFromTotal(varieties, capacities, 100, varietyFailureModel(2))
 	assert.NoError(t, err)
 	assert.Equal(t, InstanceCapacity{
 		varieties[0]: 100,
@@ -101,11 +102,11 @@ func TestDesiredCapacityFromTotal(t *testing.T) {
 }
 
 func TestDesiredCapacityFromTargetCPUUtil(t *testing.T) {
-	SetCapacityTable(map[string]float64{
+	capacities := CapacityTable{
 		"c4.large": 10.0,
 		"m4.large": 20.0,
 		"r3.large": 30.0,
-	})
+	}
 
 	subnet := Subnet{
 		SubnetID:         "subnet-abc",
@@ -126,13 +127,15 @@ func TestDesiredCapacityFromTargetCPUUtil(t *testing.T) {
 		},
 	}
 	actual, err := DesiredCapacityFromTargetCPUUtil(
+		context.Background(),
 		varieties,
+		capacities,
 		80,
 		80,
 		10,
 		100.0,
 		100.0,
-		1,
+		varietyFailureModel(1),
 	)
 	assert.NoError(t, err)
 	assert.Equal(t, InstanceCapacity{
@@ -141,3 +144,26 @@ func TestDesiredCapacityFromTargetCPUUtil(t *testing.T) {
 		varieties[2]: 90,
 	}, actual)
 }
+
+func TestClampCapacity(t *testing.T) {
+	capacities := CapacityTable{"t1": 10, "t2": 10, "t3": 10}
+	varieties := []InstanceVariety{
+		{InstanceType: "t1", Subnet: Subnet{SubnetID: "subnet-a"}},
+		{InstanceType: "t2", Subnet: Subnet{SubnetID: "subnet-a"}},
+		{InstanceType: "t3", Subnet: Subnet{SubnetID: "subnet-a"}},
+	}
+	desired := InstanceCapacity{varieties[0]: 50, varieties[1]: 40, varieties[2]: 40}
+
+	actual, err := ClampCapacity(varieties, capacities, desired, 0, 0, varietyFailureModel(0))
+	assert.NoError(t, err)
+	assert.Equal(t, desired, actual)
+
+	// capacity over MaxCapacity is spread over varieties
+	actual, err = ClampCapacity(varieties, capacities, desired, 0, 75, varietyFailureModel(0))
+	assert.NoError(t, err)
+	assert.Equal(t, InstanceCapacity{varieties[0]: 30, varieties[1]: 20, varieties[2]: 20}, actual)
+
+	actual, err = ClampCapacity(varieties, capacities, InstanceCapacity{varieties[0]: 10}, 25, 0, varietyFailureModel(0))
+	assert.NoError(t, err)
+	assert.Equal(t, InstanceCapacity{varieties[0]: 10, varieties[1]: 10, varieties[2]: 10}, actual)
+}
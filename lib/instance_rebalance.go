@@ -0,0 +1,288 @@
+package autoscaler
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+type RebalanceConfig struct {
+	// Threshold is the ratio of spot capacity in the worst case, lost against the balanced
+	// allocation of the same total, above which capacity is rebalanced
+	Threshold float64 `yaml:"Threshold" validate:"required,gt=0,lt=1"`
+	// BatchSize is the max num of instances moved at a time
+	BatchSize int `yaml:"BatchSize" validate:"required,min=1"`
+}
+
+// RebalanceStatus represents instances launched in under-weighted varieties by the last rebalance.
+// Instances in over-weighted varieties are terminated after they become working, and they are abandoned at Deadline.
+type RebalanceStatus struct {
+	Launched         int
+	LaunchedCapacity float64
+	LaunchedAt       time.Time
+	Deadline         time.Time
+}
+
+// rebalanceInstances moves spot capacity from over-weighted varieties to under-weighted ones
+// when spot capacity in the worst case is much less than the one of the balanced allocation.
+// Instances are launched in under-weighted varieties first and instances in over-weighted
+// varieties are terminated after the launched ones become working.
+func (r *Runner) rebalanceInstances(ctx context.Context) error {
+	if r.config.Rebalance == nil {
+		return nil
+	}
+	logf(ctx, "[DEBUG] START: rebalanceInstances")
+
+	cooldownEndsAt, err := r.status.FetchCooldownEndsAt()
+	if err != nil {
+		return err
+	}
+
+	if r.now().Before(cooldownEndsAt) {
+		logf(ctx, "[INFO] skip rebalance in cooldown (it ends at %s)", cooldownEndsAt)
+		return nil
+	}
+
+	workingInstances, err := r.ec2Client.DescribeWorkingInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	capacities := r.config.CapacityTable()
+	spotCapacity, err := workingInstances.Spot().Capacity(capacities)
+	if err != nil {
+		return err
+	}
+
+	price, err := r.ec2Client.DescribeSpotPrices(ctx, r.instanceVarieties())
+	if err != nil {
+		return err
+	}
+
+	availableVarieties, err := r.availableVarieties(ctx, price)
+	if err != nil {
+		return err
+	}
+
+	failureModel := r.config.FailureModel()
+	balanced, err := BalancedCapacity(availableVarieties, capacities, spotCapacity.Total(), failureModel)
+	if err != nil {
+		return err
+	}
+
+	current := spotCapacity.TotalOnFailure(failureModel)
+	ideal := balanced.TotalOnFailure(failureModel)
+	imbalance := 0.0
+	if ideal > 0 {
+		imbalance = math.Max(0, 1-current/ideal)
+	}
+	r.api.UpdateMetrics(map[string]float64{
+		"spot_capacity_imbalance": imbalance,
+	})
+
+	status, err := r.status.FetchRebalanceStatus()
+	if err != nil {
+		return err
+	}
+
+	amis, err := r.resolveAMIs(ctx, r.instanceVarieties())
+	if err != nil {
+		return err
+	}
+
+	managed := workingInstances.ManagedBy(r.config.FullAutoscalerID()).Spot()
+
+	if status.Launched > 0 {
+		// allow clock skew between this host and EC2
+		launched := managed.LaunchedAfter(status.LaunchedAt.Add(-time.Minute))
+		if len(launched) < status.Launched {
+			if !r.now().Before(status.Deadline) {
+				logf(ctx, "[WARN] instances launched by rebalance did not become working by %s, abandoning the rebalance", status.Deadline)
+				return r.status.StoreRebalanceStatus(&RebalanceStatus{})
+			}
+			logf(ctx, "[INFO] waiting for instances launched by rebalance to become working (%d/%d)", len(launched), status.Launched)
+			return nil
+		}
+
+		// capacity is moved to balance the total before the launch
+		balanced, err := BalancedCapacity(availableVarieties, capacities, spotCapacity.Total()-status.LaunchedCapacity, failureModel)
+		if err != nil {
+			return err
+		}
+
+		overWeighted, err := overWeightedInstances(managed, spotCapacity, balanced, capacities)
+		if err != nil {
+			return err
+		}
+
+		terminating, err := r.selectReplaceableInstances(ctx, workingInstances, overWeighted, status.Launched)
+		if err != nil {
+			return err
+		}
+
+		if len(terminating) > 0 {
+			err = r.terminateReplacedInstances(ctx, terminating, "rebalancingInstances", "Terminating instances in over-weighted varieties", amis)
+			if err != nil {
+				return err
+			}
+		} else {
+			logf(ctx, "[INFO] no instance in over-weighted varieties can be terminated")
+		}
+
+		return r.status.StoreRebalanceStatus(&RebalanceStatus{})
+	}
+
+	if imbalance <= r.config.Rebalance.Threshold {
+		logf(ctx, "[DEBUG] spot capacity is balanced enough (imbalance: %f)", imbalance)
+		return nil
+	}
+	logf(ctx, "[INFO] spot capacity in the worst case is %f while it is %f when balanced", current, ideal)
+
+	// instances in over-weighted varieties would not be terminated
+	paused, err := r.scaleInPaused()
+	if err != nil {
+		return err
+	}
+	if paused {
+		logf(ctx, "[INFO] scaling in is paused via API, no instance is launched for rebalance")
+		return nil
+	}
+
+	scalingStatus, err := r.status.FetchScalingStatus()
+	if err != nil {
+		return err
+	}
+	if budget := r.config.RemovalBudget(scalingStatus, r.now()); budget.Exhausted() {
+		logf(ctx, "[INFO] removal budget is used up, no instance is launched for rebalance")
+		return nil
+	}
+
+	// varieties whose AMI is not determined yet are not launched
+	launchable := InstanceCapacity{}
+	for v, c := range balanced {
+		if amis[v] != "" {
+			launchable[v] = c
+		}
+	}
+
+	change, err := underWeightedChange(spotCapacity, launchable, r.config.Rebalance.BatchSize, capacities)
+	if err != nil {
+		return err
+	}
+	if len(change) == 0 {
+		logf(ctx, "[WARN] no under-weighted variety can be launched. Abort rebalance")
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(r.config.ReplacementTimeoutDuration())
+	if err != nil {
+		return err
+	}
+
+	launchedAt := r.now()
+	change, err = r.launchInstances(ctx, change, "rebalancingInstances", "Launching instances in under-weighted varieties", amis)
+	if err != nil {
+		return err
+	}
+	if len(change) == 0 {
+		return nil
+	}
+
+	status = &RebalanceStatus{LaunchedAt: launchedAt, Deadline: launchedAt.Add(timeout)}
+	for v, c := range change {
+		cap, err := v.Capacity(capacities)
+		if err != nil {
+			return err
+		}
+		status.Launched += int(c)
+		status.LaunchedCapacity += float64(c) * cap
+	}
+
+	return r.status.StoreRebalanceStatus(status)
+}
+
+// underWeightedChange returns up to limit instances to launch in varieties whose current
+// capacity is below the balanced one, taken from the variety with the largest shortage
+func underWeightedChange(current InstanceCapacity, balanced InstanceCapacity, limit int, capacities CapacityTable) (map[InstanceVariety]int64, error) {
+	varieties := balanced.Varieties()
+	sort.Sort(SortInstanceVarietiesByCapacity{Varieties: varieties, Capacities: capacities})
+
+	shortage := map[InstanceVariety]float64{}
+	for _, v := range varieties {
+		shortage[v] = balanced[v] - current[v]
+	}
+
+	change := map[InstanceVariety]int64{}
+	for n := 0; n < limit; n++ {
+		var most *InstanceVariety
+		for i, v := range varieties {
+			if shortage[v] > 0 && (most == nil || shortage[v] > shortage[*most]) {
+				most = &varieties[i]
+			}
+		}
+		if most == nil {
+			break
+		}
+
+		cap, err := most.Capacity(capacities)
+		if err != nil {
+			return nil, err
+		}
+		change[*most]++
+		shortage[*most] -= cap
+	}
+
+	return change, nil
+}
+
+// overWeightedInstances returns instances in varieties whose current capacity exceeds the
+// balanced one by their capacity or more, oldest first from the variety with the largest excess
+func overWeightedInstances(instances Instances, current InstanceCapacity, balanced InstanceCapacity, capacities CapacityTable) (Instances, error) {
+	sorted := make(Instances, len(instances))
+	copy(sorted, instances)
+	sort.Sort(SortInstancesByLaunchTime(sorted))
+
+	byVariety := map[InstanceVariety]Instances{}
+	varieties := []InstanceVariety{}
+	for _, i := range sorted {
+		v := i.Variety()
+		if _, ok := byVariety[v]; !ok {
+			varieties = append(varieties, v)
+		}
+		byVariety[v] = append(byVariety[v], i)
+	}
+	sort.Sort(SortInstanceVarietiesByCapacity{Varieties: varieties, Capacities: capacities})
+
+	excess := map[InstanceVariety]float64{}
+	for _, v := range varieties {
+		excess[v] = current[v] - balanced[v]
+	}
+
+	selected := Instances{}
+	for {
+		var most *InstanceVariety
+		for i, v := range varieties {
+			cap, err := v.Capacity(capacities)
+			if err != nil {
+				return nil, err
+			}
+			if len(byVariety[v]) > 0 && excess[v] >= cap && (most == nil || excess[v] > excess[*most]) {
+				most = &varieties[i]
+			}
+		}
+		if most == nil {
+			break
+		}
+
+		cap, err := most.Capacity(capacities)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, byVariety[*most][0])
+		byVariety[*most] = byVariety[*most][1:]
+		excess[*most] -= cap
+	}
+
+	return selected, nil
+}
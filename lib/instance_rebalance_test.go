@@ -0,0 +1,130 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebalanceInstancesLaunchesThenTerminates(t *testing.T) {
+	config := simulationConfig(configForTest("10"))
+	config.Rebalance = &RebalanceConfig{Threshold: 0.3, BatchSize: 2}
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	instances := Instances{}
+	for _, id := range []string{"i-1", "i-2", "i-3", "i-4"} {
+		i := spotInstanceForTest(id, "c4.large", "ami-abc")
+		i.LaunchTime = &now
+		instances = append(instances, i)
+	}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(10))
+	assert.NoError(t, err)
+
+	// instances are launched in the under-weighted variety first
+	now = now.Add(time.Hour)
+	err = r.rebalanceInstances(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, ec2Client.Changes, 1)
+	for v, c := range ec2Client.Changes {
+		assert.Equal(t, "m4.large", v.InstanceType)
+		assert.Equal(t, int64(2), c)
+	}
+	st, err := status.FetchRebalanceStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, st.Launched)
+	assert.Equal(t, now.Add(30*time.Minute), st.Deadline)
+
+	// rebalance is skipped in cooldown
+	err = r.rebalanceInstances(context.Background())
+	assert.NoError(t, err)
+	working, _ := ec2Client.DescribeWorkingInstances(context.Background())
+	assert.Len(t, working, 6)
+
+	// instances in the over-weighted variety are terminated after cooldown
+	now = now.Add(10 * time.Minute)
+	err = r.rebalanceInstances(context.Background())
+	assert.NoError(t, err)
+	working, _ = ec2Client.DescribeWorkingInstances(context.Background())
+	capacity, err := working.Capacity(r.config.CapacityTable())
+	assert.NoError(t, err)
+	byType := map[string]float64{}
+	for v, c := range capacity {
+		byType[v.InstanceType] += c
+	}
+	assert.Equal(t, map[string]float64{"c4.large": 20, "m4.large": 20}, byType)
+
+	st, err = status.FetchRebalanceStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, st.Launched)
+
+	// balanced capacity is not moved any more
+	now = now.Add(10 * time.Minute)
+	ec2Client.Changes = nil
+	err = r.rebalanceInstances(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, ec2Client.Changes)
+}
+
+func TestRebalanceInstancesAbandonsLaunchAfterDeadline(t *testing.T) {
+	config := simulationConfig(configForTest("10"))
+	config.Rebalance = &RebalanceConfig{Threshold: 0.3, BatchSize: 2}
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	launchTime := now
+	instances := Instances{}
+	for _, id := range []string{"i-1", "i-2", "i-3", "i-4"} {
+		i := spotInstanceForTest(id, "c4.large", "ami-abc")
+		i.LaunchTime = &launchTime
+		instances = append(instances, i)
+	}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(10))
+	assert.NoError(t, err)
+
+	// instances launched by rebalance have not become working
+	now = now.Add(time.Hour)
+	launched := &RebalanceStatus{Launched: 2, LaunchedCapacity: 20, LaunchedAt: now, Deadline: now.Add(30 * time.Minute)}
+	assert.NoError(t, status.StoreRebalanceStatus(launched))
+
+	now = now.Add(10 * time.Minute)
+	err = r.rebalanceInstances(context.Background())
+	assert.NoError(t, err)
+	st, err := status.FetchRebalanceStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, st.Launched)
+
+	// the launch is abandoned at the deadline without terminating instances
+	now = now.Add(20 * time.Minute)
+	err = r.rebalanceInstances(context.Background())
+	assert.NoError(t, err)
+	st, err = status.FetchRebalanceStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, st.Launched)
+	assert.Empty(t, ec2Client.Changes)
+	working, _ := ec2Client.DescribeWorkingInstances(context.Background())
+	assert.Len(t, working, 4)
+}
+
+func TestOverWeightedInstances(t *testing.T) {
+	capacities := CapacityTable{"c4.large": 10, "m4.large": 10}
+	instances := Instances{
+		spotInstanceForTest("i-1", "c4.large", "ami-abc"),
+		spotInstanceForTest("i-2", "c4.large", "ami-abc"),
+		spotInstanceForTest("i-3", "c4.large", "ami-abc"),
+		spotInstanceForTest("i-4", "m4.large", "ami-abc"),
+	}
+	current, err := instances.Capacity(capacities)
+	assert.NoError(t, err)
+	balanced := InstanceCapacity{instances[0].Variety(): 15, instances[3].Variety(): 25}
+
+	selected, err := overWeightedInstances(instances, current, balanced, capacities)
+	assert.NoError(t, err)
+	assert.Equal(t, Instances{instances[0]}, selected)
+}
@@ -0,0 +1,158 @@
+package autoscaler
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+type InstanceRefreshConfig struct {
+	BatchSize int `yaml:"BatchSize" validate:"required,min=1"`
+}
+
+// RefreshStatus represents progress of instance refresh
+type RefreshStatus struct {
+	AMIs       []string
+	Outdated   int
+	UpToDate   int
+	Launched   int
+	Terminated int
+	UpdatedAt  time.Time
+
+	// BatchLaunched replacements were launched when UpToDate was BatchUpToDate. Outdated instances
+	// are terminated after they become working, and the batch is abandoned at BatchDeadline.
+	BatchLaunched int
+	BatchUpToDate int
+	BatchDeadline time.Time
+}
+
+func (s *RefreshStatus) resetBatch() {
+	s.BatchLaunched = 0
+	s.BatchUpToDate = 0
+	s.BatchDeadline = time.Time{}
+}
+
+// refreshInstances replaces managed spot instances whose AMI differs from the current one.
+// Replacement capacity is launched first and outdated instances are terminated after it becomes
+// working, only when spot capacity in the worst case is kept enough for the current load.
+func (r *Runner) refreshInstances(ctx context.Context) error {
+	if r.config.InstanceRefresh == nil {
+		return nil
+	}
+	logf(ctx, "[DEBUG] START: refreshInstances")
+
+	paused, err := r.status.FetchRefreshPaused()
+	if err != nil {
+		return err
+	}
+
+	if paused {
+		logf(ctx, "[INFO] instance refresh is paused")
+		return nil
+	}
+
+	cooldownEndsAt, err := r.status.FetchCooldownEndsAt()
+	if err != nil {
+		return err
+	}
+
+	if r.now().Before(cooldownEndsAt) {
+		logf(ctx, "[INFO] skip instance refresh in cooldown (it ends at %s)", cooldownEndsAt)
+		return nil
+	}
+
+	amis, err := r.resolveAMIs(ctx, r.instanceVarieties())
+	if err != nil {
+		return err
+	}
+
+	workingInstances, err := r.ec2Client.DescribeWorkingInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	managed := workingInstances.ManagedBy(r.config.FullAutoscalerID()).Spot()
+
+	// instances whose AMI is not determined yet are not replaced
+	outdated := Instances{}
+	for _, i := range managed.OutdatedFor(amis) {
+		if amis[i.Variety()] != "" {
+			outdated = append(outdated, i)
+		}
+	}
+
+	status, err := r.status.FetchRefreshStatus()
+	if err != nil {
+		return err
+	}
+
+	if strings.Join(status.AMIs, ",") != strings.Join(amis.Distinct(), ",") {
+		logf(ctx, "[INFO] starting instance refresh to %v", amis.Distinct())
+		status = &RefreshStatus{AMIs: amis.Distinct()}
+	}
+	status.Outdated = len(outdated)
+	status.UpToDate = len(managed) - len(outdated)
+	status.UpdatedAt = r.now()
+
+	defer func() {
+		err := r.status.StoreRefreshStatus(status)
+		if err != nil {
+			logf(ctx, "[ERROR] storing refresh status failed: %s", err)
+		}
+	}()
+
+	if len(outdated) == 0 {
+		logf(ctx, "[DEBUG] no outdated instance")
+		status.resetBatch()
+		return nil
+	}
+	logf(ctx, "[INFO] %d outdated instances are found", len(outdated))
+
+	sort.Sort(SortInstancesByLaunchTime(outdated))
+
+	if status.BatchLaunched > 0 {
+		if status.UpToDate < status.BatchUpToDate+status.BatchLaunched {
+			if !r.now().Before(status.BatchDeadline) {
+				logf(ctx, "[WARN] replacement instances did not become working by %s, abandoning the batch", status.BatchDeadline)
+				status.resetBatch()
+				return nil
+			}
+			logf(ctx, "[INFO] waiting for replacement instances to become working (%d/%d)", status.UpToDate-status.BatchUpToDate, status.BatchLaunched)
+			return nil
+		}
+
+		terminating, err := r.selectReplaceableInstances(ctx, workingInstances, outdated, status.BatchLaunched)
+		if err != nil {
+			return err
+		}
+		status.resetBatch()
+
+		if len(terminating) == 0 {
+			logf(ctx, "[INFO] no outdated instance can be terminated")
+			return nil
+		}
+		err = r.terminateReplacedInstances(ctx, terminating, "refreshingInstances", "Terminating outdated instances", amis)
+		if err != nil {
+			return err
+		}
+		status.Terminated += len(terminating)
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(r.config.ReplacementTimeoutDuration())
+	if err != nil {
+		return err
+	}
+
+	launched, err := r.launchReplacementInstances(ctx, outdated, r.config.InstanceRefresh.BatchSize, "refreshingInstances", "Launching replacement instances", amis)
+	if err != nil {
+		return err
+	}
+	status.Launched += launched
+	status.BatchLaunched = launched
+	status.BatchUpToDate = status.UpToDate
+	status.BatchDeadline = r.now().Add(timeout)
+
+	return nil
+}
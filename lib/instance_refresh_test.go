@@ -0,0 +1,213 @@
+package autoscaler
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func spotInstanceForTest(id string, instanceType string, ami string) *Instance {
+	return &Instance{
+		Instance: ec2.Instance{
+			InstanceId:            aws.String(id),
+			InstanceType:          aws.String(instanceType),
+			ImageId:               aws.String(ami),
+			SubnetId:              aws.String("subnet-abc"),
+			SpotInstanceRequestId: aws.String("sir-abc"),
+			Placement: &ec2.Placement{
+				AvailabilityZone: aws.String("ap-northeast-1b"),
+			},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("ManagedBy"), Value: aws.String("spotscaler/test")},
+			},
+		},
+	}
+}
+
+func TestRefreshInstancesTerminatesOutdatedAfterReplacement(t *testing.T) {
+	config := configForTest("10")
+	config.InstanceRefresh = &InstanceRefreshConfig{BatchSize: 1}
+
+	outdated := spotInstanceForTest("i-1", "c4.large", "ami-old")
+	instances := Instances{
+		outdated,
+		spotInstanceForTest("i-2", "c4.large", "ami-abc"),
+		spotInstanceForTest("i-3", "m4.large", "ami-abc"),
+	}
+	replacement := spotInstanceForTest("i-4", "c4.large", "ami-abc")
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(instances, nil).Once()
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(append(instances, replacement), nil).Once()
+
+	statusStore := NewMemoryStatusStore(time.Now)
+
+	r := &Runner{
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
+	}
+
+	// capacity is enough to terminate the outdated instance, but a replacement is launched first
+	ec2Client.On("ChangeInstances", mock.Anything, map[InstanceVariety]int64{outdated.Variety(): 1}, amisForTest(config), Instances{}).Return(nil).Once()
+	err := r.refreshInstances(context.Background())
+	assert.NoError(t, err)
+	ec2Client.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything)
+	st, _ := statusStore.FetchRefreshStatus()
+	assert.Equal(t, 1, st.Launched)
+	assert.Equal(t, 1, st.BatchLaunched)
+	assert.Equal(t, 0, st.Terminated)
+
+	// the outdated instance is terminated after the replacement becomes working
+	ec2Client.On("TerminateInstances", mock.Anything, Instances{outdated}).Return(nil).Once()
+	statusStore.StoreCooldownEndsAt(time.Time{})
+	err = r.refreshInstances(context.Background())
+	assert.NoError(t, err)
+	st, _ = statusStore.FetchRefreshStatus()
+	assert.Equal(t, 1, st.Terminated)
+	assert.Equal(t, 0, st.BatchLaunched)
+	ec2Client.AssertExpectations(t)
+
+	// the termination is charged to the hourly removal limits
+	scalingStatus, err := statusStore.FetchScalingStatus()
+	assert.NoError(t, err)
+	capacity, removed := scalingStatus.RemovedSince(time.Now().Add(-1 * time.Hour))
+	assert.Equal(t, 10.0, capacity)
+	assert.Equal(t, int64(1), removed)
+}
+
+func TestRefreshInstancesAbandonsBatchAfterDeadline(t *testing.T) {
+	config := configForTest("10")
+	config.InstanceRefresh = &InstanceRefreshConfig{BatchSize: 1}
+
+	instances := Instances{
+		spotInstanceForTest("i-1", "c4.large", "ami-old"),
+		spotInstanceForTest("i-2", "m4.large", "ami-abc"),
+	}
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(instances, nil)
+
+	statusStore := NewMemoryStatusStore(time.Now)
+	statusStore.StoreRefreshStatus(&RefreshStatus{
+		AMIs:          []string{"ami-abc"},
+		Launched:      1,
+		BatchLaunched: 1,
+		BatchUpToDate: 1,
+		BatchDeadline: time.Now().Add(-time.Minute),
+	})
+
+	r := &Runner{
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
+	}
+	err := r.refreshInstances(context.Background())
+	assert.NoError(t, err)
+	ec2Client.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything)
+	ec2Client.AssertNotCalled(t, "ChangeInstances", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	st, _ := statusStore.FetchRefreshStatus()
+	assert.Equal(t, 0, st.BatchLaunched)
+	assert.Equal(t, 1, st.Launched)
+}
+
+func TestRefreshInstancesLaunchesReplacementFirst(t *testing.T) {
+	config := configForTest("10")
+	config.InstanceRefresh = &InstanceRefreshConfig{BatchSize: 2}
+
+	instances := Instances{
+		spotInstanceForTest("i-1", "c4.large", "ami-old"),
+		spotInstanceForTest("i-2", "m4.large", "ami-old"),
+	}
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(instances, nil)
+	ec2Client.On("ChangeInstances", mock.Anything, map[InstanceVariety]int64{
+		instances[0].Variety(): 1,
+		instances[1].Variety(): 1,
+	}, amisForTest(config), Instances{}).Return(nil)
+
+	statusStore := new(MockStatusStoreIface)
+	statusStore.On("FetchRefreshPaused").Return(false, nil)
+	statusStore.On("FetchCooldownEndsAt").Return(time.Time{}, nil)
+	statusStore.On("FetchRefreshStatus").Return(&RefreshStatus{}, nil)
+	statusStore.On("FetchScalingOverride").Return(&ScalingOverride{}, nil)
+	statusStore.On("StoreCooldownEndsAt", mock.AnythingOfType("time.Time")).Return(nil)
+	statusStore.On("FetchScalingStatus").Return(&ScalingStatus{}, nil)
+	statusStore.On("StoreRefreshStatus", mock.MatchedBy(func(st *RefreshStatus) bool {
+		return st.Launched == 2 && st.BatchLaunched == 2 && st.Terminated == 0
+	})).Return(nil)
+
+	r := &Runner{
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
+	}
+	err := r.refreshInstances(context.Background())
+	assert.NoError(t, err)
+	ec2Client.AssertExpectations(t)
+	statusStore.AssertExpectations(t)
+}
+
+func TestRefreshInstancesWithinRemovalBudget(t *testing.T) {
+	config := configForTest("10")
+	config.InstanceRefresh = &InstanceRefreshConfig{BatchSize: 1}
+	config.MaxTerminatedInstancesPerHour = 2
+
+	outdated := spotInstanceForTest("i-1", "c4.large", "ami-old")
+	instances := Instances{
+		outdated,
+		spotInstanceForTest("i-2", "c4.large", "ami-abc"),
+		spotInstanceForTest("i-3", "m4.large", "ami-abc"),
+	}
+	replacement := spotInstanceForTest("i-4", "c4.large", "ami-abc")
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(instances, nil).Once()
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(append(instances, replacement), nil)
+	ec2Client.On("ChangeInstances", mock.Anything, map[InstanceVariety]int64{outdated.Variety(): 1}, amisForTest(config), Instances{}).Return(nil).Once()
+
+	statusStore := NewMemoryStatusStore(time.Now)
+
+	r := &Runner{
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
+	}
+
+	err := r.refreshInstances(context.Background())
+	assert.NoError(t, err)
+
+	// the hourly budget is used up by scaling in while the replacement is launching
+	statusStore.StoreScalingStatus(&ScalingStatus{
+		Removals: []Removal{{At: time.Now().Add(-10 * time.Minute), Capacity: 20, Instances: 2}},
+	})
+	statusStore.StoreCooldownEndsAt(time.Time{})
+	err = r.refreshInstances(context.Background())
+	assert.NoError(t, err)
+	ec2Client.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything)
+	st, _ := statusStore.FetchRefreshStatus()
+	assert.Equal(t, 0, st.Terminated)
+
+	// no more replacement is launched until the budget is available
+	statusStore.StoreCooldownEndsAt(time.Time{})
+	err = r.refreshInstances(context.Background())
+	assert.NoError(t, err)
+	st, _ = statusStore.FetchRefreshStatus()
+	assert.Equal(t, 1, st.Launched)
+	assert.Equal(t, 0, st.BatchLaunched)
+	ec2Client.AssertExpectations(t)
+}
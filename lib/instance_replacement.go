@@ -0,0 +1,315 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// DefaultReplacementTimeout is used if ReplacementTimeout is not set
+const DefaultReplacementTimeout = "30m"
+
+// ReplacementTimeoutDuration returns how long instances launched to replace others are waited for
+// to become working before the batch is abandoned
+func (c *Config) ReplacementTimeoutDuration() string {
+	if c.ReplacementTimeout != "" {
+		return c.ReplacementTimeout
+	}
+	return DefaultReplacementTimeout
+}
+
+// selectReplaceableInstances returns up to limit instances in candidates which can be
+// terminated while spot capacity in the worst case is kept enough for the current load
+// and the removal budget is not exceeded.
+// No instance is selected while scaling in is paused via API.
+func (r *Runner) selectReplaceableInstances(ctx context.Context, workingInstances Instances, candidates Instances, limit int) (Instances, error) {
+	paused, err := r.scaleInPaused()
+	if err != nil {
+		return nil, err
+	}
+	if paused {
+		logf(ctx, "[INFO] scaling in is paused via API, no replaced instance is terminated")
+		return Instances{}, nil
+	}
+
+	requiredWorstCase, err := r.requiredWorstCaseSpotCapacity(ctx, workingInstances)
+	if err != nil {
+		return nil, err
+	}
+
+	spotCapacity, err := workingInstances.Spot().Capacity(r.config.CapacityTable())
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err = r.withinRemovalBudget(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := Instances{}
+	for _, i := range candidates {
+		if len(selected) >= limit {
+			break
+		}
+
+		cap, err := r.config.CapacityTable().Capacity(*i.InstanceType)
+		if err != nil {
+			return nil, err
+		}
+
+		spotCapacity[i.Variety()] -= cap
+		if spotCapacity.TotalOnFailure(r.config.FailureModel()) < requiredWorstCase {
+			spotCapacity[i.Variety()] += cap
+			continue
+		}
+
+		selected = append(selected, i)
+	}
+
+	return selected, nil
+}
+
+func (r *Runner) terminateReplacedInstances(ctx context.Context, instances Instances, event string, message string, amis VarietyAMIs) error {
+	ids := []string{}
+	for _, i := range instances {
+		ids = append(ids, *i.InstanceId)
+	}
+	logf(ctx, "[INFO] %s: %v", message, ids)
+
+	err := r.confirmIfNeeded("")
+	if err != nil {
+		return err
+	}
+
+	err = r.runHookCommands(ctx, event, message, map[string]interface{}{
+		"AMIs":        amis.Distinct(),
+		"InstanceIDs": ids,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = r.takeCooldown(false, true)
+	if err != nil {
+		return err
+	}
+
+	err = r.ec2Client.TerminateInstances(ctx, instances)
+	if err != nil {
+		return err
+	}
+
+	// replaced instances are charged to the hourly removal limits as well as scaling in
+	change := map[InstanceVariety]int64{}
+	for _, i := range instances {
+		change[i.Variety()]--
+	}
+	return r.recordRemoval(change)
+}
+
+// launchReplacementInstances launches instances of the same varieties as up to limit
+// instances in replaced and returns the number of launched instances. Nothing is launched
+// while scaling in is paused via API since replaced instances would not be terminated.
+func (r *Runner) launchReplacementInstances(ctx context.Context, replaced Instances, limit int, event string, message string, amis VarietyAMIs) (int, error) {
+	paused, err := r.scaleInPaused()
+	if err != nil {
+		return 0, err
+	}
+	if paused {
+		logf(ctx, "[INFO] scaling in is paused via API, no replacement instance is launched")
+		return 0, nil
+	}
+
+	// instances which cannot be terminated within the removal budget are not replaced
+	replaced, err = r.withinRemovalBudget(ctx, replaced)
+	if err != nil {
+		return 0, err
+	}
+	if len(replaced) == 0 {
+		return 0, nil
+	}
+
+	change := map[InstanceVariety]int64{}
+	for n, i := range replaced {
+		if n >= limit {
+			break
+		}
+		change[i.Variety()]++
+	}
+
+	change, err = r.launchInstances(ctx, change, event, message, amis)
+	if err != nil {
+		return 0, err
+	}
+
+	launched := 0
+	for _, c := range change {
+		launched += int(c)
+	}
+	return launched, nil
+}
+
+// withinRemovalBudget returns instances in order from the first ones which can be
+// terminated without exceeding the removal budget
+func (r *Runner) withinRemovalBudget(ctx context.Context, instances Instances) (Instances, error) {
+	st, err := r.status.FetchScalingStatus()
+	if err != nil {
+		return nil, err
+	}
+	budget := r.config.RemovalBudget(st, r.now())
+
+	within := Instances{}
+	capacity := 0.0
+	for _, i := range instances {
+		if budget.Instances != nil && int64(len(within)) >= *budget.Instances {
+			break
+		}
+		cap, err := r.config.CapacityTable().Capacity(*i.InstanceType)
+		if err != nil {
+			return nil, err
+		}
+		if budget.Capacity != nil && capacity+cap > *budget.Capacity {
+			continue
+		}
+		within = append(within, i)
+		capacity += cap
+	}
+
+	if len(within) < len(instances) {
+		logf(ctx, "[WARN] replacing instances is limited from %d to %d by the removal budget", len(instances), len(within))
+	}
+	return within, nil
+}
+
+// scaleInPaused returns whether terminating instances is paused via API
+func (r *Runner) scaleInPaused() (bool, error) {
+	override, err := r.status.FetchScalingOverride()
+	if err != nil {
+		return false, err
+	}
+	return override.ScaleInPaused, nil
+}
+
+// launchInstances launches instances in change after the hook and takes Cooldown.
+// Launches are trimmed to fit in MaxHourlyCost as scaling does, and the launched change is returned.
+func (r *Runner) launchInstances(ctx context.Context, change map[InstanceVariety]int64, event string, message string, amis VarietyAMIs) (map[InstanceVariety]int64, error) {
+	change, err := r.budgetLaunches(ctx, change)
+	if err != nil {
+		return nil, err
+	}
+	if len(change) == 0 {
+		return change, nil
+	}
+
+	logf(ctx, "[INFO] %s: %v", message, change)
+
+	err = r.confirmIfNeeded("")
+	if err != nil {
+		return nil, err
+	}
+
+	eventDetails := []map[string]interface{}{}
+	for v, c := range change {
+		eventDetails = append(eventDetails, map[string]interface{}{
+			"Count":   c,
+			"Variety": v,
+		})
+	}
+	err = r.runHookCommands(ctx, event, message, map[string]interface{}{
+		"AMIs":    amis.Distinct(),
+		"Changes": eventDetails,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// replacement instances do not block scaling out
+	err = r.takeCooldown(false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.ec2Client.ChangeInstances(ctx, change, amis, Instances{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.updateTimer(ctx, "LaunchingInstances")
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// budgetLaunches trims change so that hourly cost of working instances after the launches
+// fits in MaxHourlyCost
+func (r *Runner) budgetLaunches(ctx context.Context, change map[InstanceVariety]int64) (map[InstanceVariety]int64, error) {
+	if r.config.MaxHourlyCost <= 0 {
+		return change, nil
+	}
+
+	workingInstances, err := r.ec2Client.DescribeWorkingInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	price, err := r.ec2Client.DescribeSpotPrices(ctx, r.instanceVarieties())
+	if err != nil {
+		return nil, err
+	}
+	ondemandCost, err := r.updateCostMetrics(ctx, workingInstances, price)
+	if err != nil {
+		return nil, err
+	}
+
+	budgeted, err := r.budgetChangeCount(ctx, workingInstances, change, ondemandCost, price)
+	if err != nil {
+		return nil, err
+	}
+	for v, c := range change {
+		if budgeted[v] < c {
+			logf(ctx, "[WARN] launching instances exceeds MaxHourlyCost: %v * %d", v, c-budgeted[v])
+		}
+	}
+	return budgeted, nil
+}
+
+// requiredWorstCaseSpotCapacity returns spot capacity in the worst case which is
+// required not to exceed the scale-out threshold under the current load and schedule
+func (r *Runner) requiredWorstCaseSpotCapacity(ctx context.Context, workingInstances Instances) (float64, error) {
+	ondemandCapacity, err := workingInstances.Ondemand().Capacity(r.config.CapacityTable())
+	if err != nil {
+		return 0.0, err
+	}
+
+	spotCapacity, err := workingInstances.Spot().Capacity(r.config.CapacityTable())
+	if err != nil {
+		return 0.0, err
+	}
+
+	cpuUtil, err := r.getCPUUtil(ctx)
+	if err != nil {
+		return 0.0, err
+	}
+
+	targetCPUUtil := r.config.MaxCPUUtil - r.config.ScaleInThreshold/2.0
+	if targetCPUUtil <= 0 {
+		return 0.0, fmt.Errorf("target CPU util must be positive: %f", targetCPUUtil)
+	}
+
+	total := ondemandCapacity.Total() + spotCapacity.Total()
+	required := cpuUtil*total/targetCPUUtil - ondemandCapacity.Total()
+
+	schedule, err := r.getCurrentSchedule()
+	if err != nil {
+		return 0.0, err
+	}
+
+	if schedule != nil {
+		required = math.Max(required, schedule.Capacity-ondemandCapacity.Total())
+	}
+
+	logf(ctx, "[DEBUG] required spot capacity in worst case: %f", required)
+	return required, nil
+}
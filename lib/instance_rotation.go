@@ -0,0 +1,135 @@
+package autoscaler
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// RotationStatus represents replacement instances launched by the last rotation.
+// Old instances are terminated after they become working, and they are abandoned at Deadline.
+type RotationStatus struct {
+	Launched   int
+	LaunchedAt time.Time
+	Deadline   time.Time
+}
+
+// rotateInstances replaces managed spot instances older than MaxInstanceLifetime.
+// Replacement capacity is launched first and old instances are terminated after it becomes
+// working, only when spot capacity in the worst case is kept enough for the current load.
+func (r *Runner) rotateInstances(ctx context.Context) error {
+	if r.config.MaxInstanceLifetime == "" {
+		return nil
+	}
+	logf(ctx, "[DEBUG] START: rotateInstances")
+
+	lifetime, err := time.ParseDuration(r.config.MaxInstanceLifetime)
+	if err != nil {
+		return err
+	}
+
+	limit := r.config.MaxRotatedInstances
+	if limit < 1 {
+		limit = 1
+	}
+
+	cooldownEndsAt, err := r.status.FetchCooldownEndsAt()
+	if err != nil {
+		return err
+	}
+
+	if r.now().Before(cooldownEndsAt) {
+		logf(ctx, "[INFO] skip instance rotation in cooldown (it ends at %s)", cooldownEndsAt)
+		return nil
+	}
+
+	workingInstances, err := r.ec2Client.DescribeWorkingInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	managed := workingInstances.ManagedBy(r.config.FullAutoscalerID()).Spot()
+	expired := managed.LaunchedBefore(r.now().Add(-lifetime))
+	r.api.UpdateMetrics(map[string]float64{
+		"expired_instances": float64(len(expired)),
+	})
+
+	status, err := r.status.FetchRotationStatus()
+	if err != nil {
+		return err
+	}
+
+	if len(expired) == 0 {
+		logf(ctx, "[DEBUG] no instance exceeds max lifetime")
+		if status.Launched > 0 {
+			return r.status.StoreRotationStatus(&RotationStatus{})
+		}
+		return nil
+	}
+	logf(ctx, "[INFO] %d instances exceed max lifetime %s", len(expired), lifetime)
+
+	amis, err := r.resolveAMIs(ctx, r.instanceVarieties())
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(SortInstancesByLaunchTime(expired))
+
+	// instances whose AMI is not determined yet are not replaced
+	replaceable := Instances{}
+	for _, i := range expired {
+		if amis[i.Variety()] != "" {
+			replaceable = append(replaceable, i)
+		}
+	}
+	if len(replaceable) == 0 {
+		logf(ctx, "[WARN] AMI is not found. Abort instance rotation")
+		return nil
+	}
+
+	if status.Launched > 0 {
+		// allow clock skew between this host and EC2
+		replacements := managed.LaunchedAfter(status.LaunchedAt.Add(-time.Minute))
+		if len(replacements) < status.Launched {
+			if !r.now().Before(status.Deadline) {
+				logf(ctx, "[WARN] replacement instances did not become working by %s, abandoning the rotation", status.Deadline)
+				return r.status.StoreRotationStatus(&RotationStatus{})
+			}
+			logf(ctx, "[INFO] waiting for replacement instances to become working (%d/%d)", len(replacements), status.Launched)
+			return nil
+		}
+
+		terminating, err := r.selectReplaceableInstances(ctx, workingInstances, replaceable, status.Launched)
+		if err != nil {
+			return err
+		}
+
+		err = r.status.StoreRotationStatus(&RotationStatus{})
+		if err != nil {
+			return err
+		}
+
+		if len(terminating) == 0 {
+			logf(ctx, "[INFO] no instance exceeding max lifetime can be terminated")
+			return nil
+		}
+		return r.terminateReplacedInstances(ctx, terminating, "rotatingInstances", "Terminating instances exceeding max lifetime", amis)
+	}
+
+	timeout, err := time.ParseDuration(r.config.ReplacementTimeoutDuration())
+	if err != nil {
+		return err
+	}
+
+	launchedAt := r.now()
+	launched, err := r.launchReplacementInstances(ctx, replaceable, limit, "rotatingInstances", "Launching replacement instances for rotation", amis)
+	if err != nil {
+		return err
+	}
+
+	return r.status.StoreRotationStatus(&RotationStatus{
+		Launched:   launched,
+		LaunchedAt: launchedAt,
+		Deadline:   launchedAt.Add(timeout),
+	})
+}
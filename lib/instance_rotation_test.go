@@ -0,0 +1,125 @@
+package autoscaler
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestRotateInstancesLaunchesReplacementFirst(t *testing.T) {
+	config := configForTest("10")
+	config.MaxInstanceLifetime = "24h"
+	config.MaxRotatedInstances = 1
+
+	old := spotInstanceForTest("i-1", "c4.large", "ami-abc")
+	old.LaunchTime = aws.Time(time.Now().Add(-48 * time.Hour))
+	young := spotInstanceForTest("i-2", "m4.large", "ami-abc")
+	young.LaunchTime = aws.Time(time.Now().Add(-1 * time.Hour))
+	instances := Instances{old, young}
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(instances, nil)
+	ec2Client.On("ChangeInstances", mock.Anything, map[InstanceVariety]int64{
+		old.Variety(): 1,
+	}, amisForTest(config), Instances{}).Return(nil)
+
+	statusStore := new(MockStatusStoreIface)
+	statusStore.On("FetchCooldownEndsAt").Return(time.Time{}, nil)
+	statusStore.On("FetchRotationStatus").Return(&RotationStatus{}, nil)
+	statusStore.On("FetchScalingOverride").Return(&ScalingOverride{}, nil)
+	statusStore.On("StoreCooldownEndsAt", mock.AnythingOfType("time.Time")).Return(nil)
+	statusStore.On("FetchScalingStatus").Return(&ScalingStatus{}, nil)
+	statusStore.On("StoreRotationStatus", mock.MatchedBy(func(st *RotationStatus) bool {
+		return st.Launched == 1 && st.Deadline.After(st.LaunchedAt)
+	})).Return(nil)
+
+	r := &Runner{
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		api:          NewAPIServer(statusStore),
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
+	}
+	err := r.rotateInstances(context.Background())
+	assert.NoError(t, err)
+	ec2Client.AssertExpectations(t)
+	statusStore.AssertExpectations(t)
+}
+
+func TestRotateInstancesTerminatesAfterReplacementIsWorking(t *testing.T) {
+	config := configForTest("10")
+	config.MaxInstanceLifetime = "24h"
+	config.MaxRotatedInstances = 1
+
+	now := time.Now()
+	old := spotInstanceForTest("i-1", "c4.large", "ami-abc")
+	old.LaunchTime = aws.Time(now.Add(-48 * time.Hour))
+	young := spotInstanceForTest("i-2", "m4.large", "ami-abc")
+	young.LaunchTime = aws.Time(now.Add(-1 * time.Hour))
+	replacement := spotInstanceForTest("i-3", "c4.large", "ami-abc")
+	replacement.LaunchTime = aws.Time(now.Add(-5 * time.Minute))
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(Instances{old, young}, nil).Once()
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(Instances{old, young, replacement}, nil).Once()
+	ec2Client.On("TerminateInstances", mock.Anything, Instances{old}).Return(nil)
+
+	statusStore := NewMemoryStatusStore(time.Now)
+	statusStore.StoreRotationStatus(&RotationStatus{Launched: 1, LaunchedAt: now.Add(-10 * time.Minute), Deadline: now.Add(20 * time.Minute)})
+
+	r := &Runner{
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		api:          NewAPIServer(statusStore),
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
+	}
+
+	// the replacement is not working yet
+	err := r.rotateInstances(context.Background())
+	assert.NoError(t, err)
+	ec2Client.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything)
+
+	err = r.rotateInstances(context.Background())
+	assert.NoError(t, err)
+	ec2Client.AssertExpectations(t)
+	st, _ := statusStore.FetchRotationStatus()
+	assert.Equal(t, 0, st.Launched)
+}
+
+func TestRotateInstancesAbandonsReplacementAfterDeadline(t *testing.T) {
+	config := configForTest("10")
+	config.MaxInstanceLifetime = "24h"
+
+	now := time.Now()
+	old := spotInstanceForTest("i-1", "c4.large", "ami-abc")
+	old.LaunchTime = aws.Time(now.Add(-48 * time.Hour))
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(Instances{old}, nil)
+
+	statusStore := NewMemoryStatusStore(time.Now)
+	statusStore.StoreRotationStatus(&RotationStatus{Launched: 1, LaunchedAt: now.Add(-time.Hour), Deadline: now.Add(-30 * time.Minute)})
+
+	r := &Runner{
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		api:          NewAPIServer(statusStore),
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
+	}
+	err := r.rotateInstances(context.Background())
+	assert.NoError(t, err)
+	ec2Client.AssertNotCalled(t, "TerminateInstances", mock.Anything, mock.Anything)
+	ec2Client.AssertNotCalled(t, "ChangeInstances", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	st, _ := statusStore.FetchRotationStatus()
+	assert.Equal(t, 0, st.Launched)
+}
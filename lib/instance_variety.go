@@ -7,25 +7,34 @@ type InstanceVariety struct {
 	Subnet       Subnet
 }
 
-func (v InstanceVariety) Capacity() (float64, error) {
-	return CapacityFromInstanceType(v.InstanceType)
+func (v InstanceVariety) Capacity(capacities CapacityTable) (float64, error) {
+	return capacities.Capacity(v.InstanceType)
 }
 
-type SortInstanceVarietiesByCapacity []InstanceVariety
+func (v InstanceVariety) Architecture(architectures ArchitectureTable) string {
+	return architectures.Architecture(v.InstanceType)
+}
+
+// SortInstanceVarietiesByCapacity sorts Varieties by capacity in Capacities,
+// and then by subnet and instance type
+type SortInstanceVarietiesByCapacity struct {
+	Varieties  []InstanceVariety
+	Capacities CapacityTable
+}
 
 func (s SortInstanceVarietiesByCapacity) Len() int {
-	return len(s)
+	return len(s.Varieties)
 }
 func (s SortInstanceVarietiesByCapacity) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
+	s.Varieties[i], s.Varieties[j] = s.Varieties[j], s.Varieties[i]
 }
 func (s SortInstanceVarietiesByCapacity) Less(i, j int) bool {
-	ic, err := s[i].Capacity()
+	ic, err := s.Varieties[i].Capacity(s.Capacities)
 	if err != nil {
 		panic(err)
 	}
 
-	jc, err := s[j].Capacity()
+	jc, err := s.Varieties[j].Capacity(s.Capacities)
 	if err != nil {
 		panic(err)
 	}
@@ -34,13 +43,18 @@ func (s SortInstanceVarietiesByCapacity) Less(i, j int) bool {
 		return ic < jc
 	}
 
-	if s[i].Subnet != s[j].Subnet {
-		return s[i].Subnet.SubnetID < s[j].Subnet.SubnetID
+	return lessInstanceVariety(s.Varieties[i], s.Varieties[j])
+}
+
+// lessInstanceVariety orders varieties by subnet and instance type, e.g. for output
+func lessInstanceVariety(a InstanceVariety, b InstanceVariety) bool {
+	if a.Subnet != b.Subnet {
+		return a.Subnet.SubnetID < b.Subnet.SubnetID
 	}
 
-	if s[i].InstanceType != s[j].InstanceType {
-		return s[i].InstanceType < s[j].InstanceType
+	if a.InstanceType != b.InstanceType {
+		return a.InstanceType < b.InstanceType
 	}
 
-	panic(fmt.Sprintf("%#v and %#v must be different", s[i], s[j]))
+	panic(fmt.Sprintf("%#v and %#v must be different", a, b))
 }
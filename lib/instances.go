@@ -1,5 +1,11 @@
 package autoscaler
 
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
 type Instances []*Instance
 
 func (is Instances) ManagedBy(managedBy string) Instances {
@@ -40,10 +46,10 @@ func (is Instances) Spot() Instances {
 	return instances
 }
 
-func (is Instances) Capacity() (InstanceCapacity, error) {
+func (is Instances) Capacity(capacities CapacityTable) (InstanceCapacity, error) {
 	c := InstanceCapacity{}
 	for _, i := range is {
-		cap, err := CapacityFromInstanceType(*i.InstanceType)
+		cap, err := capacities.Capacity(*i.InstanceType)
 		if err != nil {
 			return nil, err
 		}
@@ -51,3 +57,65 @@ func (is Instances) Capacity() (InstanceCapacity, error) {
 	}
 	return c, nil
 }
+
+// OutdatedFor returns instances launched from an AMI other than one for its variety
+func (is Instances) OutdatedFor(amis VarietyAMIs) Instances {
+	instances := Instances{}
+	for _, i := range is {
+		if aws.StringValue(i.ImageId) != amis[i.Variety()] {
+			instances = append(instances, i)
+		}
+	}
+
+	return instances
+}
+
+// UpToDateFor returns instances launched from the AMI for its variety
+func (is Instances) UpToDateFor(amis VarietyAMIs) Instances {
+	instances := Instances{}
+	for _, i := range is {
+		if aws.StringValue(i.ImageId) == amis[i.Variety()] {
+			instances = append(instances, i)
+		}
+	}
+
+	return instances
+}
+
+func (is Instances) LaunchedBefore(t time.Time) Instances {
+	instances := Instances{}
+	for _, i := range is {
+		if launchTime(i).Before(t) {
+			instances = append(instances, i)
+		}
+	}
+
+	return instances
+}
+
+func (is Instances) LaunchedAfter(t time.Time) Instances {
+	instances := Instances{}
+	for _, i := range is {
+		if launchTime(i).After(t) {
+			instances = append(instances, i)
+		}
+	}
+
+	return instances
+}
+
+type SortInstancesByLaunchTime Instances
+
+func (s SortInstancesByLaunchTime) Len() int {
+	return len(s)
+}
+func (s SortInstancesByLaunchTime) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+func (s SortInstancesByLaunchTime) Less(i, j int) bool {
+	return launchTime(s[i]).Before(launchTime(s[j]))
+}
+
+func launchTime(i *Instance) time.Time {
+	return aws.TimeValue(i.LaunchTime)
+}
@@ -1,15 +1,38 @@
 package autoscaler
 
 import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
+// LaunchConfiguration represents parameters to launch instances.
+// IPv6 addresses, KMS keys of EBS and tenancy are not supported yet since RequestSpotInstances
+// of vendored aws-sdk-go (1.5.2) lacks them, and metadata options (IMDSv2) are not supported since
+// launch specifications of spot instance requests never have them. Unknown keys in config are
+// rejected, so setting them fails instead of launching instances without them.
 type LaunchConfiguration struct {
 	KeyName                string               `yaml:"KeyName" validate:"required"`
 	SecurityGroupIDs       []string             `yaml:"SecurityGroupIDs" validate:"required"`
 	UserData               string               `yaml:"UserData"`
+	UserDataTemplate       bool                 `yaml:"UserDataTemplate"`
 	IAMInstanceProfileName string               `yaml:"IAMInstanceProfileName"`
 	BlockDeviceMappings    []BlockDeviceMapping `yaml:"BlockDeviceMappings"`
+	NetworkInterfaces      []NetworkInterface   `yaml:"NetworkInterfaces" validate:"dive"`
+	PlacementGroupName     string               `yaml:"PlacementGroupName"`
+	Monitoring             bool                 `yaml:"Monitoring"`
+	EBSOptimized           bool                 `yaml:"EBSOptimized"`
+	VolumeTags             EC2Tags              `yaml:"VolumeTags" validate:"dive"`
+}
+
+type NetworkInterface struct {
+	DeviceIndex                    int64    `yaml:"DeviceIndex"`
+	AssociatePublicIPAddress       *bool    `yaml:"AssociatePublicIPAddress"`
+	DeleteOnTermination            *bool    `yaml:"DeleteOnTermination"`
+	Description                    *string  `yaml:"Description"`
+	SecondaryPrivateIPAddressCount *int64   `yaml:"SecondaryPrivateIPAddressCount"`
+	SecurityGroupIDs               []string `yaml:"SecurityGroupIDs"`
 }
 
 type BlockDeviceMapping struct {
@@ -28,6 +51,82 @@ type EBSBlockDevice struct {
 	VolumeType          *string `yaml:"VolumeType"`
 }
 
+// Validate validates combination of launch parameters
+func (c LaunchConfiguration) Validate() error {
+	indices := map[int64]bool{}
+	for _, n := range c.NetworkInterfaces {
+		if indices[n.DeviceIndex] {
+			return fmt.Errorf("LaunchConfiguration.NetworkInterfaces: DeviceIndex %d is duplicated", n.DeviceIndex)
+		}
+		indices[n.DeviceIndex] = true
+
+		if aws.BoolValue(n.AssociatePublicIPAddress) && (n.DeviceIndex != 0 || len(c.NetworkInterfaces) > 1) {
+			return fmt.Errorf("LaunchConfiguration.NetworkInterfaces: AssociatePublicIPAddress can be set only to a single interface with DeviceIndex 0")
+		}
+	}
+
+	if len(c.NetworkInterfaces) > 0 && !indices[0] {
+		return fmt.Errorf("LaunchConfiguration.NetworkInterfaces: an interface with DeviceIndex 0 is required")
+	}
+
+	return nil
+}
+
+// SDKLaunchSpecification returns launch specification for spot instance requests in a subnet
+func (c LaunchConfiguration) SDKLaunchSpecification(v InstanceVariety, ami string, userData string) *ec2.RequestSpotLaunchSpecification {
+	spec := &ec2.RequestSpotLaunchSpecification{
+		ImageId:      aws.String(ami),
+		InstanceType: aws.String(v.InstanceType),
+		KeyName:      aws.String(c.KeyName),
+		UserData:     aws.String(userData),
+		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
+			Name: aws.String(c.IAMInstanceProfileName),
+		},
+		BlockDeviceMappings: c.SDKBlockDeviceMappings(),
+		EbsOptimized:        aws.Bool(c.EBSOptimized),
+		Monitoring: &ec2.RunInstancesMonitoringEnabled{
+			Enabled: aws.Bool(c.Monitoring),
+		},
+	}
+
+	if c.PlacementGroupName != "" {
+		spec.Placement = &ec2.SpotPlacement{
+			AvailabilityZone: aws.String(v.Subnet.AvailabilityZone),
+			GroupName:        aws.String(c.PlacementGroupName),
+		}
+	}
+
+	if len(c.NetworkInterfaces) == 0 {
+		spec.SubnetId = aws.String(v.Subnet.SubnetID)
+		spec.SecurityGroupIds = aws.StringSlice(c.SecurityGroupIDs)
+		return spec
+	}
+
+	// subnet and security groups must be specified in network interfaces instead
+	for _, n := range c.NetworkInterfaces {
+		spec.NetworkInterfaces = append(spec.NetworkInterfaces, n.SDK(v.Subnet, c.SecurityGroupIDs))
+	}
+
+	return spec
+}
+
+func (n NetworkInterface) SDK(subnet Subnet, defaultSecurityGroupIDs []string) *ec2.InstanceNetworkInterfaceSpecification {
+	securityGroupIDs := n.SecurityGroupIDs
+	if len(securityGroupIDs) == 0 {
+		securityGroupIDs = defaultSecurityGroupIDs
+	}
+
+	return &ec2.InstanceNetworkInterfaceSpecification{
+		DeviceIndex:                    aws.Int64(n.DeviceIndex),
+		SubnetId:                       aws.String(subnet.SubnetID),
+		Groups:                         aws.StringSlice(securityGroupIDs),
+		AssociatePublicIpAddress:       n.AssociatePublicIPAddress,
+		DeleteOnTermination:            n.DeleteOnTermination,
+		Description:                    n.Description,
+		SecondaryPrivateIpAddressCount: n.SecondaryPrivateIPAddressCount,
+	}
+}
+
 func (c LaunchConfiguration) SDKBlockDeviceMappings() []*ec2.BlockDeviceMapping {
 	ret := []*ec2.BlockDeviceMapping{}
 	for _, m := range c.BlockDeviceMappings {
@@ -46,6 +145,10 @@ func (m BlockDeviceMapping) SDK() *ec2.BlockDeviceMapping {
 }
 
 func (e *EBSBlockDevice) SDK() *ec2.EbsBlockDevice {
+	if e == nil {
+		return nil
+	}
+
 	return &ec2.EbsBlockDevice{
 		DeleteOnTermination: e.DeleteOnTermination,
 		Encrypted:           e.Encrypted,
@@ -0,0 +1,56 @@
+package autoscaler
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSDKLaunchSpecification(t *testing.T) {
+	c := LaunchConfiguration{
+		KeyName:          "key",
+		SecurityGroupIDs: []string{"sg-abc"},
+		BlockDeviceMappings: []BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/sdb"), VirtualName: aws.String("ephemeral0")},
+		},
+		PlacementGroupName: "pg",
+		Monitoring:         true,
+	}
+	v := InstanceVariety{
+		InstanceType: "c4.large",
+		Subnet:       Subnet{SubnetID: "subnet-abc", AvailabilityZone: "ap-northeast-1a"},
+	}
+
+	spec := c.SDKLaunchSpecification(v, "ami-abc", "")
+	assert.Equal(t, "subnet-abc", *spec.SubnetId)
+	assert.Equal(t, []*string{aws.String("sg-abc")}, spec.SecurityGroupIds)
+	assert.Equal(t, "pg", *spec.Placement.GroupName)
+	assert.Equal(t, "ap-northeast-1a", *spec.Placement.AvailabilityZone)
+	assert.True(t, *spec.Monitoring.Enabled)
+	assert.Nil(t, spec.BlockDeviceMappings[0].Ebs)
+
+	c.NetworkInterfaces = []NetworkInterface{
+		{DeviceIndex: 0, AssociatePublicIPAddress: aws.Bool(true)},
+	}
+	assert.NoError(t, c.Validate())
+
+	spec = c.SDKLaunchSpecification(v, "ami-abc", "")
+	assert.Nil(t, spec.SubnetId)
+	assert.Nil(t, spec.SecurityGroupIds)
+	assert.Equal(t, "subnet-abc", *spec.NetworkInterfaces[0].SubnetId)
+	assert.Equal(t, []*string{aws.String("sg-abc")}, spec.NetworkInterfaces[0].Groups)
+	assert.True(t, *spec.NetworkInterfaces[0].AssociatePublicIpAddress)
+}
+
+func TestLaunchConfigurationValidate(t *testing.T) {
+	c := LaunchConfiguration{
+		NetworkInterfaces: []NetworkInterface{
+			{DeviceIndex: 0},
+			{DeviceIndex: 1, AssociatePublicIPAddress: aws.Bool(true)},
+		},
+	}
+	assert.Error(t, c.Validate())
+
+	c.NetworkInterfaces = []NetworkInterface{{DeviceIndex: 1}}
+	assert.Error(t, c.Validate())
+}
@@ -0,0 +1,84 @@
+package autoscaler
+
+import (
+	"fmt"
+)
+
+// LaunchOverride overrides LaunchConfiguration and AMI for some instance types or an architecture
+type LaunchOverride struct {
+	AMICommand          *Command             `yaml:"AMICommand"`
+	AMIResolver         *AMIResolverConfig   `yaml:"AMIResolver"`
+	BlockDeviceMappings []BlockDeviceMapping `yaml:"BlockDeviceMappings"`
+	UserData            *string              `yaml:"UserData"`
+	SecurityGroupIDs    []string             `yaml:"SecurityGroupIDs"`
+}
+
+func (o LaunchOverride) hasAMI() bool {
+	return o.AMICommand != nil || o.AMIResolver != nil
+}
+
+func (o LaunchOverride) Validate() error {
+	if o.AMIResolver != nil {
+		return o.AMIResolver.Validate()
+	}
+	return nil
+}
+
+func (o LaunchOverride) apply(c LaunchConfiguration) LaunchConfiguration {
+	if o.BlockDeviceMappings != nil {
+		c.BlockDeviceMappings = o.BlockDeviceMappings
+	}
+	if o.UserData != nil {
+		c.UserData = *o.UserData
+	}
+	if o.SecurityGroupIDs != nil {
+		c.SecurityGroupIDs = o.SecurityGroupIDs
+	}
+	return c
+}
+
+// LaunchConfigurationFor returns LaunchConfiguration merged with overrides for
+// the architecture and the instance type of v. Override by instance type wins.
+func (c *Config) LaunchConfigurationFor(v InstanceVariety) LaunchConfiguration {
+	lc := c.LaunchConfiguration
+	if o, ok := c.LaunchOverridesByArchitecture[v.Architecture(c.ArchitectureTable())]; ok {
+		lc = o.apply(lc)
+	}
+	if o, ok := c.LaunchOverridesByType[v.InstanceType]; ok {
+		lc = o.apply(lc)
+	}
+	return lc
+}
+
+// AMISourceKeyFor returns key of AMI resolver used for v.
+// An empty key means AMICommand or AMIResolver at top level.
+func (c *Config) AMISourceKeyFor(v InstanceVariety) string {
+	if o, ok := c.LaunchOverridesByType[v.InstanceType]; ok && o.hasAMI() {
+		return fmt.Sprintf("type:%s", v.InstanceType)
+	}
+	if o, ok := c.LaunchOverridesByArchitecture[v.Architecture(c.ArchitectureTable())]; ok && o.hasAMI() {
+		return fmt.Sprintf("arch:%s", v.Architecture(c.ArchitectureTable()))
+	}
+	return ""
+}
+
+func (c *Config) validateLaunchOverrides() error {
+	for a, o := range c.LaunchOverridesByArchitecture {
+		if a != "x86_64" && a != "arm64" && a != "i386" {
+			return fmt.Errorf("LaunchOverridesByArchitecture: unknown architecture %s", a)
+		}
+		err := o.Validate()
+		if err != nil {
+			return fmt.Errorf("LaunchOverridesByArchitecture[%s]: %s", a, err)
+		}
+	}
+
+	for t, o := range c.LaunchOverridesByType {
+		err := o.Validate()
+		if err != nil {
+			return fmt.Errorf("LaunchOverridesByType[%s]: %s", t, err)
+		}
+	}
+
+	return nil
+}
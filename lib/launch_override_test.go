@@ -0,0 +1,72 @@
+package autoscaler
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func TestArchitectureTable(t *testing.T) {
+	architectures := ArchitectureTable{"x1.large": "arm64"}
+
+	assert.Equal(t, "x86_64", architectures.Architecture("c4.large"))
+	assert.Equal(t, "x86_64", architectures.Architecture("g4dn.xlarge"))
+	assert.Equal(t, "arm64", architectures.Architecture("m6g.large"))
+	assert.Equal(t, "arm64", architectures.Architecture("c6gn.large"))
+	assert.Equal(t, "arm64", architectures.Architecture("a1.medium"))
+	assert.Equal(t, "arm64", architectures.Architecture("x1.large"))
+}
+
+func TestLaunchConfigurationFor(t *testing.T) {
+	armUserData := "arm"
+	config := configForTest("50")
+	config.LaunchConfiguration.UserData = "default"
+	config.LaunchConfiguration.SecurityGroupIDs = []string{"sg-default"}
+	config.LaunchOverridesByArchitecture = map[string]LaunchOverride{
+		"arm64": {UserData: &armUserData, SecurityGroupIDs: []string{"sg-arm"}},
+	}
+	config.LaunchOverridesByType = map[string]LaunchOverride{
+		"m6g.large": {SecurityGroupIDs: []string{"sg-m6g"}},
+	}
+
+	x86 := InstanceVariety{InstanceType: "c4.large"}
+	arm := InstanceVariety{InstanceType: "c6g.large"}
+	m6g := InstanceVariety{InstanceType: "m6g.large"}
+
+	assert.Equal(t, "default", config.LaunchConfigurationFor(x86).UserData)
+	assert.Equal(t, []string{"sg-default"}, config.LaunchConfigurationFor(x86).SecurityGroupIDs)
+	assert.Equal(t, "arm", config.LaunchConfigurationFor(arm).UserData)
+	assert.Equal(t, []string{"sg-arm"}, config.LaunchConfigurationFor(arm).SecurityGroupIDs)
+	assert.Equal(t, "arm", config.LaunchConfigurationFor(m6g).UserData)
+	assert.Equal(t, []string{"sg-m6g"}, config.LaunchConfigurationFor(m6g).SecurityGroupIDs)
+}
+
+func TestResolveAMIsWithOverrides(t *testing.T) {
+	config := configForTest("50")
+	config.InstanceTypes = []string{"c4.large", "c6g.large"}
+	config.LaunchOverridesByArchitecture = map[string]LaunchOverride{
+		"arm64": {AMICommand: &Command{Command: "echo", Args: []string{"-n", "ami-arm"}}},
+	}
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{State: aws.String("available")}, nil).Once()
+	ec2Client.On("DescribeImage", mock.Anything, "ami-arm").Return(&ec2.Image{State: aws.String("available")}, nil).Once()
+
+	resolvers, err := NewAMIResolvers(config, ec2Client, nil)
+	assert.NoError(t, err)
+
+	r := &Runner{
+		config:       config,
+		ec2Client:    ec2Client,
+		amiResolvers: resolvers,
+	}
+	vs := config.InstanceVarieties()
+	amis, err := r.resolveAMIs(context.Background(), vs)
+	assert.NoError(t, err)
+	assert.Equal(t, VarietyAMIs{vs[0]: "ami-abc", vs[1]: "ami-arm"}, amis)
+	assert.Equal(t, []string{"ami-abc", "ami-arm"}, amis.Distinct())
+	ec2Client.AssertExpectations(t)
+}
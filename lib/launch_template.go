@@ -0,0 +1,121 @@
+package autoscaler
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// LaunchTemplateData is passed to templates of UserData, InstanceTags and NameTag
+type LaunchTemplateData struct {
+	InstanceType     string
+	SubnetID         string
+	AvailabilityZone string
+	Capacity         float64
+	AMI              string
+	AutoscalerID     string
+	LaunchedAt       time.Time
+}
+
+func NewLaunchTemplateData(config *Config, v InstanceVariety, ami string, launchedAt time.Time) (LaunchTemplateData, error) {
+	capacity, err := v.Capacity(config.CapacityTable())
+	if err != nil {
+		return LaunchTemplateData{}, err
+	}
+
+	return LaunchTemplateData{
+		InstanceType:     v.InstanceType,
+		SubnetID:         v.Subnet.SubnetID,
+		AvailabilityZone: v.Subnet.AvailabilityZone,
+		Capacity:         capacity,
+		AMI:              ami,
+		AutoscalerID:     config.AutoscalerID,
+		LaunchedAt:       launchedAt,
+	}, nil
+}
+
+func renderTemplate(name string, text string, data LaunchTemplateData) (string, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	b := &bytes.Buffer{}
+	err = t.Execute(b, data)
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// RenderUserData renders UserData of LaunchConfiguration if UserDataTemplate is enabled.
+// Otherwise UserData is used as is since "{{" may appear in scripts.
+func (c LaunchConfiguration) RenderUserData(data LaunchTemplateData) (string, error) {
+	if !c.UserDataTemplate {
+		return c.UserData, nil
+	}
+	return renderTemplate("UserData", c.UserData, data)
+}
+
+// RenderInstanceTags renders values of InstanceTags and NameTag
+func (c *Config) RenderInstanceTags(data LaunchTemplateData) (EC2Tags, error) {
+	tags := EC2Tags{}
+	for _, t := range c.InstanceTags {
+		v, err := renderTemplate(fmt.Sprintf("InstanceTags[%s]", t.Key), t.Value, data)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, EC2Tag{Key: t.Key, Value: v})
+	}
+
+	if c.NameTag != "" {
+		v, err := renderTemplate("NameTag", c.NameTag, data)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, EC2Tag{Key: "Name", Value: v})
+	}
+
+	return tags, nil
+}
+
+// validateLaunchTemplates renders templates with dummy data to find errors before launching
+func (c *Config) validateLaunchTemplates() error {
+	data := LaunchTemplateData{
+		InstanceType:     "c4.large",
+		SubnetID:         "subnet-00000000",
+		AvailabilityZone: "us-east-1a",
+		Capacity:         1,
+		AMI:              "ami-00000000",
+		AutoscalerID:     c.AutoscalerID,
+		LaunchedAt:       time.Now(),
+	}
+
+	_, err := c.LaunchConfiguration.RenderUserData(data)
+	if err != nil {
+		return fmt.Errorf("invalid template: %s", err)
+	}
+
+	for k, o := range c.LaunchOverridesByArchitecture {
+		_, err := o.apply(c.LaunchConfiguration).RenderUserData(data)
+		if err != nil {
+			return fmt.Errorf("invalid template in LaunchOverridesByArchitecture[%s]: %s", k, err)
+		}
+	}
+
+	for k, o := range c.LaunchOverridesByType {
+		_, err := o.apply(c.LaunchConfiguration).RenderUserData(data)
+		if err != nil {
+			return fmt.Errorf("invalid template in LaunchOverridesByType[%s]: %s", k, err)
+		}
+	}
+
+	_, err = c.RenderInstanceTags(data)
+	if err != nil {
+		return fmt.Errorf("invalid template: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package autoscaler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRenderLaunchTemplates(t *testing.T) {
+	config := configForTest("50")
+	config.LaunchConfiguration.UserData = "#!/bin/sh\necho {{.InstanceType}} {{.Capacity}} {{.AMI}}\n"
+	config.LaunchConfiguration.UserDataTemplate = true
+	config.InstanceTags = EC2Tags{{Key: "Zone", Value: "{{.AvailabilityZone}}"}}
+	config.NameTag = "{{.AutoscalerID}}-{{.LaunchedAt.Format \"20060102\"}}"
+
+	data, err := NewLaunchTemplateData(config, config.InstanceVarieties()[0], "ami-abc", time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+
+	userData, err := config.LaunchConfiguration.RenderUserData(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\necho c4.large 10 ami-abc\n", userData)
+
+	// UserData is not a template unless UserDataTemplate is enabled
+	config.LaunchConfiguration.UserDataTemplate = false
+	userData, err = config.LaunchConfiguration.RenderUserData(data)
+	assert.NoError(t, err)
+	assert.Equal(t, config.LaunchConfiguration.UserData, userData)
+
+	tags, err := config.RenderInstanceTags(data)
+	assert.NoError(t, err)
+	assert.Equal(t, EC2Tags{
+		{Key: "Zone", Value: "ap-northeast-1b"},
+		{Key: "Name", Value: "test-20170102"},
+	}, tags)
+}
+
+func TestValidateLaunchTemplates(t *testing.T) {
+	config := configForTest("50")
+	config.LaunchConfiguration.UserData = "{{.Unknown}}"
+	assert.NoError(t, config.validateLaunchTemplates())
+	config.LaunchConfiguration.UserDataTemplate = true
+	assert.Error(t, config.validateLaunchTemplates())
+
+	config.LaunchConfiguration.UserData = ""
+	config.NameTag = "{{.InstanceType"
+	assert.Error(t, config.validateLaunchTemplates())
+}
@@ -0,0 +1,174 @@
+package autoscaler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log lines are written by the log package as "[LEVEL] message". Fields given to logWithFields
+// follow the message after a tab as key=value pairs. JSONLogWriter converts these lines into JSON.
+
+const logFieldsSeparator = "\t"
+
+// LogFields are structured fields of a log line
+type LogFields map[string]interface{}
+
+func (fs LogFields) String() string {
+	keys := []string{}
+	for k := range fs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, k := range keys {
+		v := fmt.Sprint(fs[k])
+		if vs, ok := fs[k].([]string); ok {
+			v = strings.Join(vs, ",")
+		}
+		if v == "" || strings.ContainsAny(v, " \t\"=") {
+			v = strconv.Quote(v)
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// logWithFields writes a log line with structured fields and the run ID in ctx
+func logWithFields(ctx context.Context, level string, message string, fields LogFields) {
+	if id := logRunID(ctx); id != "" {
+		withRunID := LogFields{logRunIDField: id}
+		for k, v := range fields {
+			withRunID[k] = v
+		}
+		fields = withRunID
+	}
+	log.Printf("[%s] %s%s%s", level, message, logFieldsSeparator, fields)
+}
+
+// logf writes a log line formatted as log.Printf does with the run ID in ctx
+func logf(ctx context.Context, format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	if id := logRunID(ctx); id != "" {
+		message += logFieldsSeparator + LogFields{logRunIDField: id}.String()
+	}
+	log.Print(message)
+}
+
+// logRunIDField is the field of log lines which JSONLogWriter writes as run_id
+const logRunIDField = "run_id"
+
+type logRunKey struct{}
+
+var logRunCount uint64
+
+// withLogRun returns ctx with a new run ID, which is attached to log lines written with it.
+// The run ID is carried by ctx since loops of groups run concurrently.
+func withLogRun(ctx context.Context) (context.Context, string) {
+	id := fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405"), atomic.AddUint64(&logRunCount, 1))
+	return context.WithValue(ctx, logRunKey{}, id), id
+}
+
+// logRunID returns the run ID in ctx or an empty string
+func logRunID(ctx context.Context) string {
+	id, _ := ctx.Value(logRunKey{}).(string)
+	return id
+}
+
+// JSONLogWriter converts log lines into JSON lines with time, level, message, run_id and fields.
+// Values of fields are strings, and run_id is taken from the field of the same name.
+type JSONLogWriter struct {
+	Writer io.Writer
+	mutex  sync.Mutex
+}
+
+var logLinePattern = regexp.MustCompile(`(?s)^\[(TRACE|DEBUG|INFO|WARN|ERROR)\] ?(.*)$`)
+var logFieldPattern = regexp.MustCompile(`(\w+)=("(?:[^"\\]|\\.)*"|\S*)`)
+
+func (w *JSONLogWriter) Write(p []byte) (int, error) {
+	// the log package writes a line (which may include newlines in its message) at once
+	line := strings.TrimRight(string(p), "\n")
+	entry := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": "INFO",
+	}
+
+	message := line
+	if m := logLinePattern.FindStringSubmatch(line); m != nil {
+		entry["level"] = m[1]
+		message = m[2]
+	}
+	if i := strings.LastIndex(message, logFieldsSeparator); i >= 0 {
+		fields := map[string]string{}
+		for _, m := range logFieldPattern.FindAllStringSubmatch(message[i+1:], -1) {
+			v := m[2]
+			if uq, err := strconv.Unquote(v); err == nil {
+				v = uq
+			}
+			fields[m[1]] = v
+		}
+		if id, ok := fields[logRunIDField]; ok {
+			entry["run_id"] = id
+			delete(fields, logRunIDField)
+		}
+		if len(fields) > 0 {
+			entry["fields"] = fields
+		}
+		message = message[:i]
+	}
+	entry["message"] = message
+
+	j, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err = w.Writer.Write(append(j, '\n'))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// logLineWriter writes each line written to it as a log line of level with prefix.
+// It is used for output of commands, which would break JSON log lines if written to stdout.
+type logLineWriter struct {
+	ctx    context.Context
+	level  string
+	prefix string
+	buf    []byte
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		logf(w.ctx, "[%s] %s%s", w.level, w.prefix, w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line without a newline
+func (w *logLineWriter) Flush() {
+	if len(w.buf) > 0 {
+		logf(w.ctx, "[%s] %s%s", w.level, w.prefix, w.buf)
+		w.buf = nil
+	}
+}
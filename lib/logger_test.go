@@ -0,0 +1,133 @@
+package autoscaler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLogWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.Writer())
+	defer log.SetFlags(log.Flags())
+	log.SetOutput(&JSONLogWriter{Writer: buf})
+	log.SetFlags(0)
+
+	ctx, id := withLogRun(context.Background())
+	logf(ctx, "[DEBUG] ondemand capacity: %f", 10.0)
+	logWithFields(ctx, "INFO", "spot instances are requested", LogFields{
+		"sir_ids": []string{"sir-1", "sir-2"},
+		"note":    "a b",
+	})
+	logf(context.Background(), "no level")
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 3)
+
+	entries := []map[string]interface{}{}
+	for _, l := range lines {
+		e := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(l, &e))
+		entries = append(entries, e)
+	}
+
+	assert.Equal(t, "DEBUG", entries[0]["level"])
+	assert.Equal(t, "ondemand capacity: 10.000000", entries[0]["message"])
+	assert.Equal(t, id, entries[0]["run_id"])
+	assert.Nil(t, entries[0]["fields"])
+
+	assert.Equal(t, "INFO", entries[1]["level"])
+	assert.Equal(t, "spot instances are requested", entries[1]["message"])
+	assert.Equal(t, map[string]interface{}{"sir_ids": "sir-1,sir-2", "note": "a b"}, entries[1]["fields"])
+	assert.Equal(t, id, entries[1]["run_id"])
+
+	assert.Equal(t, "INFO", entries[2]["level"])
+	assert.Nil(t, entries[2]["run_id"])
+}
+
+func TestRunIDOfConcurrentGroups(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.Writer())
+	defer log.SetFlags(log.Flags())
+	log.SetOutput(&JSONLogWriter{Writer: buf})
+	log.SetFlags(0)
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	web, _, _ := newScalePlanTestRunner(t, &now)
+	web.config.AutoscalerID = "web"
+	api, _, _ := newScalePlanTestRunner(t, &now)
+	api.config.AutoscalerID = "api"
+
+	wg := &sync.WaitGroup{}
+	for _, r := range []*Runner{web, api} {
+		wg.Add(1)
+		go func(r *Runner) {
+			defer wg.Done()
+			for n := 0; n < 5; n++ {
+				assert.NoError(t, r.Run(context.Background()))
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	groups := map[string]string{}
+	lines := map[string][]string{}
+	for _, l := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		e := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(l, &e), string(l))
+		id, ok := e["run_id"].(string)
+		if !assert.True(t, ok, "a line in a run has no run_id: %s", l) {
+			continue
+		}
+		message := e["message"].(string)
+		if message == "START Runner.Run" {
+			assert.Empty(t, groups[id], "run ID %s is used by more than one run", id)
+			groups[id] = e["fields"].(map[string]interface{})["autoscaler_id"].(string)
+		}
+		lines[id] = append(lines[id], message)
+	}
+
+	count := map[string]int{}
+	for id, messages := range lines {
+		count[groups[id]]++
+		// each run ID is used from the start to the end of a run
+		assert.Equal(t, "START Runner.Run", messages[0])
+		assert.Equal(t, "END Runner.Run", messages[len(messages)-1])
+	}
+	assert.Equal(t, map[string]int{"web": 5, "api": 5}, count)
+}
+
+func TestCommandAndAPIOutputGoThroughLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.Writer())
+	defer log.SetFlags(log.Flags())
+	log.SetOutput(&JSONLogWriter{Writer: buf})
+	log.SetFlags(0)
+
+	err := Command{Command: "sh", Args: []string{"-c", "cat; echo err >&2; printf last"}}.RunWithStdin(context.Background(), "hook input\n")
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/groups", nil)
+	NewGroupsAPIServer(map[string]*APIServer{}).handler().ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	messages := map[string]string{}
+	for _, l := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		e := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(l, &e), string(l))
+		messages[e["message"].(string)] = e["level"].(string)
+	}
+	assert.Equal(t, "INFO", messages["sh: hook input"])
+	assert.Equal(t, "WARN", messages["sh: err"])
+	assert.Equal(t, "INFO", messages["sh: last"])
+	assert.Equal(t, "DEBUG", messages["API request"])
+}
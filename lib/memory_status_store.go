@@ -0,0 +1,199 @@
+package autoscaler
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStatusStore stores status data in memory (e.g. for simulation)
+type MemoryStatusStore struct {
+	mutex           sync.Mutex
+	clock           func() time.Time
+	cooldownEndsAt  time.Time
+	schedules       map[string]*Schedule
+	timers          map[string]time.Time
+	pinnedAMIs      map[string]string
+	refreshStatus   RefreshStatus
+	refreshPaused   bool
+	rotationStatus  RotationStatus
+	scalingStatus   ScalingStatus
+	scalingOverride ScalingOverride
+	rebalanceStatus RebalanceStatus
+}
+
+// NewMemoryStatusStore returns a MemoryStatusStore. Timers expire according to clock.
+func NewMemoryStatusStore(clock func() time.Time) *MemoryStatusStore {
+	return &MemoryStatusStore{
+		clock:      clock,
+		schedules:  map[string]*Schedule{},
+		timers:     map[string]time.Time{},
+		pinnedAMIs: map[string]string{},
+	}
+}
+
+func (s *MemoryStatusStore) StoreCooldownEndsAt(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cooldownEndsAt = t
+	return nil
+}
+
+func (s *MemoryStatusStore) FetchCooldownEndsAt() (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cooldownEndsAt, nil
+}
+
+func (s *MemoryStatusStore) ListSchedules() ([]*Schedule, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	schedules := []*Schedule{}
+	for _, sch := range s.schedules {
+		c := *sch
+		schedules = append(schedules, &c)
+	}
+	return schedules, nil
+}
+
+func (s *MemoryStatusStore) AddSchedules(sch *Schedule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := *sch
+	s.schedules[sch.Key] = &c
+	return nil
+}
+
+func (s *MemoryStatusStore) RemoveSchedule(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.schedules, key)
+	return nil
+}
+
+func (s *MemoryStatusStore) UpdateTimer(key string, t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.timers[key] = t
+	return nil
+}
+
+func (s *MemoryStatusStore) DeleteTimer(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.timers, key)
+	return nil
+}
+
+func (s *MemoryStatusStore) GetExpiredTimers() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := []string{}
+	for k, t := range s.timers {
+		if t.Before(s.clock()) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryStatusStore) StorePinnedAMI(key string, ami string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if ami == "" {
+		delete(s.pinnedAMIs, key)
+		return nil
+	}
+	s.pinnedAMIs[key] = ami
+	return nil
+}
+
+func (s *MemoryStatusStore) FetchPinnedAMI(key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pinnedAMIs[key], nil
+}
+
+func (s *MemoryStatusStore) StoreRefreshStatus(st *RefreshStatus) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refreshStatus = *st
+	return nil
+}
+
+func (s *MemoryStatusStore) FetchRefreshStatus() (*RefreshStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st := s.refreshStatus
+	return &st, nil
+}
+
+func (s *MemoryStatusStore) StoreRefreshPaused(paused bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refreshPaused = paused
+	return nil
+}
+
+func (s *MemoryStatusStore) FetchRefreshPaused() (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.refreshPaused, nil
+}
+
+func (s *MemoryStatusStore) StoreRotationStatus(st *RotationStatus) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rotationStatus = *st
+	return nil
+}
+
+func (s *MemoryStatusStore) FetchRotationStatus() (*RotationStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st := s.rotationStatus
+	return &st, nil
+}
+
+func (s *MemoryStatusStore) StoreScalingStatus(st *ScalingStatus) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scalingStatus = *st
+	s.scalingStatus.Removals = append([]Removal{}, st.Removals...)
+	return nil
+}
+
+func (s *MemoryStatusStore) FetchScalingStatus() (*ScalingStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st := s.scalingStatus
+	st.Removals = append([]Removal{}, s.scalingStatus.Removals...)
+	return &st, nil
+}
+
+func (s *MemoryStatusStore) StoreScalingOverride(o *ScalingOverride) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scalingOverride = *o
+	return nil
+}
+
+func (s *MemoryStatusStore) FetchScalingOverride() (*ScalingOverride, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	o := s.scalingOverride
+	return &o, nil
+}
+
+func (s *MemoryStatusStore) StoreRebalanceStatus(st *RebalanceStatus) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rebalanceStatus = *st
+	return nil
+}
+
+func (s *MemoryStatusStore) FetchRebalanceStatus() (*RebalanceStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st := s.rebalanceStatus
+	return &st, nil
+}
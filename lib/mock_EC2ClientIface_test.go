@@ -1,5 +1,6 @@
 package autoscaler
 
+import context "context"
 import ec2 "github.com/aws/aws-sdk-go/service/ec2"
 import mock "github.com/stretchr/testify/mock"
 
@@ -8,13 +9,13 @@ type MockEC2ClientIface struct {
 	mock.Mock
 }
 
-// CancelOpenSIRs provides a mock function with given fields: reqs
-func (_m *MockEC2ClientIface) CancelOpenSIRs(reqs []*ec2.SpotInstanceRequest) error {
-	ret := _m.Called(reqs)
+// CancelOpenSIRs provides a mock function with given fields: ctx, reqs
+func (_m *MockEC2ClientIface) CancelOpenSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error {
+	ret := _m.Called(ctx, reqs)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func([]*ec2.SpotInstanceRequest) error); ok {
-		r0 = rf(reqs)
+	if rf, ok := ret.Get(0).(func(context.Context, []*ec2.SpotInstanceRequest) error); ok {
+		r0 = rf(ctx, reqs)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -22,13 +23,13 @@ func (_m *MockEC2ClientIface) CancelOpenSIRs(reqs []*ec2.SpotInstanceRequest) er
 	return r0
 }
 
-// ChangeInstances provides a mock function with given fields: change, ami, terminationTarget
-func (_m *MockEC2ClientIface) ChangeInstances(change map[InstanceVariety]int64, ami string, terminationTarget Instances) error {
-	ret := _m.Called(change, ami, terminationTarget)
+// ChangeInstances provides a mock function with given fields: ctx, change, amis, terminationTarget
+func (_m *MockEC2ClientIface) ChangeInstances(ctx context.Context, change map[InstanceVariety]int64, amis VarietyAMIs, terminationTarget Instances) error {
+	ret := _m.Called(ctx, change, amis, terminationTarget)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(map[InstanceVariety]int64, string, Instances) error); ok {
-		r0 = rf(change, ami, terminationTarget)
+	if rf, ok := ret.Get(0).(func(context.Context, map[InstanceVariety]int64, VarietyAMIs, Instances) error); ok {
+		r0 = rf(ctx, change, amis, terminationTarget)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -36,13 +37,13 @@ func (_m *MockEC2ClientIface) ChangeInstances(change map[InstanceVariety]int64,
 	return r0
 }
 
-// CreateStatusTagsOfSIRs provides a mock function with given fields: reqs, status
-func (_m *MockEC2ClientIface) CreateStatusTagsOfSIRs(reqs []*ec2.SpotInstanceRequest, status string) error {
-	ret := _m.Called(reqs, status)
+// CreateStatusTagsOfSIRs provides a mock function with given fields: ctx, reqs, status
+func (_m *MockEC2ClientIface) CreateStatusTagsOfSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest, status string) error {
+	ret := _m.Called(ctx, reqs, status)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func([]*ec2.SpotInstanceRequest, string) error); ok {
-		r0 = rf(reqs, status)
+	if rf, ok := ret.Get(0).(func(context.Context, []*ec2.SpotInstanceRequest, string) error); ok {
+		r0 = rf(ctx, reqs, status)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -50,13 +51,13 @@ func (_m *MockEC2ClientIface) CreateStatusTagsOfSIRs(reqs []*ec2.SpotInstanceReq
 	return r0
 }
 
-// DescribeDeadSIRs provides a mock function with given fields:
-func (_m *MockEC2ClientIface) DescribeDeadSIRs() ([]*ec2.SpotInstanceRequest, error) {
-	ret := _m.Called()
+// DescribeDeadSIRs provides a mock function with given fields: ctx
+func (_m *MockEC2ClientIface) DescribeDeadSIRs(ctx context.Context) ([]*ec2.SpotInstanceRequest, error) {
+	ret := _m.Called(ctx)
 
 	var r0 []*ec2.SpotInstanceRequest
-	if rf, ok := ret.Get(0).(func() []*ec2.SpotInstanceRequest); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) []*ec2.SpotInstanceRequest); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*ec2.SpotInstanceRequest)
@@ -64,8 +65,8 @@ func (_m *MockEC2ClientIface) DescribeDeadSIRs() ([]*ec2.SpotInstanceRequest, er
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -73,13 +74,59 @@ func (_m *MockEC2ClientIface) DescribeDeadSIRs() ([]*ec2.SpotInstanceRequest, er
 	return r0, r1
 }
 
-// DescribePendingAndActiveSIRs provides a mock function with given fields:
-func (_m *MockEC2ClientIface) DescribePendingAndActiveSIRs() ([]*ec2.SpotInstanceRequest, error) {
-	ret := _m.Called()
+// DescribeImage provides a mock function with given fields: ctx, id
+func (_m *MockEC2ClientIface) DescribeImage(ctx context.Context, id string) (*ec2.Image, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *ec2.Image
+	if rf, ok := ret.Get(0).(func(context.Context, string) *ec2.Image); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*ec2.Image)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DescribeLatestImage provides a mock function with given fields: ctx, owners, filters
+func (_m *MockEC2ClientIface) DescribeLatestImage(ctx context.Context, owners []string, filters EC2Filters) (*ec2.Image, error) {
+	ret := _m.Called(ctx, owners, filters)
+
+	var r0 *ec2.Image
+	if rf, ok := ret.Get(0).(func(context.Context, []string, EC2Filters) *ec2.Image); ok {
+		r0 = rf(ctx, owners, filters)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*ec2.Image)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string, EC2Filters) error); ok {
+		r1 = rf(ctx, owners, filters)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DescribePendingAndActiveSIRs provides a mock function with given fields: ctx
+func (_m *MockEC2ClientIface) DescribePendingAndActiveSIRs(ctx context.Context) ([]*ec2.SpotInstanceRequest, error) {
+	ret := _m.Called(ctx)
 
 	var r0 []*ec2.SpotInstanceRequest
-	if rf, ok := ret.Get(0).(func() []*ec2.SpotInstanceRequest); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) []*ec2.SpotInstanceRequest); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*ec2.SpotInstanceRequest)
@@ -87,8 +134,8 @@ func (_m *MockEC2ClientIface) DescribePendingAndActiveSIRs() ([]*ec2.SpotInstanc
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -96,13 +143,13 @@ func (_m *MockEC2ClientIface) DescribePendingAndActiveSIRs() ([]*ec2.SpotInstanc
 	return r0, r1
 }
 
-// DescribeSpotPrices provides a mock function with given fields: vs
-func (_m *MockEC2ClientIface) DescribeSpotPrices(vs []InstanceVariety) (map[InstanceVariety]float64, error) {
-	ret := _m.Called(vs)
+// DescribeSpotPrices provides a mock function with given fields: ctx, vs
+func (_m *MockEC2ClientIface) DescribeSpotPrices(ctx context.Context, vs []InstanceVariety) (map[InstanceVariety]float64, error) {
+	ret := _m.Called(ctx, vs)
 
 	var r0 map[InstanceVariety]float64
-	if rf, ok := ret.Get(0).(func([]InstanceVariety) map[InstanceVariety]float64); ok {
-		r0 = rf(vs)
+	if rf, ok := ret.Get(0).(func(context.Context, []InstanceVariety) map[InstanceVariety]float64); ok {
+		r0 = rf(ctx, vs)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(map[InstanceVariety]float64)
@@ -110,8 +157,31 @@ func (_m *MockEC2ClientIface) DescribeSpotPrices(vs []InstanceVariety) (map[Inst
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func([]InstanceVariety) error); ok {
-		r1 = rf(vs)
+	if rf, ok := ret.Get(1).(func(context.Context, []InstanceVariety) error); ok {
+		r1 = rf(ctx, vs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DescribeSubnets provides a mock function with given fields: ctx, ids, filters
+func (_m *MockEC2ClientIface) DescribeSubnets(ctx context.Context, ids []string, filters EC2Filters) ([]Subnet, error) {
+	ret := _m.Called(ctx, ids, filters)
+
+	var r0 []Subnet
+	if rf, ok := ret.Get(0).(func(context.Context, []string, EC2Filters) []Subnet); ok {
+		r0 = rf(ctx, ids, filters)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]Subnet)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string, EC2Filters) error); ok {
+		r1 = rf(ctx, ids, filters)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -119,13 +189,13 @@ func (_m *MockEC2ClientIface) DescribeSpotPrices(vs []InstanceVariety) (map[Inst
 	return r0, r1
 }
 
-// DescribeWorkingInstances provides a mock function with given fields:
-func (_m *MockEC2ClientIface) DescribeWorkingInstances() (Instances, error) {
-	ret := _m.Called()
+// DescribeWorkingInstances provides a mock function with given fields: ctx
+func (_m *MockEC2ClientIface) DescribeWorkingInstances(ctx context.Context) (Instances, error) {
+	ret := _m.Called(ctx)
 
 	var r0 Instances
-	if rf, ok := ret.Get(0).(func() Instances); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) Instances); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(Instances)
@@ -133,8 +203,8 @@ func (_m *MockEC2ClientIface) DescribeWorkingInstances() (Instances, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -142,13 +212,13 @@ func (_m *MockEC2ClientIface) DescribeWorkingInstances() (Instances, error) {
 	return r0, r1
 }
 
-// LaunchSpotInstances provides a mock function with given fields: v, c, ami
-func (_m *MockEC2ClientIface) LaunchSpotInstances(v InstanceVariety, c int64, ami string) error {
-	ret := _m.Called(v, c, ami)
+// LaunchSpotInstances provides a mock function with given fields: ctx, v, c, ami
+func (_m *MockEC2ClientIface) LaunchSpotInstances(ctx context.Context, v InstanceVariety, c int64, ami string) error {
+	ret := _m.Called(ctx, v, c, ami)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(InstanceVariety, int64, string) error); ok {
-		r0 = rf(v, c, ami)
+	if rf, ok := ret.Get(0).(func(context.Context, InstanceVariety, int64, string) error); ok {
+		r0 = rf(ctx, v, c, ami)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -156,13 +226,13 @@ func (_m *MockEC2ClientIface) LaunchSpotInstances(v InstanceVariety, c int64, am
 	return r0
 }
 
-// PropagateTagsFromSIRsToInstances provides a mock function with given fields: reqs
-func (_m *MockEC2ClientIface) PropagateTagsFromSIRsToInstances(reqs []*ec2.SpotInstanceRequest) error {
-	ret := _m.Called(reqs)
+// PropagateTagsFromSIRsToInstances provides a mock function with given fields: ctx, reqs
+func (_m *MockEC2ClientIface) PropagateTagsFromSIRsToInstances(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error {
+	ret := _m.Called(ctx, reqs)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func([]*ec2.SpotInstanceRequest) error); ok {
-		r0 = rf(reqs)
+	if rf, ok := ret.Get(0).(func(context.Context, []*ec2.SpotInstanceRequest) error); ok {
+		r0 = rf(ctx, reqs)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -170,13 +240,13 @@ func (_m *MockEC2ClientIface) PropagateTagsFromSIRsToInstances(reqs []*ec2.SpotI
 	return r0
 }
 
-// TerminateInstances provides a mock function with given fields: instances
-func (_m *MockEC2ClientIface) TerminateInstances(instances Instances) error {
-	ret := _m.Called(instances)
+// TerminateInstances provides a mock function with given fields: ctx, instances
+func (_m *MockEC2ClientIface) TerminateInstances(ctx context.Context, instances Instances) error {
+	ret := _m.Called(ctx, instances)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(Instances) error); ok {
-		r0 = rf(instances)
+	if rf, ok := ret.Get(0).(func(context.Context, Instances) error); ok {
+		r0 = rf(ctx, instances)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -184,13 +254,13 @@ func (_m *MockEC2ClientIface) TerminateInstances(instances Instances) error {
 	return r0
 }
 
-// TerminateInstancesByCount provides a mock function with given fields: instances, v, count
-func (_m *MockEC2ClientIface) TerminateInstancesByCount(instances Instances, v InstanceVariety, count int64) error {
-	ret := _m.Called(instances, v, count)
+// TerminateInstancesByCount provides a mock function with given fields: ctx, instances, v, count
+func (_m *MockEC2ClientIface) TerminateInstancesByCount(ctx context.Context, instances Instances, v InstanceVariety, count int64) error {
+	ret := _m.Called(ctx, instances, v, count)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(Instances, InstanceVariety, int64) error); ok {
-		r0 = rf(instances, v, count)
+	if rf, ok := ret.Get(0).(func(context.Context, Instances, InstanceVariety, int64) error); ok {
+		r0 = rf(ctx, instances, v, count)
 	} else {
 		r0 = ret.Error(0)
 	}
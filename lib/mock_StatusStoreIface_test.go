@@ -57,6 +57,163 @@ func (_m *MockStatusStoreIface) FetchCooldownEndsAt() (time.Time, error) {
 	return r0, r1
 }
 
+// FetchPinnedAMI provides a mock function with given fields: key
+func (_m *MockStatusStoreIface) FetchPinnedAMI(key string) (string, error) {
+	ret := _m.Called(key)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(key)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FetchRebalanceStatus provides a mock function with given fields:
+func (_m *MockStatusStoreIface) FetchRebalanceStatus() (*RebalanceStatus, error) {
+	ret := _m.Called()
+
+	var r0 *RebalanceStatus
+	if rf, ok := ret.Get(0).(func() *RebalanceStatus); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*RebalanceStatus)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FetchRefreshPaused provides a mock function with given fields:
+func (_m *MockStatusStoreIface) FetchRefreshPaused() (bool, error) {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FetchRefreshStatus provides a mock function with given fields:
+func (_m *MockStatusStoreIface) FetchRefreshStatus() (*RefreshStatus, error) {
+	ret := _m.Called()
+
+	var r0 *RefreshStatus
+	if rf, ok := ret.Get(0).(func() *RefreshStatus); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*RefreshStatus)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FetchRotationStatus provides a mock function with given fields:
+func (_m *MockStatusStoreIface) FetchRotationStatus() (*RotationStatus, error) {
+	ret := _m.Called()
+
+	var r0 *RotationStatus
+	if rf, ok := ret.Get(0).(func() *RotationStatus); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*RotationStatus)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FetchScalingOverride provides a mock function with given fields:
+func (_m *MockStatusStoreIface) FetchScalingOverride() (*ScalingOverride, error) {
+	ret := _m.Called()
+
+	var r0 *ScalingOverride
+	if rf, ok := ret.Get(0).(func() *ScalingOverride); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*ScalingOverride)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FetchScalingStatus provides a mock function with given fields:
+func (_m *MockStatusStoreIface) FetchScalingStatus() (*ScalingStatus, error) {
+	ret := _m.Called()
+
+	var r0 *ScalingStatus
+	if rf, ok := ret.Get(0).(func() *ScalingStatus); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*ScalingStatus)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetExpiredTimers provides a mock function with given fields:
 func (_m *MockStatusStoreIface) GetExpiredTimers() ([]string, error) {
 	ret := _m.Called()
@@ -168,6 +325,104 @@ func (_m *MockStatusStoreIface) StoreMetric(values map[string]float64) error {
 	return r0
 }
 
+// StorePinnedAMI provides a mock function with given fields: key, ami
+func (_m *MockStatusStoreIface) StorePinnedAMI(key string, ami string) error {
+	ret := _m.Called(key, ami)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(key, ami)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// StoreRebalanceStatus provides a mock function with given fields: st
+func (_m *MockStatusStoreIface) StoreRebalanceStatus(st *RebalanceStatus) error {
+	ret := _m.Called(st)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*RebalanceStatus) error); ok {
+		r0 = rf(st)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// StoreRefreshPaused provides a mock function with given fields: paused
+func (_m *MockStatusStoreIface) StoreRefreshPaused(paused bool) error {
+	ret := _m.Called(paused)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(bool) error); ok {
+		r0 = rf(paused)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// StoreRefreshStatus provides a mock function with given fields: st
+func (_m *MockStatusStoreIface) StoreRefreshStatus(st *RefreshStatus) error {
+	ret := _m.Called(st)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*RefreshStatus) error); ok {
+		r0 = rf(st)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// StoreRotationStatus provides a mock function with given fields: st
+func (_m *MockStatusStoreIface) StoreRotationStatus(st *RotationStatus) error {
+	ret := _m.Called(st)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*RotationStatus) error); ok {
+		r0 = rf(st)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// StoreScalingOverride provides a mock function with given fields: o
+func (_m *MockStatusStoreIface) StoreScalingOverride(o *ScalingOverride) error {
+	ret := _m.Called(o)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*ScalingOverride) error); ok {
+		r0 = rf(o)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// StoreScalingStatus provides a mock function with given fields: st
+func (_m *MockStatusStoreIface) StoreScalingStatus(st *ScalingStatus) error {
+	ret := _m.Called(st)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*ScalingStatus) error); ok {
+		r0 = rf(st)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateTimer provides a mock function with given fields: key, t
 func (_m *MockStatusStoreIface) UpdateTimer(key string, t time.Time) error {
 	ret := _m.Called(key, t)
@@ -0,0 +1,155 @@
+package autoscaler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// ReplayResult compares a recorded decision with the replayed one
+type ReplayResult struct {
+	Time     time.Time
+	Skipped  bool
+	Recorded string
+	Replayed string
+}
+
+func (r *ReplayResult) Differs() bool {
+	return !r.Skipped && r.Recorded != r.Replayed
+}
+
+// LoadRunRecords loads a JSONL file written by RunRecorder
+func LoadRunRecords(path string) ([]*RunRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []*RunRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := &RunRecord{}
+		err := json.Unmarshal(scanner.Bytes(), record)
+		if err != nil {
+			return nil, fmt.Errorf("parsing line %d of %s failed: %s", n, path, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// Replay runs scaling with recorded inputs and compares decisions
+func Replay(ctx context.Context, config *Config, records []*RunRecord) ([]*ReplayResult, error) {
+	c := simulationConfig(config)
+
+	results := []*ReplayResult{}
+	for _, record := range records {
+		result := &ReplayResult{Time: record.Time, Recorded: record.Step().Decision()}
+		if !record.Complete() {
+			logf(ctx, "[WARN] record at %s lacks inputs and is skipped", record.Time)
+			result.Skipped = true
+			results = append(results, result)
+			continue
+		}
+
+		step, err := replayRecord(ctx, c, record)
+		if err != nil {
+			return nil, err
+		}
+		result.Replayed = step.Decision()
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func replayRecord(ctx context.Context, config *Config, record *RunRecord) (*SimulationStep, error) {
+	clock := func() time.Time { return record.Time }
+
+	prices := map[InstanceVariety]float64{}
+	for _, p := range record.SpotPrices {
+		prices[p.Variety] = p.Price
+	}
+	ec2Client := &replayEC2Client{
+		SimulatedEC2Client: NewSimulatedEC2Client(config, clock, record.WorkingInstances, nil),
+		prices:             prices,
+	}
+
+	status := NewMemoryStatusStore(clock)
+	err := status.StoreScalingStatus(record.ScalingStatus)
+	if err != nil {
+		return nil, err
+	}
+	if record.ScalingOverride != nil {
+		err := status.StoreScalingOverride(record.ScalingOverride)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, sch := range record.Schedules {
+		err := status.AddSchedules(sch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cpuUtil := *record.CPUUtil
+	runner, err := newSimulationRunner(config, ec2Client, status, record.Subnets, clock, func(ctx context.Context) (float64, error) {
+		return cpuUtil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	step := &SimulationStep{
+		Time:       record.Time,
+		CPUUtil:    cpuUtil,
+		InCooldown: record.ScalingStatus.InCooldown(record.Time),
+	}
+	err = runner.scale(ctx)
+	if err != nil {
+		step.Error = err.Error()
+	}
+	step.Changes = ec2Client.Changes
+
+	return step, nil
+}
+
+// replayEC2Client returns recorded spot prices by variety
+type replayEC2Client struct {
+	*SimulatedEC2Client
+	prices map[InstanceVariety]float64
+}
+
+func (c *replayEC2Client) DescribeSpotPrices(ctx context.Context, vs []InstanceVariety) (map[InstanceVariety]float64, error) {
+	return c.prices, nil
+}
+
+// PrintReplayResults writes results as a table and returns the number of differences
+func PrintReplayResults(w io.Writer, results []*ReplayResult) (int, error) {
+	differences := 0
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tRESULT\tRECORDED\tREPLAYED")
+	for _, r := range results {
+		mark := "same"
+		if r.Skipped {
+			mark = "skipped"
+		} else if r.Differs() {
+			mark = "DIFF"
+			differences++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Time.Format(time.RFC3339), mark, r.Recorded, r.Replayed)
+	}
+	return differences, tw.Flush()
+}
@@ -0,0 +1,64 @@
+package autoscaler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spotscaler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "records.jsonl")
+
+	config := simulationConfig(configForTest("70"))
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	instances := Instances{
+		spotInstanceForTest("i-1", "c4.large", "ami-abc"),
+		spotInstanceForTest("i-2", "m4.large", "ami-abc"),
+	}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, nil)
+	assert.NoError(t, err)
+	r.cpuUtilSource = r.cpuUtilFromCommand
+	err = r.EnableRecording(path)
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		r.recorder.Start(now, r.instanceVarieties())
+		err = r.recorder.Finish(r.scale(context.Background()))
+		assert.NoError(t, err)
+		now = now.Add(time.Minute)
+	}
+
+	records, err := LoadRunRecords(path)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.True(t, records[0].Complete())
+	assert.Equal(t, 70.0, *records[0].CPUUtil)
+	assert.NotEmpty(t, records[0].Changes)
+	assert.True(t, records[1].Step().InCooldown)
+
+	results, err := Replay(context.Background(), configForTest("70"), records)
+	assert.NoError(t, err)
+	for _, result := range results {
+		assert.False(t, result.Differs(), "%#v", result)
+	}
+
+	changed := configForTest("70")
+	changed.MaxCPUUtil = 50
+	results, err = Replay(context.Background(), changed, records)
+	assert.NoError(t, err)
+	assert.True(t, results[0].Differs())
+	assert.False(t, results[1].Differs())
+}
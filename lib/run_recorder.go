@@ -0,0 +1,240 @@
+package autoscaler
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RunRecord represents inputs and the decision of scaling in a loop.
+// Inputs which were not fetched (e.g. due to an error) are nil.
+type RunRecord struct {
+	Time             time.Time
+	Subnets          []Subnet
+	WorkingInstances Instances
+	SpotPrices       []RecordedSpotPrice
+	CPUUtil          *float64
+	Schedules        []*Schedule
+	ScalingStatus    *ScalingStatus
+	ScalingOverride  *ScalingOverride
+	Changes          []RecordedChange
+	Error            string
+}
+
+type RecordedSpotPrice struct {
+	Variety InstanceVariety
+	Price   float64
+}
+
+type RecordedChange struct {
+	Variety InstanceVariety
+	Count   int64
+}
+
+// Complete returns true if inputs required to replay the record are present
+func (r *RunRecord) Complete() bool {
+	return r.WorkingInstances != nil && r.SpotPrices != nil && r.CPUUtil != nil && r.ScalingStatus != nil
+}
+
+// Step returns the recorded decision
+func (r *RunRecord) Step() *SimulationStep {
+	step := &SimulationStep{
+		Time:    r.Time,
+		Error:   r.Error,
+		Changes: map[InstanceVariety]int64{},
+	}
+	if r.CPUUtil != nil {
+		step.CPUUtil = *r.CPUUtil
+	}
+	if r.ScalingStatus != nil {
+		step.InCooldown = r.ScalingStatus.InCooldown(r.Time)
+	}
+	for _, c := range r.Changes {
+		step.Changes[c.Variety] = c.Count
+	}
+	return step
+}
+
+// RunRecorder writes inputs of scaling in each loop to a JSONL file.
+// Only the first value of each input in a loop is recorded.
+type RunRecorder struct {
+	mutex  sync.Mutex
+	file   *os.File
+	record *RunRecord
+}
+
+func NewRunRecorder(path string) (*RunRecorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &RunRecorder{file: f}, nil
+}
+
+func (r *RunRecorder) Start(t time.Time, varieties []InstanceVariety) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	subnets := []Subnet{}
+	for _, v := range varieties {
+		subnets = appendSubnetIfMissing(subnets, v.Subnet)
+	}
+	r.record = &RunRecord{Time: t, Subnets: subnets}
+}
+
+// Finish writes the current record with the result of scaling
+func (r *RunRecorder) Finish(scaleErr error) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.record == nil {
+		return nil
+	}
+	record := r.record
+	r.record = nil
+
+	if scaleErr != nil {
+		record.Error = scaleErr.Error()
+	}
+
+	j, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Write(append(j, '\n'))
+	return err
+}
+
+func (r *RunRecorder) Close() error {
+	return r.file.Close()
+}
+
+func (r *RunRecorder) capture(f func(record *RunRecord)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.record != nil {
+		f(r.record)
+	}
+}
+
+// EnableRecording starts recording inputs of scaling to a JSONL file at path
+func (r *Runner) EnableRecording(path string) error {
+	recorder, err := NewRunRecorder(path)
+	if err != nil {
+		return err
+	}
+
+	cpuUtilSource := r.cpuUtilSource
+	if cpuUtilSource == nil {
+		cpuUtilSource = r.cpuUtilFromCommand
+	}
+
+	r.recorder = recorder
+	r.ec2Client = &recordingEC2Client{EC2ClientIface: r.ec2Client, recorder: recorder}
+	r.status = &recordingStatusStore{StatusStoreIface: r.status, recorder: recorder}
+	r.cpuUtilSource = func(ctx context.Context) (float64, error) {
+		u, err := cpuUtilSource(ctx)
+		if err == nil {
+			recorder.capture(func(record *RunRecord) {
+				if record.CPUUtil == nil {
+					record.CPUUtil = &u
+				}
+			})
+		}
+		return u, err
+	}
+
+	return nil
+}
+
+type recordingEC2Client struct {
+	EC2ClientIface
+	recorder *RunRecorder
+}
+
+func (c *recordingEC2Client) DescribeWorkingInstances(ctx context.Context) (Instances, error) {
+	instances, err := c.EC2ClientIface.DescribeWorkingInstances(ctx)
+	if err == nil {
+		c.recorder.capture(func(record *RunRecord) {
+			if record.WorkingInstances == nil {
+				record.WorkingInstances = instances
+			}
+		})
+	}
+	return instances, err
+}
+
+func (c *recordingEC2Client) DescribeSpotPrices(ctx context.Context, vs []InstanceVariety) (map[InstanceVariety]float64, error) {
+	prices, err := c.EC2ClientIface.DescribeSpotPrices(ctx, vs)
+	if err == nil {
+		c.recorder.capture(func(record *RunRecord) {
+			if record.SpotPrices != nil {
+				return
+			}
+			record.SpotPrices = []RecordedSpotPrice{}
+			for v, p := range prices {
+				record.SpotPrices = append(record.SpotPrices, RecordedSpotPrice{Variety: v, Price: p})
+			}
+			sort.Slice(record.SpotPrices, func(i, j int) bool {
+				return lessInstanceVariety(record.SpotPrices[i].Variety, record.SpotPrices[j].Variety)
+			})
+		})
+	}
+	return prices, err
+}
+
+func (c *recordingEC2Client) ChangeInstances(ctx context.Context, change map[InstanceVariety]int64, amis VarietyAMIs, terminationTarget Instances) error {
+	c.recorder.capture(func(record *RunRecord) {
+		for v, count := range change {
+			record.Changes = append(record.Changes, RecordedChange{Variety: v, Count: count})
+		}
+	})
+	return c.EC2ClientIface.ChangeInstances(ctx, change, amis, terminationTarget)
+}
+
+type recordingStatusStore struct {
+	StatusStoreIface
+	recorder *RunRecorder
+}
+
+func (s *recordingStatusStore) ListSchedules() ([]*Schedule, error) {
+	schedules, err := s.StatusStoreIface.ListSchedules()
+	if err == nil {
+		s.recorder.capture(func(record *RunRecord) {
+			if record.Schedules == nil {
+				record.Schedules = schedules
+			}
+		})
+	}
+	return schedules, err
+}
+
+func (s *recordingStatusStore) FetchScalingStatus() (*ScalingStatus, error) {
+	st, err := s.StatusStoreIface.FetchScalingStatus()
+	if err == nil {
+		s.recorder.capture(func(record *RunRecord) {
+			if record.ScalingStatus == nil {
+				c := *st
+				record.ScalingStatus = &c
+			}
+		})
+	}
+	return st, err
+}
+
+func (s *recordingStatusStore) FetchScalingOverride() (*ScalingOverride, error) {
+	o, err := s.StatusStoreIface.FetchScalingOverride()
+	if err == nil {
+		s.recorder.capture(func(record *RunRecord) {
+			if record.ScalingOverride == nil {
+				c := *o
+				record.ScalingOverride = &c
+			}
+		})
+	}
+	return o, err
+}
@@ -2,11 +2,13 @@ package autoscaler
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"math"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"syscall"
@@ -18,11 +20,27 @@ import (
 )
 
 type Runner struct {
-	config     *Config
-	status     StatusStoreIface
-	awsSession *session.Session
-	ec2Client  EC2ClientIface
-	api        *APIServer
+	config       *Config
+	status       StatusStoreIface
+	awsSession   *session.Session
+	ec2Client    EC2ClientIface
+	api          *APIServer
+	amiResolvers map[string]AMIResolver
+
+	subnets           []Subnet
+	subnetsResolvedAt time.Time
+
+	// clock and cpuUtilSource replace the wall clock and CPUUtilCommand (e.g. in simulation)
+	clock         func() time.Time
+	cpuUtilSource func(ctx context.Context) (float64, error)
+
+	recorder *RunRecorder
+
+	// loadConfig loads config again on SIGHUP
+	loadConfig func() (*Config, error)
+
+	// recoverPanic is set for groups so that a panic in a group does not stop the others
+	recoverPanic bool
 }
 
 func NewRunner(config *Config) (*Runner, error) {
@@ -31,120 +49,218 @@ func NewRunner(config *Config) (*Runner, error) {
 		return nil, err
 	}
 
-	status := NewStatusStore(config.RedisHost, config.FullAutoscalerID())
+	status := NewStatusStore(config.RedisHost, config.RedisPassword, config.FullAutoscalerID())
+	return newRunnerWithSession(config, awsSess, status)
+}
+
+func newRunnerWithSession(config *Config, awsSess *session.Session, status StatusStoreIface) (*Runner, error) {
+	ec2Client := NewEC2Client(ec2.New(awsSess), config)
+
+	amiResolvers, err := NewAMIResolvers(config, ec2Client, status)
+	if err != nil {
+		return nil, err
+	}
 
 	runner := &Runner{
-		config:     config,
-		status:     status,
-		awsSession: awsSess,
-		ec2Client:  NewEC2Client(ec2.New(awsSess), config),
-		api:        NewAPIServer(status),
+		config:       config,
+		status:       status,
+		awsSession:   awsSess,
+		ec2Client:    ec2Client,
+		api:          NewAPIServer(status),
+		amiResolvers: amiResolvers,
 	}
 
 	return runner, nil
 }
 
-func (r *Runner) StartLoop() error {
+// StartLoop runs loops until ctx is canceled. A loop in progress stops at the next EC2 API call
+// or command, but a launch of instances in progress completes.
+func (r *Runner) StartLoop(ctx context.Context) error {
 	if r.config.APIAddr != "" {
 		r.api.Run(r.config.APIAddr)
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := r.api.Shutdown(shutdownCtx)
+			if err != nil {
+				logf(ctx, "[ERROR] error in shutting down API server: %s", err)
+			}
+		}()
 	}
 
-	SetCapacityTable(r.config.InstanceCapacityByType)
+	return r.loop(ctx)
+}
 
+func (r *Runner) loop(ctx context.Context) error {
 	loopInterval, err := time.ParseDuration(r.config.LoopInterval)
 	if err != nil {
 		return err
 	}
 
-	for {
-		c := time.After(loopInterval)
-
-		sigchan := make(chan os.Signal, 1)
-		signal.Notify(sigchan, syscall.SIGTERM)
+	hupchan := make(chan os.Signal, 1)
+	signal.Notify(hupchan, syscall.SIGHUP)
+	defer signal.Stop(hupchan)
 
-		if err != nil {
-			log.Println("[ERROR] error in loop:", err)
-		} else {
-			err := r.Run()
-			if err != nil {
-				log.Println("[ERROR] error in loop:", err)
+	for {
+		err := r.withPanicRecovery(func() error {
+			// config is replaced only between loops
+			select {
+			case <-hupchan:
+				err := r.reloadConfig(ctx)
+				if err != nil {
+					logf(ctx, "[ERROR] error in reloading config: %s", err)
+				}
+				// LoopInterval of a reloaded config is validated
+				if d, err := time.ParseDuration(r.config.LoopInterval); err == nil {
+					loopInterval = d
+				}
+			default:
 			}
+
+			return r.Run(ctx)
+		})
+		if err != nil && ctx.Err() == nil {
+			logf(ctx, "[ERROR] error in loop: %s", err)
 		}
 
 		select {
-		case <-sigchan:
-			log.Printf("[INFO] shutting down...")
+		case <-ctx.Done():
+			logf(ctx, "[INFO] shutting down...")
 			return nil
 		default:
-			signal.Stop(sigchan)
-			log.Println("[INFO] waiting for next run")
-			<-c
+			logf(ctx, "[INFO] waiting for next run")
+		}
+
+		select {
+		case <-ctx.Done():
+			logf(ctx, "[INFO] shutting down...")
+			return nil
+		case <-time.After(loopInterval):
 		}
 	}
+}
+
+// withPanicRecovery calls f and returns a panic in f as an error if the runner is one of groups
+func (r *Runner) withPanicRecovery(f func() error) (err error) {
+	if !r.recoverPanic {
+		return f()
+	}
 
-	return nil
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic in %s: %v\n%s", r.config.AutoscalerID, p, debug.Stack())
+		}
+	}()
+	return f()
+}
+
+// RunOnce runs a loop once
+func (r *Runner) RunOnce(ctx context.Context) error {
+	return r.withPanicRecovery(func() error {
+		return r.Run(ctx)
+	})
 }
 
-func (r *Runner) Run() error {
+func (r *Runner) Run(ctx context.Context) error {
 	var err error
 
-	log.Println("[DEBUG] START Runner.Run")
+	ctx, _ = withLogRun(ctx)
+	logWithFields(ctx, "DEBUG", "START Runner.Run", LogFields{"autoscaler_id": r.config.AutoscalerID})
+	if err != nil {
+		return err
+	}
+
+	err = r.removeExpiredSchedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = r.resolveSubnets(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = r.runExpiredTimers(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = r.propagateSIRTagsToInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = r.cancelDeadSIRs(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = r.removeExpiredSchedules()
+	if r.recorder != nil {
+		r.recorder.Start(r.now(), r.instanceVarieties())
+	}
+	err = r.scale(ctx)
+	if r.recorder != nil {
+		rerr := r.recorder.Finish(err)
+		if rerr != nil {
+			logf(ctx, "[ERROR] recording inputs failed: %s", rerr)
+		}
+	}
 	if err != nil {
 		return err
 	}
 
-	err = r.runExpiredTimers()
+	override, err := r.status.FetchScalingOverride()
 	if err != nil {
 		return err
 	}
+	if override.Paused {
+		logf(ctx, "[INFO] skip refresh, rotation and rebalance while scaling is paused via API")
+		logf(ctx, "[DEBUG] END Runner.Run")
+		return nil
+	}
 
-	err = r.propagateSIRTagsToInstances()
+	err = r.refreshInstances(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = r.cancelDeadSIRs()
+	err = r.rotateInstances(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = r.scale()
+	err = r.rebalanceInstances(ctx)
 	if err != nil {
 		return err
 	}
 
-	log.Println("[DEBUG] END Runner.Run")
+	logf(ctx, "[DEBUG] END Runner.Run")
 	return nil
 }
 
-func (r *Runner) propagateSIRTagsToInstances() error {
-	log.Println("[DEBUG] START: propagateSIRTagsToInstances")
+func (r *Runner) propagateSIRTagsToInstances(ctx context.Context) error {
+	logf(ctx, "[DEBUG] START: propagateSIRTagsToInstances")
 	// find active and status:pending SIRs
-	pendingSIRs, err := r.ec2Client.DescribePendingAndActiveSIRs()
+	pendingSIRs, err := r.ec2Client.DescribePendingAndActiveSIRs(ctx)
 	if err != nil {
 		return err
 	}
 
 	if len(pendingSIRs) == 0 {
-		log.Println("[INFO] no active and pending spot instance requests")
+		logf(ctx, "[INFO] no active and pending spot instance requests")
 		return nil
 	}
 
-	log.Println("[INFO] propagating tags from spot instance requests")
+	logf(ctx, "[INFO] propagating tags from spot instance requests")
 
 	// propagate tags
-	err = r.ec2Client.PropagateTagsFromSIRsToInstances(pendingSIRs)
+	err = r.ec2Client.PropagateTagsFromSIRsToInstances(ctx, pendingSIRs)
 	if err != nil {
 		return err
 	}
 
 	// status:completed tag to SIR
-	err = r.ec2Client.CreateStatusTagsOfSIRs(pendingSIRs, "completed")
+	err = r.ec2Client.CreateStatusTagsOfSIRs(ctx, pendingSIRs, "completed")
 	if err != nil {
 		return err
 	}
@@ -152,15 +268,15 @@ func (r *Runner) propagateSIRTagsToInstances() error {
 	return nil
 }
 
-func (r *Runner) cancelDeadSIRs() error {
-	log.Println("[DEBUG] START: cancelDeadSIRs")
+func (r *Runner) cancelDeadSIRs(ctx context.Context) error {
+	logf(ctx, "[DEBUG] START: cancelDeadSIRs")
 
-	sirs, err := r.ec2Client.DescribeDeadSIRs()
+	sirs, err := r.ec2Client.DescribeDeadSIRs(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = r.ec2Client.CancelOpenSIRs(sirs)
+	err = r.ec2Client.CancelOpenSIRs(ctx, sirs)
 	if err != nil {
 		return err
 	}
@@ -168,62 +284,80 @@ func (r *Runner) cancelDeadSIRs() error {
 	return nil
 }
 
-func (r *Runner) scale() error {
-	log.Println("[DEBUG] START: scale")
-
-	workingInstances, err := r.ec2Client.DescribeWorkingInstances()
+func (r *Runner) scale(ctx context.Context) error {
+	plan, err := r.planScale(ctx)
 	if err != nil {
 		return err
 	}
 
-	ondemandCapacity, err := workingInstances.Ondemand().Capacity()
+	// the decision is published with the result of applying it
+	err = r.applyScalePlan(ctx, plan)
 	if err != nil {
-		return err
+		plan.Decision.Result = fmt.Sprintf("failed: %s", err)
 	}
-	log.Printf("[DEBUG] ondemand capacity: %f", ondemandCapacity.Total())
+	r.api.UpdateDecision(plan.Decision)
+
+	return err
+}
 
-	spotCapacity, err := workingInstances.Spot().Capacity()
+// planScale computes changes of spot instances which scaling makes now
+func (r *Runner) planScale(ctx context.Context) (*ScalePlan, error) {
+	logf(ctx, "[DEBUG] START: scale")
+
+	workingInstances, err := r.ec2Client.DescribeWorkingInstances(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Printf("[DEBUG] spot capacity: %f", spotCapacity.Total())
+	plan := newScalePlan(r.now(), workingInstances)
+	decision := plan.Decision
 
-	price, err := r.ec2Client.DescribeSpotPrices(r.config.InstanceVarieties())
+	ondemandCapacity, err := workingInstances.Ondemand().Capacity(r.config.CapacityTable())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Printf("[DEBUG] current spot price: %v", price)
+	logf(ctx, "[DEBUG] ondemand capacity: %f", ondemandCapacity.Total())
 
-	availableVarieties := []InstanceVariety{}
-	for v, p := range price {
-		bid, ok := r.config.BiddingPriceByType[v.InstanceType]
-		if !ok {
-			return fmt.Errorf("Bidding price for %s is unknown", v.InstanceType)
-		}
+	spotCapacity, err := workingInstances.Spot().Capacity(r.config.CapacityTable())
+	if err != nil {
+		return nil, err
+	}
+	logf(ctx, "[DEBUG] spot capacity: %f", spotCapacity.Total())
 
-		if p <= bid {
-			availableVarieties = append(availableVarieties, v)
-		} else {
-			log.Printf("[DEBUG] %v is not available due to price (%f USD)", v, p)
-		}
+	price, err := r.ec2Client.DescribeSpotPrices(ctx, r.instanceVarieties())
+	if err != nil {
+		return nil, err
+	}
+	logf(ctx, "[DEBUG] current spot price: %v", price)
+
+	availableVarieties, err := r.availableVarieties(ctx, price)
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("[DEBUG] %d spot varieties are available", len(availableVarieties))
 
-	worstTotalSpotCapacity := spotCapacity.TotalInWorstCase(r.config.MaxTerminatedVarieties)
-	log.Printf("[DEBUG] in worst case, spot capacity change from %f to %f", spotCapacity.Total(), worstTotalSpotCapacity)
+	failureModel := r.config.FailureModel()
+	worstTotalSpotCapacity := spotCapacity.TotalOnFailure(failureModel)
+	logf(ctx, "[DEBUG] in worst case, spot capacity change from %f to %f", spotCapacity.Total(), worstTotalSpotCapacity)
 
 	cpuUtilToScaleOut := r.config.MaxCPUUtil *
 		(ondemandCapacity.Total() + worstTotalSpotCapacity) /
 		(ondemandCapacity.Total() + spotCapacity.Total())
 	cpuUtilToScaleIn := cpuUtilToScaleOut - r.config.ScaleInThreshold
-	log.Printf("[DEBUG] cpu util to scale out: %f, cpu util to scale in: %f", cpuUtilToScaleOut, cpuUtilToScaleIn)
+	logf(ctx, "[DEBUG] cpu util to scale out: %f, cpu util to scale in: %f", cpuUtilToScaleOut, cpuUtilToScaleIn)
 
-	cpuUtil, err := r.getCPUUtil()
+	cpuUtil, err := r.getCPUUtil(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Printf("[DEBUG] CPU util: %f", cpuUtil)
+	logf(ctx, "[DEBUG] CPU util: %f", cpuUtil)
+	decision.CPUUtil = cpuUtil
+	decision.OndemandCapacity = ondemandCapacity.Total()
+	decision.SpotCapacity = spotCapacity.Total()
+	decision.SpotCapacityInWorstCase = worstTotalSpotCapacity
+	decision.AvailableVarieties = len(availableVarieties)
+	decision.FailureModel = failureModel
+	decision.CPUUtilToScaleOut = cpuUtilToScaleOut
+	decision.CPUUtilToScaleIn = cpuUtilToScaleIn
 
 	r.api.UpdateMetrics(map[string]float64{
 		"ondemand_capacity":           ondemandCapacity.Total(),
@@ -236,118 +370,217 @@ func (r *Runner) scale() error {
 		"cpu_util":                    cpuUtil,
 	})
 
-	cooldownEndsAt, err := r.status.FetchCooldownEndsAt()
+	ondemandCost, err := r.updateCostMetrics(ctx, workingInstances, price)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if time.Now().Before(cooldownEndsAt) {
-		log.Printf("[INFO] skip scaling in cooldown (it ends at %s)", cooldownEndsAt)
-		return nil
+	scalingStatus, err := r.status.FetchScalingStatus()
+	if err != nil {
+		return nil, err
 	}
 
-	if len(availableVarieties)-r.config.MaxTerminatedVarieties < 1 {
-		log.Printf("[ERROR] available varieties are too few against acceptable termination (%d)", r.config.MaxTerminatedVarieties)
-	}
+	removalBudget := r.config.RemovalBudget(scalingStatus, r.now())
+	r.api.UpdateRemovalBudget(removalBudget)
 
-	schedule, err := r.getCurrentSchedule()
+	override, err := r.status.FetchScalingOverride()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	decision.Override = override
 
-	if schedule != nil {
-		log.Printf("[INFO] schedule is found: %q", schedule)
+	// the allocation is computed in pause and cooldown as well, and changes are blocked below
+	scaleOutInCooldown := r.now().Before(scalingStatus.ScaleOutCooldownEndsAt)
+	scaleInInCooldown := r.now().Before(scalingStatus.ScaleInCooldownEndsAt)
+	if override.Paused {
+		logf(ctx, "[INFO] scaling is paused via API")
+		decision.Reason = "paused"
+	} else if scaleOutInCooldown && scaleInInCooldown {
+		logf(ctx, "[INFO] scaling in cooldown (scaling out ends at %s, scaling in ends at %s)", scalingStatus.ScaleOutCooldownEndsAt, scalingStatus.ScaleInCooldownEndsAt)
+		decision.Reason = "cooldown"
 	}
 
-	var desiredCapacity InstanceCapacity
-	if cpuUtil <= cpuUtilToScaleIn {
-		log.Println("[DEBUG] scaling in")
-	} else if cpuUtilToScaleOut <= cpuUtil {
-		log.Println("[DEBUG] scaling out")
-	} else if schedule == nil {
-		log.Println("[DEBUG] skip both scaling in and scaling out")
-		return nil
+	if domains := failureModel.Domains(availableVarieties); len(domains)-failureModel.MaxFailedDomains < 1 {
+		logf(ctx, "[ERROR] failure domains of available varieties are too few against acceptable failure (%s)", failureModel)
 	}
 
-	desiredCapacity, err = DesiredCapacityFromTargetCPUUtil(
-		availableVarieties,
-		cpuUtil,
-		r.config.MaxCPUUtil,
-		r.config.ScaleInThreshold/2.0,
-		ondemandCapacity.Total(),
-		spotCapacity.Total(),
-		r.config.MaxTerminatedVarieties,
-	)
+	schedule, err := r.getCurrentSchedule()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if schedule != nil {
-		log.Println("[INFO] schedule found:", schedule)
+		logf(ctx, "[INFO] schedule is found: %v", schedule)
+	}
+	decision.Schedule = schedule
+
+	desiredCapacity, err := NewScalingPolicy(r.config).DesiredCapacity(ctx, &ScalingState{
+		AvailableVarieties: availableVarieties,
+		Capacities:         r.config.CapacityTable(),
+		CPUUtil:            cpuUtil,
+		OndemandCapacity:   ondemandCapacity.Total(),
+		SpotCapacity:       spotCapacity,
+		FailureModel:       failureModel,
+		Schedule:           schedule,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	decision.PolicyCapacity = varietyCapacities(desiredCapacity)
+
+	overrideCapacity := override.ActiveCapacity(r.now())
+	if desiredCapacity == nil && schedule == nil && overrideCapacity == nil {
+		if decision.Reason == "" {
+			decision.Reason = "no scaling required"
+		}
+		return plan, nil
+	}
+
+	if overrideCapacity != nil {
+		// the override replaces both the scaling policy and the schedule
+		logf(ctx, "[INFO] capacity is overridden via API: %f until %s", *overrideCapacity, override.CapacityExpiresAt)
+		dc, err := DesiredCapacityFromTotal(
+			availableVarieties,
+			r.config.CapacityTable(),
+			*overrideCapacity-ondemandCapacity.Total(),
+			failureModel,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		logf(ctx, "[DEBUG] capacity calculated from override: %v", dc)
+		decision.OverrideCapacity = varietyCapacities(dc)
+		desiredCapacity = dc
+	} else if schedule != nil {
+		logf(ctx, "[INFO] schedule found: %v", schedule)
 		dc, err := DesiredCapacityFromTotal(
 			availableVarieties,
+			r.config.CapacityTable(),
 			schedule.Capacity-ondemandCapacity.Total(),
-			r.config.MaxTerminatedVarieties,
+			failureModel,
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		log.Printf("[DEBUG] capacity calculated from CPU util: %v", desiredCapacity)
-		log.Printf("[DEBUG] capacity calculated from schedule: %v", dc)
+		logf(ctx, "[DEBUG] capacity calculated by scaling policy: %v", desiredCapacity)
+		logf(ctx, "[DEBUG] capacity calculated from schedule: %v", dc)
+		decision.ScheduleCapacity = varietyCapacities(dc)
 
-		mtv := r.config.MaxTerminatedVarieties
-		if dc.TotalInWorstCase(mtv) > desiredCapacity.TotalInWorstCase(mtv) {
+		if desiredCapacity == nil || dc.TotalOnFailure(failureModel) > desiredCapacity.TotalOnFailure(failureModel) {
 			desiredCapacity = dc
 		}
 	}
 
-	log.Printf("[INFO] desired capacity: %v", desiredCapacity)
+	logf(ctx, "[INFO] desired capacity: %v", desiredCapacity)
 
-	if r.config.MaxCapacity > 0 && desiredCapacity.Total() > r.config.MaxCapacity {
-		return fmt.Errorf("computed desired capacity is over MaxCapacity %f", r.config.MaxCapacity)
+	capped, err := r.clampDesiredCapacity(ctx, availableVarieties, desiredCapacity)
+	if err != nil {
+		return nil, err
 	}
+	if capped.Total() != desiredCapacity.Total() {
+		decision.CappedBy = append(decision.CappedBy, "MinCapacity/MaxCapacity")
+	}
+	desiredCapacity = capped
 
-	if desiredCapacity.Total() <= r.config.MinCapacity {
-		return fmt.Errorf("computed desired capacity is below MinCapacity %f <= %f", desiredCapacity.Total(), r.config.MinCapacity)
+	capped, err = r.budgetDesiredCapacity(ctx, availableVarieties, desiredCapacity, ondemandCost, price)
+	if err != nil {
+		return nil, err
 	}
+	if capped.Total() != desiredCapacity.Total() {
+		decision.CappedBy = append(decision.CappedBy, "MaxHourlyCost")
+	}
+	desiredCapacity = capped
+	decision.DesiredCapacity = varietyCapacities(desiredCapacity)
 
-	changeCount, err := spotCapacity.CountDiff(desiredCapacity)
+	changeCount, err := spotCapacity.CountDiff(desiredCapacity, r.config.CapacityTable())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	prohibitToScaleIn := r.config.ProhibitToScaleIn
 	if prohibitToScaleIn {
-		log.Println("Scaling in is prohibited")
+		logf(ctx, "Scaling in is prohibited")
 	}
 
 	for v, i := range changeCount {
-		if schedule != nil && i < 0 {
-			log.Printf("[WARN] with scheduled capacity, terminating an instance is not allowed: %v * %d", v, i)
+		if override.Paused {
+			logf(ctx, "[INFO] scaling is paused via API: %v * %d", v, i)
+			decision.Block(v, i, BlockedByPaused)
+			delete(changeCount, v)
+		} else if schedule != nil && overrideCapacity == nil && i < 0 {
+			logf(ctx, "[WARN] with scheduled capacity, terminating an instance is not allowed: %v * %d", v, i)
+			decision.Block(v, i, BlockedBySchedule)
 			delete(changeCount, v)
 		} else if prohibitToScaleIn && i < 0 {
-			log.Printf("[WARN] scaling in is prohibited, terminating an instance is not allowed: %v * %d", v, i)
+			logf(ctx, "[WARN] scaling in is prohibited, terminating an instance is not allowed: %v * %d", v, i)
+			decision.Block(v, i, BlockedByProhibitToScaleIn)
+			delete(changeCount, v)
+		} else if override.ScaleInPaused && i < 0 {
+			logf(ctx, "[WARN] scaling in is paused via API, terminating an instance is not allowed: %v * %d", v, i)
+			decision.Block(v, i, BlockedByScaleInPaused)
+			delete(changeCount, v)
+		} else if scaleInInCooldown && i < 0 {
+			logf(ctx, "[INFO] scaling in is in cooldown (it ends at %s): %v * %d", scalingStatus.ScaleInCooldownEndsAt, v, i)
+			decision.Block(v, i, BlockedByScaleInCooldown)
 			delete(changeCount, v)
+		} else if scaleOutInCooldown && i > 0 {
+			logf(ctx, "[INFO] scaling out is in cooldown (it ends at %s): %v * %d", scalingStatus.ScaleOutCooldownEndsAt, v, i)
+			decision.Block(v, i, BlockedByScaleOutCooldown)
+			delete(changeCount, v)
+		}
+	}
+
+	trimmed, err := removalBudget.Trim(ctx, changeCount, r.config.CapacityTable())
+	if err != nil {
+		return nil, err
+	}
+	for v, i := range changeCount {
+		if i != trimmed[v] {
+			decision.Block(v, i-trimmed[v], BlockedByRemovalBudget)
+		}
+	}
+
+	// changes left after blocking are checked against the budget again
+	budgeted, err := r.budgetChangeCount(ctx, workingInstances, trimmed, ondemandCost, price)
+	if err != nil {
+		return nil, err
+	}
+	for v, i := range trimmed {
+		if i != budgeted[v] {
+			logf(ctx, "[WARN] launching instances exceeds MaxHourlyCost: %v * %d", v, i-budgeted[v])
+			decision.Block(v, i-budgeted[v], BlockedByMaxHourlyCost)
 		}
 	}
 
-	log.Printf("[INFO] change count: %v", changeCount)
+	decision.SetChangeCount(budgeted)
+	return plan, nil
+}
+
+// applyScalePlan launches and terminates spot instances as plan says
+func (r *Runner) applyScalePlan(ctx context.Context, plan *ScalePlan) error {
+	changeCount := plan.ChangeCount()
+	logf(ctx, "[INFO] change count: %v", changeCount)
 
 	if len(changeCount) == 0 {
-		log.Println("[INFO] no change")
+		logf(ctx, "[INFO] no change")
+		plan.Decision.Result = "no change"
 		return nil
 	}
 
-	ami, err := r.config.AMICommand.Output([]string{})
+	amis, err := r.resolveAMIs(ctx, r.instanceVarieties())
 	if err != nil {
 		return err
 	}
 
-	if ami == "" {
-		log.Println("[WARN] AMI is not found. Abort scaling activity")
+	if !amis.ReadyFor(changeCount) {
+		logf(ctx, "[WARN] AMI is not found. Abort scaling activity")
+		plan.Decision.Result = "aborted: AMI is not found"
 		return nil
 	}
+	r.api.UpdateAMIs(amis.Distinct())
 
 	err = r.confirmIfNeeded("")
 	if err != nil {
@@ -361,26 +594,44 @@ func (r *Runner) scale() error {
 			"Variety": v,
 		})
 	}
-	err = r.runHookCommands("scalingInstances", "Scaling instances", map[string]interface{}{
-		"Changes": eventDetails,
+	err = r.runHookCommands(ctx, "scalingInstances", "Scaling instances", map[string]interface{}{
+		"Changes":  eventDetails,
+		"AMIs":     amis.Distinct(),
+		"Decision": plan.Decision,
 	})
 	if err != nil {
 		return err
 	}
 
-	err = r.takeCooldown()
+	scaleOut, scaleIn := false, false
+	for _, c := range changeCount {
+		scaleOut = scaleOut || c > 0
+		scaleIn = scaleIn || c < 0
+	}
+	err = r.takeCooldown(scaleOut, scaleIn)
+	if err != nil {
+		return err
+	}
+
+	// terminate instances launched from an outdated AMI first
+	managedInstances := plan.workingInstances.ManagedBy(r.config.FullAutoscalerID())
+	terminationTarget := append(managedInstances.OutdatedFor(amis), managedInstances.UpToDateFor(amis)...)
+
+	err = r.ec2Client.ChangeInstances(ctx, changeCount, amis, terminationTarget)
 	if err != nil {
 		return err
 	}
 
-	err = r.ec2Client.ChangeInstances(changeCount, ami, workingInstances.ManagedBy(r.config.FullAutoscalerID()))
+	err = r.recordRemoval(changeCount)
 	if err != nil {
 		return err
 	}
 
+	plan.Decision.Result = "applied"
+
 	for _, c := range changeCount {
 		if c > 0 {
-			err = r.updateTimer("LaunchingInstances")
+			err = r.updateTimer(ctx, "LaunchingInstances")
 			if err != nil {
 				return err
 			}
@@ -391,7 +642,66 @@ func (r *Runner) scale() error {
 	return nil
 }
 
-func (r *Runner) takeCooldown() error {
+// availableVarieties returns varieties whose spot price is at most the bidding price
+func (r *Runner) availableVarieties(ctx context.Context, price map[InstanceVariety]float64) ([]InstanceVariety, error) {
+	availableVarieties := []InstanceVariety{}
+	for v, p := range price {
+		bid, ok := r.config.BiddingPriceByType[v.InstanceType]
+		if !ok {
+			return nil, fmt.Errorf("Bidding price for %s is unknown", v.InstanceType)
+		}
+
+		if p <= bid {
+			availableVarieties = append(availableVarieties, v)
+		} else {
+			logf(ctx, "[DEBUG] %v is not available due to price (%f USD)", v, p)
+		}
+	}
+	logf(ctx, "[DEBUG] %d spot varieties are available", len(availableVarieties))
+
+	return availableVarieties, nil
+}
+
+// clampDesiredCapacity clamps desired capacity to MinCapacity and MaxCapacity and
+// notifies hooks of "capped" event if it is clamped
+func (r *Runner) clampDesiredCapacity(ctx context.Context, varieties []InstanceVariety, desired InstanceCapacity) (InstanceCapacity, error) {
+	clamped, err := ClampCapacity(varieties, r.config.CapacityTable(), desired, r.config.MinCapacity, r.config.MaxCapacity, r.config.FailureModel())
+	if err != nil {
+		return nil, err
+	}
+
+	unmet := math.Max(0, desired.Total()-clamped.Total())
+	r.api.UpdateMetrics(map[string]float64{
+		"unmet_demand_capacity": unmet,
+	})
+
+	if clamped.Total() == desired.Total() {
+		return desired, nil
+	}
+
+	bound := "MinCapacity"
+	if clamped.Total() < desired.Total() {
+		bound = "MaxCapacity"
+	}
+	logf(ctx, "[WARN] desired capacity %f is capped to %f by %s", desired.Total(), clamped.Total(), bound)
+	logf(ctx, "[INFO] capped desired capacity: %v", clamped)
+
+	err = r.runHookCommands(ctx, "capped", fmt.Sprintf("Desired capacity is capped by %s", bound), map[string]interface{}{
+		"Bound":               bound,
+		"DesiredCapacity":     desired.Total(),
+		"CappedCapacity":      clamped.Total(),
+		"UnmetDemandCapacity": unmet,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return clamped, nil
+}
+
+// takeCooldown takes Cooldown, which refresh, rotation and rebalance wait for, and
+// the cooldowns of the directions of the activity
+func (r *Runner) takeCooldown(scaleOut bool, scaleIn bool) error {
 	current, err := r.status.FetchCooldownEndsAt()
 	if err != nil {
 		return err
@@ -402,7 +712,7 @@ func (r *Runner) takeCooldown() error {
 		return err
 	}
 
-	t := time.Now().Add(d)
+	t := r.now().Add(d)
 	if t.After(current) {
 		err := r.status.StoreCooldownEndsAt(t)
 		if err != nil {
@@ -410,19 +720,19 @@ func (r *Runner) takeCooldown() error {
 		}
 	}
 
-	return nil
+	return r.takeScalingCooldowns(scaleOut, scaleIn)
 }
 
-func (r *Runner) removeExpiredSchedules() error {
+func (r *Runner) removeExpiredSchedules(ctx context.Context) error {
 	schedules, err := r.status.ListSchedules()
 	if err != nil {
 		return err
 	}
 
-	now := time.Now()
+	now := r.now()
 	for _, sch := range schedules {
 		if sch.EndAt.Before(now) {
-			log.Printf("[INFO] Removing expired schedule: %s", sch.Key)
+			logf(ctx, "[INFO] Removing expired schedule: %s", sch.Key)
 			err := r.status.RemoveSchedule(sch.Key)
 			if err != nil {
 				return err
@@ -441,7 +751,7 @@ func (r *Runner) getCurrentSchedule() (*Schedule, error) {
 
 	var activeSchedule *Schedule
 	for _, sch := range schedules {
-		now := time.Now()
+		now := r.now()
 		if now.After(sch.StartAt) && now.Before(sch.EndAt) {
 			if activeSchedule == nil || activeSchedule.StartAt.Before(sch.StartAt) {
 				activeSchedule = sch
@@ -452,7 +762,7 @@ func (r *Runner) getCurrentSchedule() (*Schedule, error) {
 	return activeSchedule, nil
 }
 
-func (r *Runner) runHookCommands(event string, message string, detail interface{}) error {
+func (r *Runner) runHookCommands(ctx context.Context, event string, message string, detail interface{}) error {
 	d := map[string]interface{}{
 		"event":   event,
 		"message": message,
@@ -464,7 +774,7 @@ func (r *Runner) runHookCommands(event string, message string, detail interface{
 	}
 
 	for _, h := range r.config.HookCommands {
-		err := h.RunWithStdin(string(input) + "\n")
+		err := h.RunWithStdin(ctx, string(input)+"\n")
 		if err != nil {
 			return err
 		}
@@ -472,16 +782,16 @@ func (r *Runner) runHookCommands(event string, message string, detail interface{
 	return nil
 }
 
-func (r *Runner) updateTimer(after string) error {
+func (r *Runner) updateTimer(ctx context.Context, after string) error {
 	for k, t := range r.config.Timers {
 		if t.After == after {
-			log.Println("[DEBUG] updating timer:", t)
+			logf(ctx, "[DEBUG] updating timer: %v", t)
 			d, err := time.ParseDuration(t.Duration)
 			if err != nil {
 				return err
 			}
 
-			err = r.status.UpdateTimer(k, time.Now().Add(d))
+			err = r.status.UpdateTimer(k, r.now().Add(d))
 			if err != nil {
 				return err
 			}
@@ -490,15 +800,15 @@ func (r *Runner) updateTimer(after string) error {
 	return nil
 }
 
-func (r *Runner) runExpiredTimers() error {
+func (r *Runner) runExpiredTimers(ctx context.Context) error {
 	keys, err := r.status.GetExpiredTimers()
 	if err != nil {
 		return err
 	}
 	for _, k := range keys {
 		if t, ok := r.config.Timers[k]; ok {
-			log.Println("[DEBUG] running timer command:", t)
-			err := t.RunWithStdin("")
+			logf(ctx, "[DEBUG] running timer command: %v", t)
+			err := t.RunWithStdin(ctx, "")
 			if err != nil {
 				return err
 			}
@@ -511,8 +821,22 @@ func (r *Runner) runExpiredTimers() error {
 	return nil
 }
 
-func (r *Runner) getCPUUtil() (float64, error) {
-	s, err := r.config.CPUUtilCommand.Output([]string{})
+func (r *Runner) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	return time.Now()
+}
+
+func (r *Runner) getCPUUtil(ctx context.Context) (float64, error) {
+	if r.cpuUtilSource != nil {
+		return r.cpuUtilSource(ctx)
+	}
+	return r.cpuUtilFromCommand(ctx)
+}
+
+func (r *Runner) cpuUtilFromCommand(ctx context.Context) (float64, error) {
+	s, err := r.config.CPUUtilCommand.Output(ctx, []string{})
 	if err != nil {
 		return 0.0, err
 	}
@@ -1,6 +1,11 @@
 package autoscaler
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/stretchr/testify/assert"
@@ -13,7 +18,7 @@ func configForTest(cpuUtil string) *Config {
 	c := &Config{
 		AutoscalerID: "test",
 		Cooldown:     "5m",
-		AMICommand: Command{
+		AMICommand: &Command{
 			Command: "echo",
 			Args:    []string{"-n", "ami-abc"},
 		},
@@ -43,24 +48,31 @@ func configForTest(cpuUtil string) *Config {
 		ScaleInThreshold:       20,
 		MaxCPUUtil:             80,
 	}
-	SetCapacityTable(c.InstanceCapacityByType)
 	return c
 }
 
+func amisForTest(config *Config) VarietyAMIs {
+	amis := VarietyAMIs{}
+	for _, v := range config.InstanceVarieties() {
+		amis[v] = "ami-abc"
+	}
+	return amis
+}
+
 func TestPropagateSIRTagsToInstances(t *testing.T) {
 	reqs := []*ec2.SpotInstanceRequest{
 		{SpotInstanceRequestId: aws.String("sir-abc")},
 	}
 
 	ec2Client := new(MockEC2ClientIface)
-	ec2Client.On("DescribePendingAndActiveSIRs").Return(reqs, nil)
-	ec2Client.On("PropagateTagsFromSIRsToInstances", reqs).Return(nil)
-	ec2Client.On("CreateStatusTagsOfSIRs", reqs, "completed").Return(nil)
+	ec2Client.On("DescribePendingAndActiveSIRs", mock.Anything, mock.Anything).Return(reqs, nil)
+	ec2Client.On("PropagateTagsFromSIRsToInstances", mock.Anything, reqs).Return(nil)
+	ec2Client.On("CreateStatusTagsOfSIRs", mock.Anything, reqs, "completed").Return(nil)
 
 	r := &Runner{
 		ec2Client: ec2Client,
 	}
-	err := r.propagateSIRTagsToInstances()
+	err := r.propagateSIRTagsToInstances(context.Background())
 	assert.NoError(t, err)
 	ec2Client.AssertExpectations(t)
 }
@@ -93,29 +105,35 @@ func TestScaleOut(t *testing.T) {
 	}
 
 	ec2Client := new(MockEC2ClientIface)
-	ec2Client.On("DescribeWorkingInstances").Return(instances, nil)
-	ec2Client.On("DescribeSpotPrices", config.InstanceVarieties()).Return(map[InstanceVariety]float64{
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(instances, nil)
+	ec2Client.On("DescribeSpotPrices", mock.Anything, config.InstanceVarieties()).Return(map[InstanceVariety]float64{
 		config.InstanceVarieties()[0]: 0.1,
 		config.InstanceVarieties()[1]: 0.1,
 		config.InstanceVarieties()[2]: 10, // too high
 	}, nil)
-	ec2Client.On("ChangeInstances", map[InstanceVariety]int64{
+	ec2Client.On("ChangeInstances", mock.Anything, map[InstanceVariety]int64{
 		config.InstanceVarieties()[0]: int64(1),
 		config.InstanceVarieties()[1]: int64(2),
-	}, "ami-abc", Instances{}).Return(nil)
+	}, amisForTest(config), Instances{}).Return(nil)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
 
 	statusStore := new(MockStatusStoreIface)
 	statusStore.On("ListSchedules").Return([]*Schedule{}, nil)
 	statusStore.On("FetchCooldownEndsAt").Return(time.Time{}, nil)
 	statusStore.On("StoreCooldownEndsAt", mock.AnythingOfType("time.Time")).Return(nil)
+	statusStore.On("FetchScalingStatus").Return(&ScalingStatus{}, nil)
+	statusStore.On("FetchScalingOverride").Return(&ScalingOverride{}, nil)
+	statusStore.On("StoreScalingStatus", mock.AnythingOfType("*autoscaler.ScalingStatus")).Return(nil)
 	statusStore.On("StoreMetric", mock.Anything).Return(nil)
 
 	r := &Runner{
-		config:    config,
-		ec2Client: ec2Client,
-		status:    statusStore,
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		api:          NewAPIServer(statusStore),
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
 	}
-	err := r.scale()
+	err := r.scale(context.Background())
 	assert.NoError(t, err)
 	ec2Client.AssertExpectations(t)
 }
@@ -168,28 +186,114 @@ func TestScaleIn(t *testing.T) {
 	}
 
 	ec2Client := new(MockEC2ClientIface)
-	ec2Client.On("DescribeWorkingInstances").Return(instances, nil)
-	ec2Client.On("DescribeSpotPrices", config.InstanceVarieties()).Return(map[InstanceVariety]float64{
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(instances, nil)
+	ec2Client.On("DescribeSpotPrices", mock.Anything, config.InstanceVarieties()).Return(map[InstanceVariety]float64{
 		config.InstanceVarieties()[0]: 0.1,
 		config.InstanceVarieties()[1]: 0.1,
 		config.InstanceVarieties()[2]: 10, // too high
 	}, nil)
-	ec2Client.On("ChangeInstances", map[InstanceVariety]int64{
+	ec2Client.On("ChangeInstances", mock.Anything, map[InstanceVariety]int64{
 		config.InstanceVarieties()[0]: int64(-1),
-	}, "ami-abc", instances).Return(nil)
+	}, amisForTest(config), instances).Return(nil)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
 
 	statusStore := new(MockStatusStoreIface)
 	statusStore.On("ListSchedules").Return([]*Schedule{}, nil)
 	statusStore.On("FetchCooldownEndsAt").Return(time.Time{}, nil)
 	statusStore.On("StoreCooldownEndsAt", mock.AnythingOfType("time.Time")).Return(nil)
+	statusStore.On("FetchScalingStatus").Return(&ScalingStatus{}, nil)
+	statusStore.On("FetchScalingOverride").Return(&ScalingOverride{}, nil)
+	statusStore.On("StoreScalingStatus", mock.AnythingOfType("*autoscaler.ScalingStatus")).Return(nil)
 	statusStore.On("StoreMetric", mock.Anything).Return(nil)
 
 	r := &Runner{
-		config:    config,
-		ec2Client: ec2Client,
-		status:    statusStore,
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		api:          NewAPIServer(statusStore),
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
+	}
+	err := r.scale(context.Background())
+	assert.NoError(t, err)
+	ec2Client.AssertExpectations(t)
+}
+
+func TestScaleOutCappedByMaxCapacity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spotscaler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	hookOutput := filepath.Join(dir, "hook")
+
+	config := configForTest("90")
+	config.MaxCapacity = 20
+	config.HookCommands = []Command{{Command: "sh", Args: []string{"-c", "cat >> " + hookOutput}}}
+	instances := Instances{
+		spotInstanceForTest("i-1", "c4.large", "ami-abc"),
+	}
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeWorkingInstances", mock.Anything, mock.Anything).Return(instances, nil)
+	ec2Client.On("DescribeSpotPrices", mock.Anything, config.InstanceVarieties()).Return(map[InstanceVariety]float64{
+		config.InstanceVarieties()[0]: 0.1,
+		config.InstanceVarieties()[1]: 0.1,
+		config.InstanceVarieties()[2]: 10, // too high
+	}, nil)
+	ec2Client.On("ChangeInstances", mock.Anything, map[InstanceVariety]int64{
+		config.InstanceVarieties()[1]: int64(1),
+	}, amisForTest(config), Instances{instances[0]}).Return(nil)
+	ec2Client.On("DescribeImage", mock.Anything, "ami-abc").Return(&ec2.Image{ImageId: aws.String("ami-abc"), State: aws.String("available")}, nil)
+
+	statusStore := new(MockStatusStoreIface)
+	statusStore.On("ListSchedules").Return([]*Schedule{}, nil)
+	statusStore.On("FetchCooldownEndsAt").Return(time.Time{}, nil)
+	statusStore.On("StoreCooldownEndsAt", mock.AnythingOfType("time.Time")).Return(nil)
+	statusStore.On("FetchScalingStatus").Return(&ScalingStatus{}, nil)
+	statusStore.On("FetchScalingOverride").Return(&ScalingOverride{}, nil)
+	statusStore.On("StoreScalingStatus", mock.AnythingOfType("*autoscaler.ScalingStatus")).Return(nil)
+
+	r := &Runner{
+		config:       config,
+		ec2Client:    ec2Client,
+		status:       statusStore,
+		api:          NewAPIServer(statusStore),
+		amiResolvers: map[string]AMIResolver{"": &CommandAMIResolver{Command: *config.AMICommand}},
 	}
-	err := r.scale()
+	err = r.scale(context.Background())
 	assert.NoError(t, err)
 	ec2Client.AssertExpectations(t)
+
+	assert.True(t, r.api.metrics["unmet_demand_capacity"] > 0)
+
+	b, err := ioutil.ReadFile(hookOutput)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"event":"capped"`)
+	assert.Contains(t, string(b), `"event":"scalingInstances"`)
+}
+
+func TestStartLoopStopsWhenCanceled(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, _, _ := newScalePlanTestRunner(t, &now)
+	r.config.LoopInterval = "1h"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error)
+	go func() { done <- r.StartLoop(ctx) }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop did not stop")
+	}
+}
+
+func TestCommandIsCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := Command{Command: "sleep", Args: []string{"10"}}.RunWithStdin(ctx, "")
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
 }
@@ -0,0 +1,244 @@
+package autoscaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ScalePlan represents changes of spot instances computed by scaling.
+// It can be saved to a file and applied later as long as working instances are the same.
+type ScalePlan struct {
+	InstanceIDs []string
+	Decision    *Decision
+
+	workingInstances Instances
+}
+
+func newScalePlan(t time.Time, workingInstances Instances) *ScalePlan {
+	ids := []string{}
+	for _, i := range workingInstances {
+		ids = append(ids, *i.InstanceId)
+	}
+	sort.Strings(ids)
+
+	return &ScalePlan{
+		InstanceIDs:      ids,
+		Decision:         &Decision{Time: t, Changes: []RecordedChange{}},
+		workingInstances: workingInstances,
+	}
+}
+
+func (p *ScalePlan) ChangeCount() map[InstanceVariety]int64 {
+	return p.Decision.ChangeCount()
+}
+
+// LoadScalePlan loads a plan saved by WriteScalePlan
+func LoadScalePlan(path string) (*ScalePlan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ScalePlan{}
+	err = json.Unmarshal(data, plan)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s failed: %s", path, err)
+	}
+	if plan.Decision == nil {
+		return nil, fmt.Errorf("%s has no decision", path)
+	}
+	return plan, nil
+}
+
+func WriteScalePlan(path string, plan *ScalePlan) error {
+	j, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(j, '\n'), 0644)
+}
+
+// PrintScalePlan writes the decision of plan as tables
+func PrintScalePlan(w io.Writer, plan *ScalePlan) error {
+	return PrintDecision(w, plan.Decision)
+}
+
+// Plan computes changes which scaling makes now. Call DisableSideEffects first
+// to compute them without any write to EC2 and the status store.
+func (r *Runner) Plan(ctx context.Context) (*ScalePlan, error) {
+	err := r.resolveSubnets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.planScale(ctx)
+}
+
+// ApplyPlan applies a plan computed by Plan. It fails while scaling is paused via API, or if the
+// plan is older than the longer of LoopInterval and Cooldown, working instances have changed or
+// another activity has taken place since the plan was computed.
+func (r *Runner) ApplyPlan(ctx context.Context, plan *ScalePlan) error {
+	planned := plan.Decision.Time
+	maxAge, err := r.maxPlanAge()
+	if err != nil {
+		return err
+	}
+	if r.now().After(planned.Add(maxAge)) {
+		return fmt.Errorf("plan is stale: it was computed at %s, more than %s ago", planned, maxAge)
+	}
+
+	override, err := r.status.FetchScalingOverride()
+	if err != nil {
+		return err
+	}
+	if override.Paused {
+		return fmt.Errorf("scaling is paused via API")
+	}
+
+	err = r.resolveSubnets(ctx)
+	if err != nil {
+		return err
+	}
+
+	workingInstances, err := r.ec2Client.DescribeWorkingInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := newScalePlan(planned, workingInstances)
+	if strings.Join(current.InstanceIDs, ",") != strings.Join(plan.InstanceIDs, ",") {
+		return fmt.Errorf("plan is stale: working instances have changed since %s", planned)
+	}
+
+	// an activity after the plan extends a cooldown beyond the one taken at the plan time
+	st, err := r.status.FetchScalingStatus()
+	if err != nil {
+		return err
+	}
+	scaleOut, err := time.ParseDuration(r.config.ScaleOutCooldownDuration())
+	if err != nil {
+		return err
+	}
+	scaleIn, err := time.ParseDuration(r.config.ScaleInCooldownDuration())
+	if err != nil {
+		return err
+	}
+	cooldown, err := time.ParseDuration(r.config.Cooldown)
+	if err != nil {
+		return err
+	}
+	cooldownEndsAt, err := r.status.FetchCooldownEndsAt()
+	if err != nil {
+		return err
+	}
+	if st.ScaleOutCooldownEndsAt.After(planned.Add(scaleOut)) || st.ScaleInCooldownEndsAt.After(planned.Add(scaleIn)) || cooldownEndsAt.After(planned.Add(cooldown)) {
+		return fmt.Errorf("plan is stale: another activity has taken place since %s", planned)
+	}
+
+	plan.workingInstances = workingInstances
+	return r.applyScalePlan(ctx, plan)
+}
+
+// maxPlanAge returns how long a plan can be applied after it is computed
+func (r *Runner) maxPlanAge() (time.Duration, error) {
+	loopInterval, err := time.ParseDuration(r.config.LoopInterval)
+	if err != nil {
+		return 0, err
+	}
+	cooldown, err := time.ParseDuration(r.config.Cooldown)
+	if err != nil {
+		return 0, err
+	}
+	if cooldown > loopInterval {
+		return cooldown, nil
+	}
+	return loopInterval, nil
+}
+
+// DisableSideEffects makes the runner compute decisions without launching or terminating
+// instances, writing to the status store or running hooks. EC2 API calls are made in dry-run mode.
+func (r *Runner) DisableSideEffects() {
+	c := *r.config
+	c.DryRun = true
+	c.HookCommands = nil
+	c.Timers = nil
+	c.ConfirmBeforeAction = false
+	r.config = &c
+
+	r.ec2Client = &readOnlyEC2Client{EC2ClientIface: r.ec2Client}
+	r.status = &readOnlyStatusStore{StatusStoreIface: r.status}
+}
+
+// readOnlyEC2Client ignores calls which change instances
+type readOnlyEC2Client struct {
+	EC2ClientIface
+}
+
+func (c *readOnlyEC2Client) TerminateInstancesByCount(ctx context.Context, instances Instances, v InstanceVariety, count int64) error {
+	logf(ctx, "[DEBUG] (read only) terminating %d instances of %v", count, v)
+	return nil
+}
+
+func (c *readOnlyEC2Client) TerminateInstances(ctx context.Context, instances Instances) error {
+	logf(ctx, "[DEBUG] (read only) terminating %d instances", len(instances))
+	return nil
+}
+
+func (c *readOnlyEC2Client) LaunchSpotInstances(ctx context.Context, v InstanceVariety, count int64, ami string) error {
+	logf(ctx, "[DEBUG] (read only) launching %d instances of %v", count, v)
+	return nil
+}
+
+func (c *readOnlyEC2Client) ChangeInstances(ctx context.Context, change map[InstanceVariety]int64, amis VarietyAMIs, terminationTarget Instances) error {
+	logf(ctx, "[DEBUG] (read only) changing instances: %v", change)
+	return nil
+}
+
+func (c *readOnlyEC2Client) PropagateTagsFromSIRsToInstances(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error {
+	return nil
+}
+
+func (c *readOnlyEC2Client) CreateStatusTagsOfSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest, status string) error {
+	return nil
+}
+
+func (c *readOnlyEC2Client) CancelOpenSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error {
+	return nil
+}
+
+// readOnlyStatusStore ignores writes
+type readOnlyStatusStore struct {
+	StatusStoreIface
+}
+
+func (s *readOnlyStatusStore) StoreCooldownEndsAt(t time.Time) error { return nil }
+
+func (s *readOnlyStatusStore) AddSchedules(sch *Schedule) error { return nil }
+
+func (s *readOnlyStatusStore) RemoveSchedule(key string) error { return nil }
+
+func (s *readOnlyStatusStore) UpdateTimer(key string, t time.Time) error { return nil }
+
+func (s *readOnlyStatusStore) DeleteTimer(key string) error { return nil }
+
+func (s *readOnlyStatusStore) StorePinnedAMI(key string, ami string) error { return nil }
+
+func (s *readOnlyStatusStore) StoreRefreshStatus(st *RefreshStatus) error { return nil }
+
+func (s *readOnlyStatusStore) StoreRefreshPaused(paused bool) error { return nil }
+
+func (s *readOnlyStatusStore) StoreRotationStatus(st *RotationStatus) error { return nil }
+
+func (s *readOnlyStatusStore) StoreScalingStatus(st *ScalingStatus) error { return nil }
+
+func (s *readOnlyStatusStore) StoreScalingOverride(o *ScalingOverride) error { return nil }
+
+func (s *readOnlyStatusStore) StoreRebalanceStatus(st *RebalanceStatus) error { return nil }
@@ -0,0 +1,94 @@
+package autoscaler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newScalePlanTestRunner(t *testing.T, now *time.Time) (*Runner, *SimulatedEC2Client, *MemoryStatusStore) {
+	config := simulationConfig(configForTest("90"))
+	config.LoopInterval = "1m"
+	clock := func() time.Time { return *now }
+	instances := Instances{spotInstanceForTest("i-1", "c4.large", "ami-abc")}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(90))
+	assert.NoError(t, err)
+	return r, ec2Client, status
+}
+
+func TestPlanWithoutSideEffects(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, ec2Client, status := newScalePlanTestRunner(t, &now)
+	r.DisableSideEffects()
+
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, plan.Decision.Changes)
+	assert.Equal(t, []string{"i-1"}, plan.InstanceIDs)
+
+	working, _ := ec2Client.DescribeWorkingInstances(context.Background())
+	assert.Len(t, working, 1)
+	cooldownEndsAt, err := status.FetchCooldownEndsAt()
+	assert.NoError(t, err)
+	assert.True(t, cooldownEndsAt.IsZero())
+	assert.True(t, r.config.DryRun)
+}
+
+func TestApplySavedPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spotscaler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plan.json")
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, ec2Client, _ := newScalePlanTestRunner(t, &now)
+
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, WriteScalePlan(path, plan))
+
+	loaded, err := LoadScalePlan(path)
+	assert.NoError(t, err)
+	assert.Equal(t, plan.ChangeCount(), loaded.ChangeCount())
+
+	err = r.ApplyPlan(context.Background(), loaded)
+	assert.NoError(t, err)
+	assert.Equal(t, plan.ChangeCount(), ec2Client.Changes)
+
+	// the plan is stale once it is applied
+	err = r.ApplyPlan(context.Background(), loaded)
+	assert.Error(t, err)
+}
+
+func TestApplyStalePlan(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, ec2Client, status := newScalePlanTestRunner(t, &now)
+
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+
+	// older than Cooldown (5m), which is longer than LoopInterval
+	now = now.Add(6 * time.Minute)
+	err = r.ApplyPlan(context.Background(), plan)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "more than 5m0s ago")
+	}
+
+	// scaling in by another activity after the plan
+	now = plan.Decision.Time.Add(time.Minute)
+	scaleIn, err := time.ParseDuration(r.config.ScaleInCooldownDuration())
+	assert.NoError(t, err)
+	assert.NoError(t, status.StoreScalingStatus(&ScalingStatus{ScaleInCooldownEndsAt: now.Add(scaleIn)}))
+	err = r.ApplyPlan(context.Background(), plan)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "another activity")
+	}
+	assert.Empty(t, ec2Client.Changes)
+}
@@ -0,0 +1,227 @@
+package autoscaler
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+// ScalingStatus represents cooldowns of each direction and recent removals by scaling
+type ScalingStatus struct {
+	ScaleOutCooldownEndsAt time.Time
+	ScaleInCooldownEndsAt  time.Time
+	Removals               []Removal
+}
+
+// InCooldown returns true if scaling out or scaling in is in cooldown at t
+func (s *ScalingStatus) InCooldown(t time.Time) bool {
+	return t.Before(s.ScaleOutCooldownEndsAt) || t.Before(s.ScaleInCooldownEndsAt)
+}
+
+// Removal represents instances terminated by scaling, refresh, rotation or rebalance in a loop
+type Removal struct {
+	At        time.Time
+	Capacity  float64
+	Instances int64
+}
+
+// RemovedSince returns capacity and the number of instances terminated after t
+func (s *ScalingStatus) RemovedSince(t time.Time) (float64, int64) {
+	capacity := 0.0
+	instances := int64(0)
+	for _, r := range s.Removals {
+		if r.At.After(t) {
+			capacity += r.Capacity
+			instances += r.Instances
+		}
+	}
+	return capacity, instances
+}
+
+// RemovalBudget is capacity and the number of instances which scaling can terminate now.
+// Nil means unlimited.
+type RemovalBudget struct {
+	Capacity  *float64
+	Instances *int64
+}
+
+// RemovalBudget returns the budget from limits per loop and per hour
+func (c *Config) RemovalBudget(st *ScalingStatus, now time.Time) RemovalBudget {
+	b := RemovalBudget{}
+	removedCapacity, removedInstances := st.RemovedSince(now.Add(-1 * time.Hour))
+
+	limitCapacity := func(limit float64) {
+		if b.Capacity == nil || limit < *b.Capacity {
+			l := math.Max(0, limit)
+			b.Capacity = &l
+		}
+	}
+	limitInstances := func(limit int64) {
+		if b.Instances == nil || limit < *b.Instances {
+			if limit < 0 {
+				limit = 0
+			}
+			b.Instances = &limit
+		}
+	}
+
+	if c.MaxTerminatedCapacityPerLoop > 0 {
+		limitCapacity(c.MaxTerminatedCapacityPerLoop)
+	}
+	if c.MaxTerminatedCapacityPerHour > 0 {
+		limitCapacity(c.MaxTerminatedCapacityPerHour - removedCapacity)
+	}
+	if c.MaxTerminatedInstancesPerLoop > 0 {
+		limitInstances(int64(c.MaxTerminatedInstancesPerLoop))
+	}
+	if c.MaxTerminatedInstancesPerHour > 0 {
+		limitInstances(int64(c.MaxTerminatedInstancesPerHour) - removedInstances)
+	}
+
+	return b
+}
+
+// Exhausted returns true if no instance can be terminated within the budget
+func (b RemovalBudget) Exhausted() bool {
+	return (b.Capacity != nil && *b.Capacity <= 0) || (b.Instances != nil && *b.Instances <= 0)
+}
+
+// Trim reduces negative counts in change to fit in the budget
+func (b RemovalBudget) Trim(ctx context.Context, change map[InstanceVariety]int64, capacities CapacityTable) (map[InstanceVariety]int64, error) {
+	if b.Capacity == nil && b.Instances == nil {
+		return change, nil
+	}
+
+	varieties := []InstanceVariety{}
+	for v, c := range change {
+		if c < 0 {
+			varieties = append(varieties, v)
+		}
+	}
+	sort.Sort(SortInstanceVarietiesByCapacity{Varieties: varieties, Capacities: capacities})
+
+	capacityLeft := 0.0
+	if b.Capacity != nil {
+		capacityLeft = *b.Capacity
+	}
+	instancesLeft := int64(0)
+	if b.Instances != nil {
+		instancesLeft = *b.Instances
+	}
+
+	trimmed := map[InstanceVariety]int64{}
+	for v, c := range change {
+		if c > 0 {
+			trimmed[v] = c
+		}
+	}
+	for _, v := range varieties {
+		cap, err := v.Capacity(capacities)
+		if err != nil {
+			return nil, err
+		}
+
+		count := -change[v]
+		if b.Instances != nil && count > instancesLeft {
+			count = instancesLeft
+		}
+		if byCapacity := int64(math.Floor(capacityLeft / cap)); b.Capacity != nil && count > byCapacity {
+			count = byCapacity
+		}
+
+		if count != -change[v] {
+			logf(ctx, "[WARN] terminating %v is limited from %d to %d by the removal budget", v, -change[v], count)
+		}
+		if count > 0 {
+			trimmed[v] = -count
+			instancesLeft -= count
+			capacityLeft -= float64(count) * cap
+		}
+	}
+
+	return trimmed, nil
+}
+
+// takeScalingCooldowns takes the cooldown of each direction in which instances are changed
+func (r *Runner) takeScalingCooldowns(scaleOut bool, scaleIn bool) error {
+	if !scaleOut && !scaleIn {
+		return nil
+	}
+
+	st, err := r.status.FetchScalingStatus()
+	if err != nil {
+		return err
+	}
+
+	if scaleOut {
+		d, err := time.ParseDuration(r.config.ScaleOutCooldownDuration())
+		if err != nil {
+			return err
+		}
+		if t := r.now().Add(d); t.After(st.ScaleOutCooldownEndsAt) {
+			st.ScaleOutCooldownEndsAt = t
+		}
+	}
+	if scaleIn {
+		d, err := time.ParseDuration(r.config.ScaleInCooldownDuration())
+		if err != nil {
+			return err
+		}
+		if t := r.now().Add(d); t.After(st.ScaleInCooldownEndsAt) {
+			st.ScaleInCooldownEndsAt = t
+		}
+	}
+
+	return r.status.StoreScalingStatus(st)
+}
+
+// recordRemoval records instances terminated by change for the hourly removal limits
+func (r *Runner) recordRemoval(change map[InstanceVariety]int64) error {
+	removal := Removal{At: r.now()}
+	for v, c := range change {
+		if c >= 0 {
+			continue
+		}
+		cap, err := v.Capacity(r.config.CapacityTable())
+		if err != nil {
+			return err
+		}
+		removal.Instances += -c
+		removal.Capacity += float64(-c) * cap
+	}
+	if removal.Instances == 0 {
+		return nil
+	}
+
+	st, err := r.status.FetchScalingStatus()
+	if err != nil {
+		return err
+	}
+
+	removals := []Removal{removal}
+	for _, rm := range st.Removals {
+		if rm.At.After(r.now().Add(-1 * time.Hour)) {
+			removals = append(removals, rm)
+		}
+	}
+	st.Removals = removals
+
+	return r.status.StoreScalingStatus(st)
+}
+
+// ScaleOutCooldownDuration returns ScaleOutCooldown or Cooldown if it is not set
+func (c *Config) ScaleOutCooldownDuration() string {
+	if c.ScaleOutCooldown != "" {
+		return c.ScaleOutCooldown
+	}
+	return c.Cooldown
+}
+
+// ScaleInCooldownDuration returns ScaleInCooldown or Cooldown if it is not set
+func (c *Config) ScaleInCooldownDuration() string {
+	if c.ScaleInCooldown != "" {
+		return c.ScaleInCooldown
+	}
+	return c.Cooldown
+}
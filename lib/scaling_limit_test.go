@@ -0,0 +1,148 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemovalBudget(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := &ScalingStatus{Removals: []Removal{
+		{At: now.Add(-10 * time.Minute), Capacity: 20, Instances: 2},
+		{At: now.Add(-2 * time.Hour), Capacity: 100, Instances: 10},
+	}}
+
+	b := (&Config{}).RemovalBudget(st, now)
+	assert.Nil(t, b.Capacity)
+	assert.Nil(t, b.Instances)
+
+	b = (&Config{
+		MaxTerminatedCapacityPerLoop:  30,
+		MaxTerminatedCapacityPerHour:  40,
+		MaxTerminatedInstancesPerHour: 3,
+	}).RemovalBudget(st, now)
+	assert.Equal(t, 20.0, *b.Capacity)
+	assert.Equal(t, int64(1), *b.Instances)
+
+	b = (&Config{MaxTerminatedInstancesPerHour: 1}).RemovalBudget(st, now)
+	assert.Equal(t, int64(0), *b.Instances)
+}
+
+func TestRemovalBudgetTrim(t *testing.T) {
+	capacities := CapacityTable{"t1": 10, "t2": 20, "t3": 10}
+	v1 := InstanceVariety{InstanceType: "t1", Subnet: Subnet{SubnetID: "subnet-a"}}
+	v2 := InstanceVariety{InstanceType: "t2", Subnet: Subnet{SubnetID: "subnet-a"}}
+	v3 := InstanceVariety{InstanceType: "t3", Subnet: Subnet{SubnetID: "subnet-a"}}
+
+	capacity := 30.0
+	b := RemovalBudget{Capacity: &capacity}
+	trimmed, err := b.Trim(context.Background(), map[InstanceVariety]int64{v1: -2, v2: -2, v3: 1}, capacities)
+	assert.NoError(t, err)
+	assert.Equal(t, map[InstanceVariety]int64{v1: -2, v3: 1}, trimmed)
+
+	instances := int64(3)
+	b = RemovalBudget{Instances: &instances}
+	trimmed, err = b.Trim(context.Background(), map[InstanceVariety]int64{v1: -2, v2: -2}, capacities)
+	assert.NoError(t, err)
+	assert.Equal(t, map[InstanceVariety]int64{v1: -2, v2: -1}, trimmed)
+}
+
+func TestScaleWithSeparateCooldowns(t *testing.T) {
+	config := simulationConfig(configForTest("10"))
+	config.ScaleOutCooldown = "1m"
+	config.ScaleInCooldown = "30m"
+	config.MaxTerminatedInstancesPerLoop = 1
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	instances := Instances{}
+	for _, id := range []string{"i-1", "i-2", "i-3"} {
+		instances = append(instances,
+			spotInstanceForTest(id+"c", "c4.large", "ami-abc"),
+			spotInstanceForTest(id+"m", "m4.large", "ami-abc"))
+	}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(10))
+	assert.NoError(t, err)
+
+	// scaling in is limited to an instance per loop
+	err = r.scale(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, ec2Client.Changes, 1)
+	for _, c := range ec2Client.Changes {
+		assert.Equal(t, int64(-1), c)
+	}
+
+	// scaling in is in cooldown while scaling out is not
+	now = now.Add(5 * time.Minute)
+	ec2Client.Changes = nil
+	r.cpuUtilSource = staticCPUUtil(10)
+	err = r.scale(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, ec2Client.Changes)
+
+	r.cpuUtilSource = staticCPUUtil(90)
+	err = r.scale(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ec2Client.Changes)
+
+	st, err := status.FetchScalingStatus()
+	assert.NoError(t, err)
+	assert.Len(t, st.Removals, 1)
+}
+
+func TestCooldownsOfDirectionsTaken(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, ec2Client, status := newScalePlanTestRunner(t, &now)
+
+	// scaling out takes only the scale-out cooldown
+	err := r.scale(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ec2Client.Changes)
+	st, err := status.FetchScalingStatus()
+	assert.NoError(t, err)
+	assert.True(t, now.Before(st.ScaleOutCooldownEndsAt))
+	assert.True(t, st.ScaleInCooldownEndsAt.IsZero())
+
+	// terminating a replaced instance takes only the scale-in cooldown
+	now = now.Add(time.Hour)
+	working, err := ec2Client.DescribeWorkingInstances(context.Background())
+	assert.NoError(t, err)
+	amis, err := r.resolveAMIs(context.Background(), r.instanceVarieties())
+	assert.NoError(t, err)
+	err = r.terminateReplacedInstances(context.Background(), working[:1], "refreshingInstances", "Terminating outdated instances", amis)
+	assert.NoError(t, err)
+	st, err = status.FetchScalingStatus()
+	assert.NoError(t, err)
+	assert.False(t, now.Before(st.ScaleOutCooldownEndsAt))
+	assert.True(t, now.Before(st.ScaleInCooldownEndsAt))
+}
+
+func TestReplacementLaunchDoesNotBlockScaleOut(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, ec2Client, status := newScalePlanTestRunner(t, &now)
+
+	amis, err := r.resolveAMIs(context.Background(), r.instanceVarieties())
+	assert.NoError(t, err)
+	working, err := ec2Client.DescribeWorkingInstances(context.Background())
+	assert.NoError(t, err)
+	_, err = r.launchInstances(context.Background(), map[InstanceVariety]int64{working[0].Variety(): 1}, "refreshingInstances", "Launching replacement instances", amis)
+	assert.NoError(t, err)
+
+	st, err := status.FetchScalingStatus()
+	assert.NoError(t, err)
+	assert.False(t, st.InCooldown(now))
+
+	ec2Client.Changes = nil
+	err = r.scale(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ec2Client.Changes)
+}
+
+func staticCPUUtil(u float64) func(ctx context.Context) (float64, error) {
+	return func(ctx context.Context) (float64, error) { return u, nil }
+}
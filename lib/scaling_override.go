@@ -0,0 +1,47 @@
+package autoscaler
+
+import (
+	"fmt"
+	"time"
+)
+
+// ScalingOverride is set via API to freeze or pin scaling (e.g. during incidents) without changing config
+type ScalingOverride struct {
+	// Paused stops scaling in both directions, refresh, rotation and rebalance
+	Paused bool
+	// ScaleInPaused prohibits terminating instances by scaling as ProhibitToScaleIn does,
+	// and by refresh, rotation and rebalance
+	ScaleInPaused bool
+	// Capacity replaces capacity computed by the scaling policy and schedules until CapacityExpiresAt.
+	// It is total capacity including on-demand instances as Capacity of a schedule.
+	Capacity          *float64
+	CapacityExpiresAt time.Time
+}
+
+// ActiveCapacity returns the override capacity if it is not expired at t
+func (o *ScalingOverride) ActiveCapacity(t time.Time) *float64 {
+	if o.Capacity == nil || !t.Before(o.CapacityExpiresAt) {
+		return nil
+	}
+	return o.Capacity
+}
+
+// SetCapacity overrides capacity until expiresAt
+func (o *ScalingOverride) SetCapacity(capacity float64, expiresAt time.Time, now time.Time) error {
+	if capacity < 0 {
+		return fmt.Errorf("capacity must not be negative: %v", capacity)
+	}
+	if !now.Before(expiresAt) {
+		return fmt.Errorf("expiry must be in the future: %s", expiresAt.Format(time.RFC3339))
+	}
+
+	o.Capacity = &capacity
+	o.CapacityExpiresAt = expiresAt
+	return nil
+}
+
+// ClearCapacity removes the override capacity
+func (o *ScalingOverride) ClearCapacity() {
+	o.Capacity = nil
+	o.CapacityExpiresAt = time.Time{}
+}
@@ -0,0 +1,211 @@
+package autoscaler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalingPausedViaOverride(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, _, status := newScalePlanTestRunner(t, &now)
+	assert.NoError(t, status.StoreScalingOverride(&ScalingOverride{Paused: true}))
+
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Decision.Changes)
+	assert.Equal(t, "paused", plan.Decision.Reason)
+}
+
+func TestScalingPausedSkipsReplacement(t *testing.T) {
+	config := simulationConfig(configForTest("10"))
+	config.InstanceRefresh = &InstanceRefreshConfig{BatchSize: 1}
+	config.MaxInstanceLifetime = "24h"
+	config.Rebalance = &RebalanceConfig{Threshold: 0.3, BatchSize: 2}
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	launchTime := now.Add(-48 * time.Hour)
+	instances := Instances{}
+	for _, id := range []string{"i-1", "i-2", "i-3", "i-4"} {
+		i := spotInstanceForTest(id, "c4.large", "ami-old")
+		i.LaunchTime = &launchTime
+		instances = append(instances, i)
+	}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(50))
+	assert.NoError(t, err)
+	assert.NoError(t, status.StoreScalingOverride(&ScalingOverride{Paused: true}))
+
+	// outdated, expired and imbalanced instances are kept
+	err = r.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, ec2Client.Changes)
+	working, _ := ec2Client.DescribeWorkingInstances(context.Background())
+	assert.Len(t, working, 4)
+
+	assert.NoError(t, status.StoreScalingOverride(&ScalingOverride{}))
+	err = r.Run(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ec2Client.Changes)
+}
+
+func TestScaleInPausedStopsReplacement(t *testing.T) {
+	config := simulationConfig(configForTest("10"))
+	config.Rebalance = &RebalanceConfig{Threshold: 0.3, BatchSize: 2}
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	instances := Instances{spotInstanceForTest("i-5", "m4.large", "ami-abc")}
+	for _, id := range []string{"i-1", "i-2", "i-3", "i-4"} {
+		instances = append(instances, spotInstanceForTest(id, "c4.large", "ami-abc"))
+	}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(10))
+	assert.NoError(t, err)
+	o := &ScalingOverride{ScaleInPaused: true}
+	assert.NoError(t, status.StoreScalingOverride(o))
+
+	// instances in the over-weighted variety would be kept, so nothing is launched
+	err = r.rebalanceInstances(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, ec2Client.Changes)
+
+	selected, err := r.selectReplaceableInstances(context.Background(), instances, instances, len(instances))
+	assert.NoError(t, err)
+	assert.Empty(t, selected)
+
+	launched, err := r.launchReplacementInstances(context.Background(), instances, 1, "refreshingInstances", "Launching replacement instances", amisForTest(config))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, launched)
+	assert.Empty(t, ec2Client.Changes)
+
+	o.ScaleInPaused = false
+	assert.NoError(t, status.StoreScalingOverride(o))
+	selected, err = r.selectReplaceableInstances(context.Background(), instances, instances, len(instances))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, selected)
+}
+
+func TestApplyPlanWhileScalingPaused(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, ec2Client, status := newScalePlanTestRunner(t, &now)
+
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, plan.Decision.Changes)
+
+	assert.NoError(t, status.StoreScalingOverride(&ScalingOverride{Paused: true}))
+	err = r.ApplyPlan(context.Background(), plan)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "paused")
+	}
+	assert.Empty(t, ec2Client.Changes)
+
+	assert.NoError(t, status.StoreScalingOverride(&ScalingOverride{}))
+	err = r.ApplyPlan(context.Background(), plan)
+	assert.NoError(t, err)
+	assert.Equal(t, plan.ChangeCount(), ec2Client.Changes)
+}
+
+func TestScalingCapacityOverride(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := simulationConfig(configForTest("90"))
+	clock := func() time.Time { return now }
+	instances := Instances{}
+	for _, id := range []string{"i-1", "i-2", "i-3"} {
+		instances = append(instances, spotInstanceForTest(id+"c", "c4.large", "ami-abc"), spotInstanceForTest(id+"m", "m4.large", "ami-abc"))
+	}
+	ec2Client := NewSimulatedEC2Client(config, clock, instances, map[string]float64{"c4.large": 0.1, "m4.large": 0.1})
+	status := NewMemoryStatusStore(clock)
+	r, err := newSimulationRunner(config, ec2Client, status, nil, clock, staticCPUUtil(90))
+	assert.NoError(t, err)
+
+	o := &ScalingOverride{}
+	assert.NoError(t, o.SetCapacity(10, now.Add(time.Hour), now))
+	assert.NoError(t, status.StoreScalingOverride(o))
+
+	// the override replaces capacity by the scaling policy which scales out at 90% CPU util
+	plan, err := r.Plan(context.Background())
+	assert.NoError(t, err)
+	d := plan.Decision
+	assert.NotNil(t, d.OverrideCapacity)
+	assert.Equal(t, d.OverrideCapacity, d.DesiredCapacity)
+	assert.NotEmpty(t, d.Changes)
+	for _, c := range d.Changes {
+		assert.True(t, c.Count < 0)
+	}
+
+	o.ScaleInPaused = true
+	assert.NoError(t, status.StoreScalingOverride(o))
+	plan, err = r.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Decision.Changes)
+	assert.NotEmpty(t, plan.Decision.Blocked)
+	for _, b := range plan.Decision.Blocked {
+		assert.Equal(t, BlockedByScaleInPaused, b.Rule)
+	}
+
+	// expired
+	now = now.Add(time.Hour)
+	plan, err = r.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, plan.Decision.OverrideCapacity)
+	assert.NotEmpty(t, plan.Decision.Changes)
+}
+
+func TestScalingOverrideAPI(t *testing.T) {
+	status := NewMemoryStatusStore(time.Now)
+	router := gin.New()
+	NewAPIServer(status).addRoutes(router)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, 200, request("POST", "/scaling/pause", "").Code)
+	assert.Equal(t, 200, request("POST", "/scaling/scale-in/pause", "").Code)
+	assert.Equal(t, 200, request("PUT", "/scaling/capacity", `{"Capacity": 10, "Duration": "1h"}`).Code)
+
+	o, err := status.FetchScalingOverride()
+	assert.NoError(t, err)
+	assert.True(t, o.Paused)
+	assert.True(t, o.ScaleInPaused)
+	if assert.NotNil(t, o.ActiveCapacity(time.Now())) {
+		assert.Equal(t, 10.0, *o.Capacity)
+	}
+
+	w := request("GET", "/status", "")
+	assert.Equal(t, 200, w.Code)
+	st := &Status{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), st))
+	if assert.NotNil(t, st.Override.Capacity) {
+		assert.Equal(t, 10.0, *st.Override.Capacity)
+	}
+	assert.True(t, st.Override.Paused)
+	assert.True(t, o.CapacityExpiresAt.Equal(st.Override.CapacityExpiresAt))
+
+	assert.Equal(t, 400, request("PUT", "/scaling/capacity", `{"Capacity": 10}`).Code)
+	assert.Equal(t, 400, request("PUT", "/scaling/capacity", `{"Capacity": 10, "ExpiresAt": "2017-01-01T00:00:00Z"}`).Code)
+	assert.Equal(t, 400, request("PUT", "/scaling/capacity", `{"Duration": "1h"}`).Code)
+
+	assert.Equal(t, 200, request("POST", "/scaling/resume", "").Code)
+	assert.Equal(t, 200, request("DELETE", "/scaling/capacity", "").Code)
+	o, err = status.FetchScalingOverride()
+	assert.NoError(t, err)
+	assert.False(t, o.Paused)
+	assert.True(t, o.ScaleInPaused)
+	assert.Nil(t, o.Capacity)
+}
@@ -0,0 +1,184 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// ScalingState is the current state a scaling policy decides desired capacity from
+type ScalingState struct {
+	AvailableVarieties []InstanceVariety
+	Capacities         CapacityTable
+	CPUUtil            float64
+	OndemandCapacity   float64
+	SpotCapacity       InstanceCapacity
+	FailureModel       FailureModel
+	Schedule           *Schedule
+}
+
+// SpotCapacityInWorstCase returns spot capacity when failure domains fail as FailureModel assumes
+func (s *ScalingState) SpotCapacityInWorstCase() float64 {
+	return s.SpotCapacity.TotalOnFailure(s.FailureModel)
+}
+
+// ScalingPolicy decides desired spot capacity.
+// Nil capacity means that the policy requires no scaling activity.
+type ScalingPolicy interface {
+	DesiredCapacity(ctx context.Context, s *ScalingState) (InstanceCapacity, error)
+}
+
+type ScalingPolicyConfig struct {
+	Type string `yaml:"Type" validate:"required,eq=worstCase|eq=step|eq=targetTracking"`
+	// Steps is used by step policy
+	Steps []ScalingStep `yaml:"Steps" validate:"dive"`
+	// TargetCPUUtil and Tolerance are used by targetTracking policy
+	TargetCPUUtil float64 `yaml:"TargetCPUUtil"`
+	Tolerance     float64 `yaml:"Tolerance"`
+}
+
+// ScalingStep adds Adjustment to spot capacity in the worst case when CPU util is
+// in [LowerBound, UpperBound). A nil bound means infinity.
+type ScalingStep struct {
+	LowerBound *float64 `yaml:"LowerBound"`
+	UpperBound *float64 `yaml:"UpperBound"`
+	Adjustment float64  `yaml:"Adjustment" validate:"required"`
+}
+
+func (s ScalingStep) covers(u float64) bool {
+	return (s.LowerBound == nil || *s.LowerBound <= u) && (s.UpperBound == nil || u < *s.UpperBound)
+}
+
+func (c *ScalingPolicyConfig) Validate() error {
+	switch c.Type {
+	case "step":
+		if len(c.Steps) == 0 {
+			return fmt.Errorf("ScalingPolicy.Steps is required for step policy")
+		}
+		for i, s := range c.Steps {
+			if s.LowerBound != nil && s.UpperBound != nil && *s.UpperBound <= *s.LowerBound {
+				return fmt.Errorf("UpperBound of ScalingPolicy.Steps[%d] must be greater than LowerBound", i)
+			}
+			for j, t := range c.Steps[:i] {
+				if stepsOverlap(s, t) {
+					return fmt.Errorf("ScalingPolicy.Steps[%d] overlaps with ScalingPolicy.Steps[%d]", i, j)
+				}
+			}
+		}
+	case "targetTracking":
+		if c.TargetCPUUtil <= 0 {
+			return fmt.Errorf("ScalingPolicy.TargetCPUUtil must be positive for targetTracking policy")
+		}
+		if c.Tolerance < 0 {
+			return fmt.Errorf("ScalingPolicy.Tolerance must not be negative")
+		}
+	}
+	return nil
+}
+
+func stepsOverlap(a ScalingStep, b ScalingStep) bool {
+	lower := func(s ScalingStep) float64 {
+		if s.LowerBound == nil {
+			return math.Inf(-1)
+		}
+		return *s.LowerBound
+	}
+	upper := func(s ScalingStep) float64 {
+		if s.UpperBound == nil {
+			return math.Inf(1)
+		}
+		return *s.UpperBound
+	}
+	return lower(a) < upper(b) && lower(b) < upper(a)
+}
+
+// NewScalingPolicy returns the policy configured in config. Worst-case policy is the default.
+func NewScalingPolicy(config *Config) ScalingPolicy {
+	c := config.ScalingPolicy
+	if c == nil {
+		c = &ScalingPolicyConfig{Type: "worstCase"}
+	}
+
+	switch c.Type {
+	case "step":
+		return &StepScalingPolicy{Steps: c.Steps}
+	case "targetTracking":
+		return &TargetTrackingScalingPolicy{TargetCPUUtil: c.TargetCPUUtil, Tolerance: c.Tolerance}
+	}
+	return &WorstCaseScalingPolicy{MaxCPUUtil: config.MaxCPUUtil, ScaleInThreshold: config.ScaleInThreshold}
+}
+
+// WorstCaseScalingPolicy scales out when CPU util would exceed MaxCPUUtil if failure domains
+// failed as the failure model assumes, and scales in when CPU util is below it by ScaleInThreshold.
+type WorstCaseScalingPolicy struct {
+	MaxCPUUtil       float64
+	ScaleInThreshold float64
+}
+
+func (p *WorstCaseScalingPolicy) DesiredCapacity(ctx context.Context, s *ScalingState) (InstanceCapacity, error) {
+	cpuUtilToScaleOut := p.MaxCPUUtil *
+		(s.OndemandCapacity + s.SpotCapacityInWorstCase()) /
+		(s.OndemandCapacity + s.SpotCapacity.Total())
+	cpuUtilToScaleIn := cpuUtilToScaleOut - p.ScaleInThreshold
+
+	if s.CPUUtil <= cpuUtilToScaleIn {
+		logf(ctx, "[DEBUG] scaling in")
+	} else if cpuUtilToScaleOut <= s.CPUUtil {
+		logf(ctx, "[DEBUG] scaling out")
+	} else if s.Schedule == nil {
+		logf(ctx, "[DEBUG] skip both scaling in and scaling out")
+		return nil, nil
+	}
+
+	return DesiredCapacityFromTargetCPUUtil(
+		ctx,
+		s.AvailableVarieties,
+		s.Capacities,
+		s.CPUUtil,
+		p.MaxCPUUtil,
+		p.ScaleInThreshold/2.0,
+		s.OndemandCapacity,
+		s.SpotCapacity.Total(),
+		s.FailureModel,
+	)
+}
+
+// StepScalingPolicy adds capacity of the step which covers CPU util to spot capacity in the worst case
+type StepScalingPolicy struct {
+	Steps []ScalingStep
+}
+
+func (p *StepScalingPolicy) DesiredCapacity(ctx context.Context, s *ScalingState) (InstanceCapacity, error) {
+	for _, step := range p.Steps {
+		if !step.covers(s.CPUUtil) {
+			continue
+		}
+
+		required := math.Max(0, s.SpotCapacityInWorstCase()+step.Adjustment)
+		logf(ctx, "[DEBUG] step policy: adjusting spot capacity in worst case by %f to %f", step.Adjustment, required)
+		return DesiredCapacityFromTotal(s.AvailableVarieties, s.Capacities, required, s.FailureModel)
+	}
+
+	logf(ctx, "[DEBUG] step policy: no step covers CPU util")
+	return nil, nil
+}
+
+// TargetTrackingScalingPolicy keeps CPU util in the worst case at TargetCPUUtil.
+// CPU util within Tolerance from the target requires no scaling activity.
+type TargetTrackingScalingPolicy struct {
+	TargetCPUUtil float64
+	Tolerance     float64
+}
+
+func (p *TargetTrackingScalingPolicy) DesiredCapacity(ctx context.Context, s *ScalingState) (InstanceCapacity, error) {
+	load := s.CPUUtil * (s.OndemandCapacity + s.SpotCapacity.Total())
+	cpuUtilInWorstCase := load / (s.OndemandCapacity + s.SpotCapacityInWorstCase())
+	logf(ctx, "[DEBUG] target tracking policy: CPU util in worst case: %f, target: %f", cpuUtilInWorstCase, p.TargetCPUUtil)
+
+	if math.Abs(cpuUtilInWorstCase-p.TargetCPUUtil) <= p.Tolerance {
+		return nil, nil
+	}
+
+	required := math.Max(0, load/p.TargetCPUUtil-s.OndemandCapacity)
+	return DesiredCapacityFromTotal(s.AvailableVarieties, s.Capacities, required, s.FailureModel)
+}
@@ -0,0 +1,113 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scalingStateForTest(cpuUtil float64) (*ScalingState, []InstanceVariety) {
+	varieties := []InstanceVariety{
+		{InstanceType: "t1", Subnet: Subnet{SubnetID: "subnet-a"}},
+		{InstanceType: "t2", Subnet: Subnet{SubnetID: "subnet-a"}},
+	}
+	return &ScalingState{
+		AvailableVarieties: varieties,
+		Capacities:         CapacityTable{"t1": 10, "t2": 10},
+		CPUUtil:            cpuUtil,
+		OndemandCapacity:   10,
+		SpotCapacity:       InstanceCapacity{varieties[0]: 20, varieties[1]: 20},
+		FailureModel:       varietyFailureModel(1),
+	}, varieties
+}
+
+func TestStepScalingPolicy(t *testing.T) {
+	p := NewScalingPolicy(&Config{ScalingPolicy: &ScalingPolicyConfig{
+		Type: "step",
+		Steps: []ScalingStep{
+			{UpperBound: floatPtr(30), Adjustment: -10},
+			{LowerBound: floatPtr(70), UpperBound: floatPtr(90), Adjustment: 10},
+			{LowerBound: floatPtr(90), Adjustment: 30},
+		},
+	}})
+
+	s, vs := scalingStateForTest(80)
+	c, err := p.DesiredCapacity(context.Background(), s)
+	assert.NoError(t, err)
+	// spot capacity in worst case is increased from 20 to 30
+	assert.Equal(t, InstanceCapacity{vs[0]: 30, vs[1]: 30}, c)
+
+	s, vs = scalingStateForTest(95)
+	c, err = p.DesiredCapacity(context.Background(), s)
+	assert.NoError(t, err)
+	assert.Equal(t, InstanceCapacity{vs[0]: 50, vs[1]: 50}, c)
+
+	s, vs = scalingStateForTest(10)
+	c, err = p.DesiredCapacity(context.Background(), s)
+	assert.NoError(t, err)
+	assert.Equal(t, InstanceCapacity{vs[0]: 10, vs[1]: 10}, c)
+
+	s, _ = scalingStateForTest(50)
+	c, err = p.DesiredCapacity(context.Background(), s)
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestTargetTrackingScalingPolicy(t *testing.T) {
+	p := NewScalingPolicy(&Config{ScalingPolicy: &ScalingPolicyConfig{
+		Type:          "targetTracking",
+		TargetCPUUtil: 50,
+		Tolerance:     5,
+	}})
+
+	// load is 40 * 50 = 2000 and CPU util in worst case is 2000 / 30
+	s, vs := scalingStateForTest(40)
+	c, err := p.DesiredCapacity(context.Background(), s)
+	assert.NoError(t, err)
+	// 2000 / 50 - 10 = 30 in worst case
+	assert.Equal(t, InstanceCapacity{vs[0]: 30, vs[1]: 30}, c)
+
+	// CPU util in worst case is 50
+	s, _ = scalingStateForTest(30)
+	c, err = p.DesiredCapacity(context.Background(), s)
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestWorstCaseScalingPolicyIsDefault(t *testing.T) {
+	p := NewScalingPolicy(&Config{MaxCPUUtil: 80, ScaleInThreshold: 20})
+	assert.IsType(t, &WorstCaseScalingPolicy{}, p)
+
+	// CPU util to scale out is 80 * 30 / 50 = 48
+	s, _ := scalingStateForTest(40)
+	c, err := p.DesiredCapacity(context.Background(), s)
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	s, _ = scalingStateForTest(60)
+	c, err = p.DesiredCapacity(context.Background(), s)
+	assert.NoError(t, err)
+	assert.True(t, c.Total() > 40)
+}
+
+func TestScalingPolicyConfigValidate(t *testing.T) {
+	c := &ScalingPolicyConfig{Type: "step", Steps: []ScalingStep{
+		{LowerBound: floatPtr(70), Adjustment: 10},
+		{LowerBound: floatPtr(80), UpperBound: floatPtr(90), Adjustment: 20},
+	}}
+	assert.Error(t, c.Validate())
+
+	c = &ScalingPolicyConfig{Type: "step", Steps: []ScalingStep{
+		{LowerBound: floatPtr(70), UpperBound: floatPtr(80), Adjustment: 10},
+		{LowerBound: floatPtr(80), Adjustment: 20},
+	}}
+	assert.NoError(t, c.Validate())
+
+	c = &ScalingPolicyConfig{Type: "targetTracking"}
+	assert.Error(t, c.Validate())
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
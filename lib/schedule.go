@@ -1,6 +1,9 @@
 package autoscaler
 
 import (
+	"fmt"
+	"io"
+	"text/tabwriter"
 	"time"
 )
 
@@ -16,3 +19,26 @@ func NewSchedule() *Schedule {
 		Key: time.Now().UTC().Format(time.RFC3339Nano),
 	}
 }
+
+func (s *Schedule) Validate() error {
+	if s.StartAt.IsZero() || s.EndAt.IsZero() {
+		return fmt.Errorf("StartAt and EndAt of a schedule are required")
+	}
+	if !s.StartAt.Before(s.EndAt) {
+		return fmt.Errorf("StartAt of a schedule must be before EndAt")
+	}
+	if s.Capacity <= 0 {
+		return fmt.Errorf("Capacity of a schedule must be positive")
+	}
+	return nil
+}
+
+// PrintSchedules writes schedules as a table
+func PrintSchedules(w io.Writer, schedules []*Schedule) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSTART AT\tEND AT\tCAPACITY")
+	for _, s := range schedules {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f\n", s.Key, s.StartAt.Format(time.RFC3339), s.EndAt.Format(time.RFC3339), s.Capacity)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,172 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// SimulatedEC2Client is an in-memory EC2 client for simulation.
+// Spot instances are fulfilled and become working as soon as they are requested.
+type SimulatedEC2Client struct {
+	config     *Config
+	clock      func() time.Time
+	instances  Instances
+	spotPrices map[string]float64
+	launched   int
+
+	// Changes is the last change passed to ChangeInstances
+	Changes map[InstanceVariety]int64
+}
+
+func NewSimulatedEC2Client(config *Config, clock func() time.Time, instances Instances, spotPrices map[string]float64) *SimulatedEC2Client {
+	return &SimulatedEC2Client{
+		config:     config,
+		clock:      clock,
+		instances:  instances,
+		spotPrices: spotPrices,
+	}
+}
+
+func (c *SimulatedEC2Client) TerminateInstancesByCount(ctx context.Context, instances Instances, v InstanceVariety, count int64) error {
+	target := Instances{}
+	for _, i := range instances {
+		if count <= 0 {
+			break
+		}
+		if i.Variety() == v {
+			target = append(target, i)
+			count--
+		}
+	}
+
+	return c.TerminateInstances(ctx, target)
+}
+
+func (c *SimulatedEC2Client) TerminateInstances(ctx context.Context, instances Instances) error {
+	terminated := map[string]bool{}
+	for _, i := range instances {
+		terminated[*i.InstanceId] = true
+	}
+
+	remaining := Instances{}
+	for _, i := range c.instances {
+		if !terminated[*i.InstanceId] {
+			remaining = append(remaining, i)
+		}
+	}
+	c.instances = remaining
+
+	return nil
+}
+
+func (c *SimulatedEC2Client) LaunchSpotInstances(ctx context.Context, v InstanceVariety, count int64, ami string) error {
+	if _, ok := c.config.BiddingPriceByType[v.InstanceType]; !ok {
+		return fmt.Errorf("Bidding price for %s is unknown", v.InstanceType)
+	}
+
+	for n := int64(0); n < count; n++ {
+		c.launched++
+		c.instances = append(c.instances, NewInstanceFromSDK(&ec2.Instance{
+			InstanceId:            aws.String(fmt.Sprintf("i-simulated%d", c.launched)),
+			InstanceType:          aws.String(v.InstanceType),
+			SubnetId:              aws.String(v.Subnet.SubnetID),
+			Placement:             &ec2.Placement{AvailabilityZone: aws.String(v.Subnet.AvailabilityZone)},
+			SpotInstanceRequestId: aws.String(fmt.Sprintf("sir-simulated%d", c.launched)),
+			ImageId:               aws.String(ami),
+			LaunchTime:            aws.Time(c.clock()),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("ManagedBy"), Value: aws.String(c.config.FullAutoscalerID())},
+			},
+		}))
+	}
+
+	return nil
+}
+
+func (c *SimulatedEC2Client) ChangeInstances(ctx context.Context, change map[InstanceVariety]int64, amis VarietyAMIs, terminationTarget Instances) error {
+	c.Changes = change
+	for v, count := range change {
+		if count > 0 {
+			ami, ok := amis[v]
+			if !ok || ami == "" {
+				return fmt.Errorf("AMI for %v is unknown", v)
+			}
+			err := c.LaunchSpotInstances(ctx, v, count, ami)
+			if err != nil {
+				return err
+			}
+		} else if count < 0 {
+			err := c.TerminateInstancesByCount(ctx, terminationTarget, v, count*-1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *SimulatedEC2Client) DescribeWorkingInstances(ctx context.Context) (Instances, error) {
+	instances := make(Instances, len(c.instances))
+	copy(instances, c.instances)
+	return instances, nil
+}
+
+func (c *SimulatedEC2Client) DescribePendingAndActiveSIRs(ctx context.Context) ([]*ec2.SpotInstanceRequest, error) {
+	return []*ec2.SpotInstanceRequest{}, nil
+}
+
+func (c *SimulatedEC2Client) PropagateTagsFromSIRsToInstances(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error {
+	return nil
+}
+
+func (c *SimulatedEC2Client) CreateStatusTagsOfSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest, status string) error {
+	return nil
+}
+
+// DescribeSpotPrices returns prices by instance type. Varieties whose price is unknown are omitted.
+func (c *SimulatedEC2Client) DescribeSpotPrices(ctx context.Context, vs []InstanceVariety) (map[InstanceVariety]float64, error) {
+	prices := map[InstanceVariety]float64{}
+	for _, v := range vs {
+		p, ok := c.spotPrices[v.InstanceType]
+		if !ok {
+			logf(ctx, "[WARN] spot price of %s is unknown in simulation", v.InstanceType)
+			continue
+		}
+		prices[v] = p
+	}
+	return prices, nil
+}
+
+func (c *SimulatedEC2Client) DescribeDeadSIRs(ctx context.Context) ([]*ec2.SpotInstanceRequest, error) {
+	return []*ec2.SpotInstanceRequest{}, nil
+}
+
+func (c *SimulatedEC2Client) CancelOpenSIRs(ctx context.Context, reqs []*ec2.SpotInstanceRequest) error {
+	return nil
+}
+
+func (c *SimulatedEC2Client) DescribeImage(ctx context.Context, id string) (*ec2.Image, error) {
+	return &ec2.Image{ImageId: aws.String(id), State: aws.String("available")}, nil
+}
+
+func (c *SimulatedEC2Client) DescribeLatestImage(ctx context.Context, owners []string, filters EC2Filters) (*ec2.Image, error) {
+	return c.DescribeImage(ctx, simulatedAMI)
+}
+
+// DescribeSubnets returns subnets in config whose ID is in ids. Filters are ignored.
+func (c *SimulatedEC2Client) DescribeSubnets(ctx context.Context, ids []string, filters EC2Filters) ([]Subnet, error) {
+	subnets := []Subnet{}
+	for _, s := range c.config.Subnets {
+		for _, id := range ids {
+			if s.SubnetID == id {
+				subnets = appendSubnetIfMissing(subnets, s)
+			}
+		}
+	}
+	return subnets, nil
+}
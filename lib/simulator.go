@@ -0,0 +1,314 @@
+package autoscaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const simulatedAMI = "ami-simulated"
+
+// SimulationInstance represents a working instance in a simulation snapshot
+type SimulationInstance struct {
+	InstanceID       string
+	InstanceType     string
+	SubnetID         string
+	AvailabilityZone string
+	Spot             bool
+	// Managed means that the instance is launched by this autoscaler
+	Managed    bool
+	LaunchTime time.Time
+}
+
+// SimulationMetric is a point of CPU util series observed with Capacity in total.
+// CPU util in simulation is scaled by simulated capacity, that is, load is kept.
+// Zero Capacity means the total capacity of the snapshot instances.
+type SimulationMetric struct {
+	Time     time.Time
+	CPUUtil  float64
+	Capacity float64
+}
+
+// SimulationInput is a snapshot which a simulation starts from
+type SimulationInput struct {
+	Instances []SimulationInstance
+	// SpotPrices is keyed by instance type
+	SpotPrices map[string]float64
+	Schedules  []*Schedule
+	Metrics    []SimulationMetric
+	// Subnets are used instead of ones in config if specified (e.g. with SubnetFilters)
+	Subnets []Subnet
+}
+
+// SimulationStep represents a decision made at a point of CPU util series
+type SimulationStep struct {
+	Time             time.Time
+	CPUUtil          float64
+	OndemandCapacity float64
+	SpotCapacity     float64
+	InCooldown       bool
+	Changes          map[InstanceVariety]int64
+	Error            string
+}
+
+// LoadSimulationInput loads JSON files. schedulesPath and subnetsPath can be empty.
+func LoadSimulationInput(instancesPath string, pricesPath string, schedulesPath string, metricsPath string, subnetsPath string) (*SimulationInput, error) {
+	input := &SimulationInput{}
+
+	files := []struct {
+		path string
+		v    interface{}
+	}{
+		{instancesPath, &input.Instances},
+		{pricesPath, &input.SpotPrices},
+		{schedulesPath, &input.Schedules},
+		{metricsPath, &input.Metrics},
+		{subnetsPath, &input.Subnets},
+	}
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(b, f.v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %s", f.path, err)
+		}
+	}
+
+	return input, nil
+}
+
+// Simulator runs decision logic of Runner.scale against in-memory EC2 and status store
+type Simulator struct {
+	runner    *Runner
+	ec2Client *SimulatedEC2Client
+	status    *MemoryStatusStore
+	input     *SimulationInput
+	current   time.Time
+	metric    SimulationMetric
+}
+
+func NewSimulator(config *Config, input *SimulationInput) (*Simulator, error) {
+	if len(input.Metrics) == 0 {
+		return nil, fmt.Errorf("metric series is empty")
+	}
+
+	c := simulationConfig(config)
+
+	s := &Simulator{input: input}
+	clock := func() time.Time { return s.current }
+
+	instances := Instances{}
+	for _, i := range input.Instances {
+		instance := &ec2.Instance{
+			InstanceId:   aws.String(i.InstanceID),
+			InstanceType: aws.String(i.InstanceType),
+			SubnetId:     aws.String(i.SubnetID),
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String(i.AvailabilityZone)},
+			ImageId:      aws.String(simulatedAMI),
+			LaunchTime:   aws.Time(i.LaunchTime),
+		}
+		if i.Spot {
+			instance.SpotInstanceRequestId = aws.String(fmt.Sprintf("sir-%s", i.InstanceID))
+		}
+		if i.Managed {
+			instance.Tags = []*ec2.Tag{{Key: aws.String("ManagedBy"), Value: aws.String(c.FullAutoscalerID())}}
+		}
+		instances = append(instances, NewInstanceFromSDK(instance))
+	}
+
+	s.ec2Client = NewSimulatedEC2Client(c, clock, instances, input.SpotPrices)
+	s.status = NewMemoryStatusStore(clock)
+	for _, sch := range input.Schedules {
+		err := s.status.AddSchedules(sch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	runner, err := newSimulationRunner(c, s.ec2Client, s.status, input.Subnets, clock, s.cpuUtil)
+	if err != nil {
+		return nil, err
+	}
+	s.runner = runner
+
+	return s, nil
+}
+
+// simulationConfig returns a copy of config whose side effects outside of the process are disabled
+func simulationConfig(config *Config) *Config {
+	c := *config
+	c.HookCommands = nil
+	c.ConfirmBeforeAction = false
+	c.Timers = nil
+	return &c
+}
+
+// newSimulationRunner returns a Runner whose AMI sources all resolve to the same AMI
+func newSimulationRunner(config *Config, ec2Client EC2ClientIface, status StatusStoreIface, subnets []Subnet, clock func() time.Time, cpuUtilSource func(ctx context.Context) (float64, error)) (*Runner, error) {
+	amiResolvers, err := NewAMIResolvers(config, ec2Client, status)
+	if err != nil {
+		return nil, err
+	}
+	for k := range amiResolvers {
+		err := status.StorePinnedAMI(k, simulatedAMI)
+		if err != nil {
+			return nil, err
+		}
+		amiResolvers[k] = &PinnedAMIResolver{status: status, Key: k}
+	}
+
+	return &Runner{
+		config:        config,
+		status:        status,
+		ec2Client:     ec2Client,
+		api:           NewAPIServer(status),
+		amiResolvers:  amiResolvers,
+		subnets:       subnets,
+		clock:         clock,
+		cpuUtilSource: cpuUtilSource,
+	}, nil
+}
+
+// Run runs scaling at every point of CPU util series and returns decisions
+func (s *Simulator) Run(ctx context.Context) ([]*SimulationStep, error) {
+	metrics := make([]SimulationMetric, len(s.input.Metrics))
+	copy(metrics, s.input.Metrics)
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].Time.Before(metrics[j].Time) })
+
+	initialCapacity, err := s.totalCapacity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range metrics {
+		if metrics[i].Capacity == 0 {
+			metrics[i].Capacity = initialCapacity
+		}
+	}
+
+	steps := []*SimulationStep{}
+	for _, m := range metrics {
+		s.current = m.Time
+		s.metric = m
+
+		step, err := s.step(ctx)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+func (s *Simulator) step(ctx context.Context) (*SimulationStep, error) {
+	step := &SimulationStep{Time: s.current}
+
+	instances, err := s.ec2Client.DescribeWorkingInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ondemandCapacity, err := instances.Ondemand().Capacity(s.runner.config.CapacityTable())
+	if err != nil {
+		return nil, err
+	}
+	spotCapacity, err := instances.Spot().Capacity(s.runner.config.CapacityTable())
+	if err != nil {
+		return nil, err
+	}
+	step.OndemandCapacity = ondemandCapacity.Total()
+	step.SpotCapacity = spotCapacity.Total()
+
+	step.CPUUtil, err = s.cpuUtil(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scalingStatus, err := s.status.FetchScalingStatus()
+	if err != nil {
+		return nil, err
+	}
+	step.InCooldown = scalingStatus.InCooldown(s.current)
+
+	err = s.runner.removeExpiredSchedules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ec2Client.Changes = nil
+	err = s.runner.scale(ctx)
+	if err != nil {
+		logf(ctx, "[WARN] scaling failed in simulation: %s", err)
+		step.Error = err.Error()
+	}
+	step.Changes = s.ec2Client.Changes
+
+	return step, nil
+}
+
+// cpuUtil returns CPU util of the current metric scaled by simulated capacity
+func (s *Simulator) cpuUtil(ctx context.Context) (float64, error) {
+	total, err := s.totalCapacity(ctx)
+	if err != nil {
+		return 0.0, err
+	}
+	if total <= 0 {
+		return 0.0, fmt.Errorf("no working instance in simulation")
+	}
+	return s.metric.CPUUtil * s.metric.Capacity / total, nil
+}
+
+func (s *Simulator) totalCapacity(ctx context.Context) (float64, error) {
+	instances, err := s.ec2Client.DescribeWorkingInstances(ctx)
+	if err != nil {
+		return 0.0, err
+	}
+	c, err := instances.Capacity(s.runner.config.CapacityTable())
+	if err != nil {
+		return 0.0, err
+	}
+	return c.Total(), nil
+}
+
+// PrintSimulationSteps writes decisions as a table
+func PrintSimulationSteps(w io.Writer, steps []*SimulationStep) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tCPU UTIL\tONDEMAND\tSPOT\tDECISION")
+	for _, s := range steps {
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%.1f\t%s\n", s.Time.Format(time.RFC3339), s.CPUUtil, s.OndemandCapacity, s.SpotCapacity, s.Decision())
+	}
+	return tw.Flush()
+}
+
+// Decision describes the step in a line
+func (s *SimulationStep) Decision() string {
+	if s.Error != "" {
+		return fmt.Sprintf("error: %s", s.Error)
+	}
+	if len(s.Changes) == 0 {
+		if s.InCooldown {
+			return "cooldown"
+		}
+		return "no change"
+	}
+
+	changes := []string{}
+	for v, c := range s.Changes {
+		changes = append(changes, fmt.Sprintf("%+d %s/%s", c, v.InstanceType, v.Subnet.SubnetID))
+	}
+	sort.Strings(changes)
+	return strings.Join(changes, " ")
+}
@@ -0,0 +1,55 @@
+package autoscaler
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulatorRun(t *testing.T) {
+	config := configForTest("0")
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	input := &SimulationInput{
+		Instances: []SimulationInstance{
+			{InstanceID: "i-1", InstanceType: "c4.large", SubnetID: "subnet-abc", AvailabilityZone: "ap-northeast-1b"},
+			{InstanceID: "i-2", InstanceType: "c4.large", SubnetID: "subnet-abc", AvailabilityZone: "ap-northeast-1b", Spot: true, Managed: true},
+			{InstanceID: "i-3", InstanceType: "m4.large", SubnetID: "subnet-abc", AvailabilityZone: "ap-northeast-1b", Spot: true, Managed: true},
+		},
+		SpotPrices: map[string]float64{"c4.large": 0.1, "m4.large": 0.1},
+		Metrics: []SimulationMetric{
+			{Time: start, CPUUtil: 90},
+			{Time: start.Add(1 * time.Minute), CPUUtil: 90},
+			{Time: start.Add(10 * time.Minute), CPUUtil: 90},
+		},
+	}
+
+	simulator, err := NewSimulator(config, input)
+	assert.NoError(t, err)
+
+	steps, err := simulator.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, steps, 3)
+
+	assert.Equal(t, 90.0, steps[0].CPUUtil)
+	assert.Equal(t, 10.0, steps[0].OndemandCapacity)
+	assert.Equal(t, 20.0, steps[0].SpotCapacity)
+	assert.True(t, len(steps[0].Changes) > 0)
+
+	// load is kept while capacity increases
+	assert.True(t, steps[1].InCooldown)
+	assert.True(t, steps[1].CPUUtil < 90.0)
+	assert.True(t, steps[1].SpotCapacity > 20.0)
+
+	assert.False(t, steps[2].InCooldown)
+	assert.Empty(t, steps[2].Error)
+
+	buf := &bytes.Buffer{}
+	err = PrintSimulationSteps(buf, steps)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "cooldown")
+	assert.Contains(t, buf.String(), "c4.large/subnet-abc")
+}
@@ -18,6 +18,73 @@ type StatusStoreIface interface {
 	UpdateTimer(key string, t time.Time) error
 	DeleteTimer(key string) error
 	GetExpiredTimers() ([]string, error)
+	StorePinnedAMI(key string, ami string) error
+	FetchPinnedAMI(key string) (string, error)
+	StoreRefreshStatus(st *RefreshStatus) error
+	FetchRefreshStatus() (*RefreshStatus, error)
+	StoreRefreshPaused(paused bool) error
+	FetchRefreshPaused() (bool, error)
+	StoreRotationStatus(st *RotationStatus) error
+	FetchRotationStatus() (*RotationStatus, error)
+	StoreScalingStatus(st *ScalingStatus) error
+	FetchScalingStatus() (*ScalingStatus, error)
+	StoreScalingOverride(o *ScalingOverride) error
+	FetchScalingOverride() (*ScalingOverride, error)
+	StoreRebalanceStatus(st *RebalanceStatus) error
+	FetchRebalanceStatus() (*RebalanceStatus, error)
+}
+
+// Status is a summary of data in a status store
+type Status struct {
+	CooldownEndsAt time.Time
+	Schedules      []*Schedule
+	Scaling        *ScalingStatus
+	Override       *ScalingOverride
+	Refresh        *RefreshStatus
+	RefreshPaused  bool
+	Rotation       *RotationStatus
+	Rebalance      *RebalanceStatus
+}
+
+// FetchStatus fetches a summary of data in s
+func FetchStatus(s StatusStoreIface) (*Status, error) {
+	st := &Status{}
+	var err error
+
+	st.CooldownEndsAt, err = s.FetchCooldownEndsAt()
+	if err != nil {
+		return nil, err
+	}
+	st.Schedules, err = s.ListSchedules()
+	if err != nil {
+		return nil, err
+	}
+	st.Scaling, err = s.FetchScalingStatus()
+	if err != nil {
+		return nil, err
+	}
+	st.Override, err = s.FetchScalingOverride()
+	if err != nil {
+		return nil, err
+	}
+	st.Refresh, err = s.FetchRefreshStatus()
+	if err != nil {
+		return nil, err
+	}
+	st.RefreshPaused, err = s.FetchRefreshPaused()
+	if err != nil {
+		return nil, err
+	}
+	st.Rotation, err = s.FetchRotationStatus()
+	if err != nil {
+		return nil, err
+	}
+	st.Rebalance, err = s.FetchRebalanceStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
 }
 
 // StatusStore stores status data in Redis
@@ -26,18 +93,26 @@ type StatusStore struct {
 	KeyPrefix   string
 }
 
-func NewStatusStore(redisHost string, autoscalerID string) *StatusStore {
-	client := redis.NewClient(&redis.Options{
-		Addr: redisHost,
-		DB:   0,
-	})
+func NewStatusStore(redisHost string, redisPassword string, autoscalerID string) *StatusStore {
+	return NewStatusStoreWithClient(NewRedisClient(redisHost, redisPassword), autoscalerID)
+}
 
+// NewStatusStoreWithClient returns a StatusStore using client, which can be shared by autoscaler groups
+func NewStatusStoreWithClient(client *redis.Client, autoscalerID string) *StatusStore {
 	return &StatusStore{
 		redisClient: client,
 		KeyPrefix:   fmt.Sprintf("%s/", autoscalerID),
 	}
 }
 
+func NewRedisClient(redisHost string, redisPassword string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     redisHost,
+		Password: redisPassword,
+		DB:       0,
+	})
+}
+
 func (s *StatusStore) key(k string) string {
 	return s.KeyPrefix + k
 }
@@ -145,3 +220,187 @@ func (s *StatusStore) GetExpiredTimers() ([]string, error) {
 
 	return keys, nil
 }
+
+// StorePinnedAMI stores AMI ID used by pinned AMI resolver of key. Empty ami unpins it.
+func (s *StatusStore) StorePinnedAMI(key string, ami string) error {
+	if ami == "" {
+		_, err := s.redisClient.HDel(s.key("pinnedAMIs"), pinnedAMIField(key)).Result()
+		return err
+	}
+
+	_, err := s.redisClient.HSet(s.key("pinnedAMIs"), pinnedAMIField(key), ami).Result()
+	return err
+}
+
+func (s *StatusStore) FetchPinnedAMI(key string) (string, error) {
+	ami, err := s.redisClient.HGet(s.key("pinnedAMIs"), pinnedAMIField(key)).Result()
+	if err == redis.Nil {
+		// not found
+		return "", nil
+	}
+	return ami, err
+}
+
+func pinnedAMIField(key string) string {
+	if key == "" {
+		return "default"
+	}
+	return key
+}
+
+func (s *StatusStore) StoreRefreshStatus(st *RefreshStatus) error {
+	j, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redisClient.Set(s.key("refreshStatus"), string(j), 0).Result()
+	return err
+}
+
+func (s *StatusStore) FetchRefreshStatus() (*RefreshStatus, error) {
+	st := &RefreshStatus{}
+	j, err := s.redisClient.Get(s.key("refreshStatus")).Result()
+	if err == redis.Nil {
+		// not found
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(j), st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *StatusStore) StoreRefreshPaused(paused bool) error {
+	_, err := s.redisClient.Set(s.key("refreshPaused"), strconv.FormatBool(paused), 0).Result()
+	return err
+}
+
+func (s *StatusStore) FetchRefreshPaused() (bool, error) {
+	str, err := s.redisClient.Get(s.key("refreshPaused")).Result()
+	if err == redis.Nil {
+		// not found
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(str)
+}
+
+func (s *StatusStore) StoreRotationStatus(st *RotationStatus) error {
+	j, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redisClient.Set(s.key("rotationStatus"), string(j), 0).Result()
+	return err
+}
+
+func (s *StatusStore) FetchRotationStatus() (*RotationStatus, error) {
+	st := &RotationStatus{}
+	j, err := s.redisClient.Get(s.key("rotationStatus")).Result()
+	if err == redis.Nil {
+		// not found
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(j), st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *StatusStore) StoreScalingStatus(st *ScalingStatus) error {
+	j, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redisClient.Set(s.key("scalingStatus"), string(j), 0).Result()
+	return err
+}
+
+func (s *StatusStore) FetchScalingStatus() (*ScalingStatus, error) {
+	st := &ScalingStatus{}
+	j, err := s.redisClient.Get(s.key("scalingStatus")).Result()
+	if err == redis.Nil {
+		// not found
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(j), st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *StatusStore) StoreScalingOverride(o *ScalingOverride) error {
+	j, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redisClient.Set(s.key("scalingOverride"), string(j), 0).Result()
+	return err
+}
+
+func (s *StatusStore) FetchScalingOverride() (*ScalingOverride, error) {
+	o := &ScalingOverride{}
+	j, err := s.redisClient.Get(s.key("scalingOverride")).Result()
+	if err == redis.Nil {
+		// not found
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(j), o)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (s *StatusStore) StoreRebalanceStatus(st *RebalanceStatus) error {
+	j, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redisClient.Set(s.key("rebalanceStatus"), string(j), 0).Result()
+	return err
+}
+
+func (s *StatusStore) FetchRebalanceStatus() (*RebalanceStatus, error) {
+	st := &RebalanceStatus{}
+	j, err := s.redisClient.Get(s.key("rebalanceStatus")).Result()
+	if err == redis.Nil {
+		// not found
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(j), st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
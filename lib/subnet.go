@@ -1,6 +1,114 @@
 package autoscaler
 
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
 type Subnet struct {
 	SubnetID         string `yaml:"SubnetID" validate:"required"`
 	AvailabilityZone string `yaml:"AvailabilityZone" validate:"required"`
 }
+
+// resolveSubnets validates static subnets in config and discovers subnets by SubnetFilters
+func (r *Runner) resolveSubnets(ctx context.Context) error {
+	if r.subnets != nil && r.config.SubnetRefreshInterval != "" {
+		interval, err := time.ParseDuration(r.config.SubnetRefreshInterval)
+		if err != nil {
+			return err
+		}
+		if r.now().Before(r.subnetsResolvedAt.Add(interval)) {
+			return nil
+		}
+	}
+	logf(ctx, "[DEBUG] START: resolveSubnets")
+
+	subnets := []Subnet{}
+	if len(r.config.Subnets) > 0 {
+		ids := []string{}
+		for _, s := range r.config.Subnets {
+			ids = append(ids, s.SubnetID)
+		}
+
+		actual, err := r.ec2Client.DescribeSubnets(ctx, ids, nil)
+		if err != nil {
+			return err
+		}
+
+		azBySubnetID := map[string]string{}
+		for _, s := range actual {
+			azBySubnetID[s.SubnetID] = s.AvailabilityZone
+		}
+
+		for _, s := range r.config.Subnets {
+			az, ok := azBySubnetID[s.SubnetID]
+			if !ok {
+				return fmt.Errorf("subnet %s is not found", s.SubnetID)
+			}
+			if az != s.AvailabilityZone {
+				return fmt.Errorf("subnet %s is in %s, but %s is configured", s.SubnetID, az, s.AvailabilityZone)
+			}
+			subnets = appendSubnetIfMissing(subnets, s)
+		}
+	}
+
+	if len(r.config.SubnetFilters) > 0 {
+		discovered, err := r.ec2Client.DescribeSubnets(ctx, nil, r.config.SubnetFilters)
+		if err != nil {
+			return err
+		}
+		for _, s := range discovered {
+			subnets = appendSubnetIfMissing(subnets, s)
+		}
+	}
+
+	if len(subnets) == 0 {
+		return fmt.Errorf("no subnet is found")
+	}
+
+	r.logVarietyChanges(ctx, r.instanceVarieties(), r.config.InstanceVarietiesInSubnets(subnets))
+	r.subnets = subnets
+	r.subnetsResolvedAt = r.now()
+
+	return nil
+}
+
+// instanceVarieties returns varieties in resolved subnets, or in subnets in config before resolution
+func (r *Runner) instanceVarieties() []InstanceVariety {
+	if r.subnets == nil {
+		return r.config.InstanceVarieties()
+	}
+	return r.config.InstanceVarietiesInSubnets(r.subnets)
+}
+
+func (r *Runner) logVarietyChanges(ctx context.Context, from []InstanceVariety, to []InstanceVariety) {
+	fromSet := map[InstanceVariety]bool{}
+	for _, v := range from {
+		fromSet[v] = true
+	}
+	toSet := map[InstanceVariety]bool{}
+	for _, v := range to {
+		toSet[v] = true
+	}
+
+	for _, v := range to {
+		if !fromSet[v] {
+			logf(ctx, "[INFO] variety is added: %v", v)
+		}
+	}
+	for _, v := range from {
+		if !toSet[v] {
+			logf(ctx, "[INFO] variety is removed: %v", v)
+		}
+	}
+}
+
+func appendSubnetIfMissing(subnets []Subnet, subnet Subnet) []Subnet {
+	for _, s := range subnets {
+		if s.SubnetID == subnet.SubnetID {
+			return subnets
+		}
+	}
+	return append(subnets, subnet)
+}
@@ -0,0 +1,50 @@
+package autoscaler
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func TestResolveSubnets(t *testing.T) {
+	config := configForTest("50")
+	config.SubnetFilters = EC2Filters{{Name: "tag:Role", Values: []string{"app"}}}
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeSubnets", mock.Anything, []string{"subnet-abc"}, EC2Filters(nil)).Return([]Subnet{
+		{SubnetID: "subnet-abc", AvailabilityZone: "ap-northeast-1b"},
+	}, nil)
+	ec2Client.On("DescribeSubnets", mock.Anything, []string(nil), config.SubnetFilters).Return([]Subnet{
+		{SubnetID: "subnet-abc", AvailabilityZone: "ap-northeast-1b"},
+		{SubnetID: "subnet-def", AvailabilityZone: "ap-northeast-1c"},
+	}, nil)
+
+	r := &Runner{
+		config:    config,
+		ec2Client: ec2Client,
+	}
+	err := r.resolveSubnets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Subnet{
+		{SubnetID: "subnet-abc", AvailabilityZone: "ap-northeast-1b"},
+		{SubnetID: "subnet-def", AvailabilityZone: "ap-northeast-1c"},
+	}, r.subnets)
+	assert.Len(t, r.instanceVarieties(), 6)
+}
+
+func TestResolveSubnetsWithWrongAvailabilityZone(t *testing.T) {
+	config := configForTest("50")
+
+	ec2Client := new(MockEC2ClientIface)
+	ec2Client.On("DescribeSubnets", mock.Anything, []string{"subnet-abc"}, EC2Filters(nil)).Return([]Subnet{
+		{SubnetID: "subnet-abc", AvailabilityZone: "ap-northeast-1c"},
+	}, nil)
+
+	r := &Runner{
+		config:    config,
+		ec2Client: ec2Client,
+	}
+	err := r.resolveSubnets(context.Background())
+	assert.Error(t, err)
+}